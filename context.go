@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"context"
+)
+
+// OpenFileContext opens a file using the given flags. If the file system does
+// not implement ContextFS, the context is only checked before calling OpenFile.
+func OpenFileContext(ctx context.Context, fsys FS, path string, flag FileOpenFlag) (File, error) {
+	fsys, path = resolve(fsys, path)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.OpenFileContext(ctx, path, flag)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.OpenFile(path, flag)
+}
+
+// StatContext returns a FileInfo describing the named file. If the file system
+// does not implement ContextFS, the context is only checked before calling Stat.
+func StatContext(ctx context.Context, fsys FS, path string) (FileInfo, error) {
+	fsys, path = resolve(fsys, path)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.StatContext(ctx, path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.Stat(path)
+}
+
+// ReadDirContext reads the named directory. If the file system does not
+// implement ContextFS, the context is only checked before calling ReadDir.
+func ReadDirContext(ctx context.Context, fsys FS, path string) ([]DirEntry, error) {
+	fsys, path = resolve(fsys, path)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.ReadDirContext(ctx, path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.ReadDir(path)
+}
+
+// MkdirAllContext creates a directory named path, along with any necessary
+// parents. If the file system does not implement ContextFS, the context is
+// only checked before calling MkdirAll.
+func MkdirAllContext(ctx context.Context, fsys FS, path string) error {
+	fsys, path = resolve(fsys, path)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.MkdirAllContext(ctx, path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.MkdirAll(path)
+}
+
+// RemoveAllContext removes path and any children it contains. If the file
+// system does not implement ContextFS, the context is only checked before
+// calling RemoveAll.
+func RemoveAllContext(ctx context.Context, fsys FS, path string) error {
+	fsys, path = resolve(fsys, path)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.RemoveAllContext(ctx, path)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.RemoveAll(path)
+}
+
+// RenameContext renames (moves) oldPath to newPath. If the file system does
+// not implement ContextFS, the context is only checked before calling Rename.
+func RenameContext(ctx context.Context, fsys FS, oldPath, newPath string) error {
+	_, newPath = resolve(fsys, newPath)
+	fsys, oldPath = resolve(fsys, oldPath)
+
+	if contextFsys, ok := fsys.(ContextFS); ok {
+		return contextFsys.RenameContext(ctx, oldPath, newPath)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.Rename(oldPath, newPath)
+}
+
+// ReadContext reads up to len(p) bytes from the file. If the file does not
+// implement ContextFile, the context is only checked before calling Read.
+func ReadContext(ctx context.Context, f File, p []byte) (int, error) {
+	if contextFile, ok := f.(ContextFile); ok {
+		return contextFile.ReadContext(ctx, p)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return f.Read(p)
+}
+
+// ReadAtContext reads len(p) bytes from the file starting at offset off. If
+// the file does not implement ContextFile, the context is only checked before
+// calling ReadAt.
+func ReadAtContext(ctx context.Context, f File, p []byte, off int64) (int, error) {
+	if contextFile, ok := f.(ContextFile); ok {
+		return contextFile.ReadAtContext(ctx, p, off)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return f.ReadAt(p, off)
+}
+
+// SyncContext flushes any changes to the file system. If the file does not
+// implement ContextFile, the context is only checked before calling Sync.
+func SyncContext(ctx context.Context, f File) error {
+	if contextFile, ok := f.(ContextFile); ok {
+		return contextFile.SyncContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// CloseContext closes the file. If the file does not implement ContextFile,
+// Close is called regardless of the context (so that the file isn't leaked).
+func CloseContext(ctx context.Context, f File) error {
+	if contextFile, ok := f.(ContextFile); ok {
+		return contextFile.CloseContext(ctx)
+	}
+
+	return f.Close()
+}
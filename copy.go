@@ -0,0 +1,194 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Copy copies the file at srcPath in srcFsys to dstPath in dstFsys, along with
+// its extended attributes. If both paths are on the same file system, and it
+// implements CopyFS, the file contents won't be streamed through the client.
+func Copy(srcFsys FS, srcPath string, dstFsys FS, dstPath string) error {
+	srcFsys, srcPath = resolve(srcFsys, srcPath)
+	dstFsys, dstPath = resolve(dstFsys, dstPath)
+
+	if copyFsys, ok := srcFsys.(CopyFS); ok && sameFS(dstFsys, srcFsys) {
+		return copyFsys.Copy(srcPath, dstPath)
+	}
+
+	return copyFile(srcFsys, srcPath, dstFsys, dstPath)
+}
+
+// CopyAll copies srcPath in srcFsys, and any children it contains, to dstPath
+// in dstFsys. Like Copy, it will use CopyFS where possible.
+func CopyAll(srcFsys FS, srcPath string, dstFsys FS, dstPath string) error {
+	srcFsys, srcPath = resolve(srcFsys, srcPath)
+	dstFsys, dstPath = resolve(dstFsys, dstPath)
+
+	if sameFS(dstFsys, srcFsys) {
+		if copyFsys, ok := srcFsys.(CopyFS); ok {
+			return copyFsys.CopyAll(srcPath, dstPath)
+		}
+
+		cleanSrc, cleanDst := filepath.Clean(srcPath), filepath.Clean(dstPath)
+		if cleanDst == cleanSrc || strings.HasPrefix(cleanDst, cleanSrc+string(filepath.Separator)) || cleanSrc == "." {
+			// Can't copy a directory into itself.
+			return &fs.PathError{Op: "copy", Path: dstPath, Err: ErrInvalid}
+		}
+	}
+
+	return fs.WalkDir(srcFsys, srcPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dstPath, relPath)
+
+		if d.IsDir() {
+			return dstFsys.MkdirAll(target)
+		}
+
+		if d.Type()&ModeSymlink != 0 {
+			err := copySymlink(srcFsys, path, dstFsys, target)
+			if !errors.Is(err, ErrUnsupported) {
+				return err
+			}
+
+			// Otherwise fall back to copying the contents of the link target.
+		}
+
+		return copyFile(srcFsys, path, dstFsys, target)
+	})
+}
+
+// copyFile copies a file by streaming it through the client.
+func copyFile(srcFsys FS, srcPath string, dstFsys FS, dstPath string) error {
+	srcFile, err := srcFsys.OpenFile(srcPath, FlagReadOnly)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	fi, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return fmt.Errorf("cannot copy directory %q: %w", srcPath, ErrInvalid)
+	}
+
+	dstFile, err := dstFsys.OpenFile(dstPath, FlagCreate|FlagTruncate|FlagWriteOnly)
+	if err != nil {
+		return err
+	}
+
+	// Closing can upload the file, so it is only closed once.
+	if err := copyFileData(srcFile, dstFile); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
+
+// copyFileData copies the contents and extended attributes of srcFile to
+// dstFile.
+func copyFileData(srcFile, dstFile File) error {
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return err
+	}
+
+	// Some file systems only support extended attributes on files that have
+	// been persisted.
+	if err := dstFile.Sync(); err != nil {
+		return err
+	}
+
+	return copyXAttrs(srcFile, dstFile)
+}
+
+func copySymlink(srcFsys FS, srcPath string, dstFsys FS, dstPath string) error {
+	linkTarget, err := ReadLink(srcFsys, srcPath)
+	if err != nil {
+		return err
+	}
+
+	return Symlink(dstFsys, linkTarget, dstPath)
+}
+
+func copyXAttrs(srcFile, dstFile File) error {
+	srcXAttrs, err := srcFile.XAttrs()
+	if err != nil {
+		return err
+	}
+
+	names, err := srcXAttrs.List()
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	dstXAttrs, err := dstFile.XAttrs()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := srcXAttrs.Get(name)
+		if err != nil {
+			return err
+		}
+
+		if err := dstXAttrs.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return dstXAttrs.Sync()
+}
+
+// resolve unwraps any sub file systems, returning the underlying file system
+// and the path relative to it.
+func resolve(fsys FS, path string) (FS, string) {
+	for {
+		subFsys, ok := fsys.(*subFS)
+		if !ok {
+			return fsys, path
+		}
+
+		fsys, path = subFsys.parentFsys, filepath.Join(subFsys.prefix, filepath.Clean(path))
+	}
+}
+
+// sameFS reports whether a and b refer to the same file system.
+func sameFS(a, b FS) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
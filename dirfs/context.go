@@ -0,0 +1,101 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"context"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var (
+	_ writablefs.ContextFS   = dirFS{}
+	_ writablefs.ContextFile = (*fileWithXAttrs)(nil)
+)
+
+// Local file system calls can't be interrupted, so the context is only
+// checked before each operation.
+
+func (fsys dirFS) OpenFileContext(ctx context.Context, name string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.OpenFile(name, flag)
+}
+
+func (fsys dirFS) StatContext(ctx context.Context, name string) (writablefs.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.Stat(name)
+}
+
+func (fsys dirFS) ReadDirContext(ctx context.Context, name string) ([]writablefs.DirEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return fsys.ReadDir(name)
+}
+
+func (fsys dirFS) MkdirAllContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.MkdirAll(name)
+}
+
+func (fsys dirFS) RemoveAllContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.RemoveAll(name)
+}
+
+func (fsys dirFS) RenameContext(ctx context.Context, oldName, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return fsys.Rename(oldName, newName)
+}
+
+func (f *fileWithXAttrs) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return f.Read(p)
+}
+
+func (f *fileWithXAttrs) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return f.ReadAt(p, off)
+}
+
+func (f *fileWithXAttrs) SyncContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (f *fileWithXAttrs) CloseContext(ctx context.Context) error {
+	// Always close the file, so it isn't leaked.
+	return f.Close()
+}
@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+func (fsys dirFS) Copy(src, dst string) error {
+	srcPath, err := fsys.safePath(src)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := fsys.safePath(dst)
+	if err != nil {
+		return err
+	}
+
+	return fsys.copyFile(srcPath, dstPath)
+}
+
+func (fsys dirFS) CopyAll(src, dst string) error {
+	srcPath, err := fsys.safePath(src)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := fsys.safePath(dst)
+	if err != nil {
+		return err
+	}
+
+	if dstPath == srcPath || strings.HasPrefix(dstPath, srcPath+string(filepath.Separator)) {
+		// Can't copy a directory into itself.
+		return writablefs.ErrInvalid
+	}
+
+	return filepath.WalkDir(srcPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return err
+		}
+
+		// Sidecar files are copied along with their files.
+		if fsys.isMetadata(path) {
+			return filepath.SkipDir
+		}
+
+		// The destination may already contain symbolic links.
+		targetName := gopath.Join(dst, filepath.ToSlash(relPath))
+
+		var target string
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err = fsys.safeLinkPath(targetName)
+		} else {
+			target, err = fsys.safePath(targetName)
+		}
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if err := os.MkdirAll(target, fsys.dirMode); err != nil {
+				return err
+			}
+
+			return fsys.copyXAttrs(fsys.xattrStore(path), fsys.xattrStore(target))
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(linkTarget, target)
+		}
+
+		return fsys.copyFile(path, target)
+	})
+}
+
+func (fsys dirFS) copyFile(srcPath, dstPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	fi, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return fmt.Errorf("cannot copy directory %q: %w", srcPath, writablefs.ErrInvalid)
+	}
+
+	dstFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err := copyFileContents(dstFile, srcFile); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+
+	if err := fsys.copyXAttrs(fsys.fileXAttrStore(srcFile), fsys.fileXAttrStore(dstFile)); err != nil {
+		_ = dstFile.Close()
+		return err
+	}
+
+	return dstFile.Close()
+}
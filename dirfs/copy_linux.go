@@ -0,0 +1,58 @@
+//go:build linux
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func copyFileContents(dst, src *os.File) error {
+	// On file systems that support it (eg. Btrfs and XFS) the new file can
+	// share the same extents, so no data needs to be copied at all.
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err == nil {
+		return nil
+	}
+
+	// Otherwise have the kernel copy the data, avoiding a round trip through
+	// userspace.
+	var written int64
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, 1<<30, 0)
+		if err != nil {
+			if written == 0 && isCopyFileRangeUnsupported(err) {
+				break
+			}
+
+			return err
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		written += int64(n)
+	}
+
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+func isCopyFileRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"io"
+	"os"
+)
+
+func copyFileContents(dst, src *os.File) error {
+	_, err := io.Copy(dst, src)
+	return err
+}
@@ -11,25 +11,148 @@ package dirfs
 
 import (
 	"archive/tar"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	gopath "path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bucket-sailor/writablefs"
 )
 
-type dirFS string
+var (
+	_ fs.ReadFileFS          = dirFS{}
+	_ writablefs.WriteFileFS = dirFS{}
+)
+
+const (
+	// The maximum number of symbolic links followed when resolving a path.
+	maxSymlinks = 40
+	// The default permission bits of created files and directories.
+	defaultFileMode writablefs.FileMode = 0o644
+	defaultDirMode  writablefs.FileMode = 0o755
+)
+
+type dirFS struct {
+	root string
+	// Store extended attribute names as given (rather than lowercased).
+	preserveXAttrCase bool
+	// The extended attribute namespaces (other than user) that can be accessed.
+	xattrNamespaces []writablefs.XAttrNamespace
+	// If not nil, extended attributes are stored in sidecar files.
+	sidecars *sidecarXAttrs
+	// The permission bits of created files and directories.
+	fileMode writablefs.FileMode
+	dirMode  writablefs.FileMode
+	// Leave symbolic links to the operating system to follow.
+	nativeSymlinks bool
+}
+
+// Options for creating a directory backed file system.
+type Options struct {
+	// PreserveXAttrCase stores extended attribute names as given. By default
+	// names are lowercased, like on backends that are case insensitive (eg.
+	// S3), so that attributes can be copied between them.
+	PreserveXAttrCase bool
+	// XAttrNamespaces are the extended attribute namespaces, other than the
+	// user namespace, that can be accessed using qualified names (eg.
+	// "trusted.foo"). Qualified names in namespaces that aren't enabled fail
+	// with writablefs.ErrUnsupported. Namespaces other than user are only
+	// supported on Linux, and accessing the trusted namespace typically
+	// requires CAP_SYS_ADMIN.
+	XAttrNamespaces []writablefs.XAttrNamespace
+	// XAttrStorage is where extended attributes are stored. By default the
+	// extended attributes of the underlying file system are used, falling back
+	// to sidecar files if it doesn't support them. Sidecar files are stored
+	// in the ".writablefs" directory, which is hidden from listings of the
+	// root directory and can't be accessed through the file system. They
+	// can't be used with namespaces other than the user namespace.
+	XAttrStorage XAttrStorage
+	// FileMode and DirMode are the permission bits (before the umask is
+	// applied) of the files and directories created by OpenFile, WriteFile,
+	// Mkdir and MkdirAll. They default to 0o644 and 0o755. The mode of an
+	// existing file can be changed with writablefs.Chmod().
+	FileMode writablefs.FileMode
+	DirMode  writablefs.FileMode
+	// NativeSymlinks leaves symbolic links to the operating system to follow,
+	// as earlier versions of dirfs did. By default dirfs resolves links itself
+	// so that they can't point outside of the root directory (and absolute
+	// targets are relative to it). Native links can point anywhere on the host
+	// file system, including the directory used internally by dirfs.
+	NativeSymlinks bool
+}
 
 // New returns a writeable file system rooted at the given directory.
 func New(dir string) (writablefs.FS, error) {
+	return NewWithOptions(dir, Options{})
+}
+
+// NewWithOptions returns a writeable file system rooted at the given
+// directory, configured with the given options.
+func NewWithOptions(dir string, opts Options) (writablefs.FS, error) {
 	var err error
 	dir, err = filepath.Abs(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	return dirFS(dir), nil
+	for _, namespace := range opts.XAttrNamespaces {
+		if err := checkXAttrNamespace(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	storage, err := checkXAttrStorage(dir, opts.XAttrStorage, opts.XAttrNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMode, err := createMode(opts.FileMode, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	dirMode, err := createMode(opts.DirMode, defaultDirMode)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := dirFS{
+		root:              dir,
+		preserveXAttrCase: opts.PreserveXAttrCase,
+		xattrNamespaces:   opts.XAttrNamespaces,
+		fileMode:          fileMode,
+		dirMode:           dirMode,
+		nativeSymlinks:    opts.NativeSymlinks,
+	}
+
+	if storage == XAttrStorageSidecar {
+		fsys.sidecars = &sidecarXAttrs{root: dir}
+	}
+
+	// A pointer, as dirFS isn't comparable (so writablefs.Copy() etc. can
+	// tell when paths are on the same file system).
+	return &fsys, nil
+}
+
+// createMode returns the mode to create files or directories with, which can
+// only have permission bits (including the setuid, setgid and sticky bits).
+func createMode(mode, defaultMode writablefs.FileMode) (writablefs.FileMode, error) {
+	if mode == 0 {
+		return defaultMode, nil
+	}
+
+	if mode&^(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) != 0 {
+		return 0, fmt.Errorf("invalid creation mode %v: %w", mode, writablefs.ErrInvalid)
+	}
+
+	return mode, nil
 }
 
 func (fsys dirFS) Close() error {
@@ -37,21 +160,31 @@ func (fsys dirFS) Close() error {
 }
 
 func (fsys dirFS) Open(name string) (writablefs.FileReadOnly, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: writablefs.ErrInvalid}
+	}
+
 	return fsys.OpenFile(name, writablefs.FlagReadOnly)
 }
 
 func (fsys dirFS) OpenFile(name string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
-	path, err := fsys.safePath(name)
+	// Like open(2), exclusive creation doesn't follow a link.
+	resolve := fsys.safePath
+	if flag.IsSet(writablefs.FlagCreate) && flag.IsSet(writablefs.FlagExclusive) {
+		resolve = fsys.safeLinkPath
+	}
+
+	path, err := resolve(name)
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := os.OpenFile(path, int(flag), 0o644)
+	f, err := os.OpenFile(path, int(flag), fsys.fileMode)
 	if err != nil {
 		return nil, err
 	}
 
-	return &fileWithXAttrs{f}, nil
+	return &fileWithXAttrs{File: f, fsys: fsys, name: name}, nil
 }
 
 func (fsys dirFS) MkdirAll(name string) error {
@@ -60,7 +193,16 @@ func (fsys dirFS) MkdirAll(name string) error {
 		return err
 	}
 
-	return os.MkdirAll(path, 0o755)
+	return os.MkdirAll(path, fsys.dirMode)
+}
+
+func (fsys dirFS) Mkdir(name string) error {
+	path, err := fsys.safeLinkPath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Mkdir(path, fsys.dirMode)
 }
 
 func (fsys dirFS) ReadDir(name string) ([]writablefs.DirEntry, error) {
@@ -69,30 +211,94 @@ func (fsys dirFS) ReadDir(name string) ([]writablefs.DirEntry, error) {
 		return nil, err
 	}
 
-	return os.ReadDir(path)
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsys.hideMetadata(path, entries), nil
 }
 
-func (fsys dirFS) RemoveAll(name string) error {
+func (fsys dirFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: writablefs.ErrInvalid}
+	}
+
 	path, err := fsys.safePath(name)
 	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+func (fsys dirFS) WriteFile(name string, data []byte) error {
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, fsys.fileMode)
+}
+
+func (fsys dirFS) RemoveAll(name string) error {
+	path, err := fsys.safeLinkPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	if fsys.sidecars != nil {
+		return fsys.sidecars.remove(path)
+	}
+
+	return nil
+}
+
+func (fsys dirFS) Remove(name string) error {
+	path, err := fsys.safeLinkPath(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if isNotEmpty(err) {
+			return &fs.PathError{Op: "remove", Path: name, Err: writablefs.ErrNotEmpty}
+		}
+
 		return err
 	}
 
-	return os.RemoveAll(path)
+	if fsys.sidecars != nil {
+		return fsys.sidecars.remove(path)
+	}
+
+	return nil
 }
 
 func (fsys dirFS) Rename(oldName, newName string) error {
-	oldPath, err := fsys.safePath(oldName)
+	oldPath, err := fsys.safeLinkPath(oldName)
 	if err != nil {
 		return err
 	}
 
-	newPath, err := fsys.safePath(newName)
+	newPath, err := fsys.safeLinkPath(newName)
 	if err != nil {
 		return err
 	}
 
-	return os.Rename(oldPath, newPath)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	if fsys.sidecars != nil {
+		return fsys.sidecars.rename(oldPath, newPath)
+	}
+
+	return nil
 }
 
 func (fsys dirFS) Stat(name string) (writablefs.FileInfo, error) {
@@ -101,7 +307,66 @@ func (fsys dirFS) Stat(name string) (writablefs.FileInfo, error) {
 		return nil, err
 	}
 
-	return os.Stat(path)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return linkFileInfo(fi, name), nil
+}
+
+func (fsys dirFS) Symlink(oldName, newName string) error {
+	newPath, err := fsys.safeLinkPath(newName)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(oldName, newPath)
+}
+
+func (fsys dirFS) ReadLink(name string) (string, error) {
+	path, err := fsys.safeLinkPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	return os.Readlink(path)
+}
+
+func (fsys dirFS) Lstat(name string) (writablefs.FileInfo, error) {
+	path, err := fsys.safeLinkPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(path)
+}
+
+func (fsys dirFS) Chmod(name string, mode writablefs.FileMode) error {
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(path, mode)
+}
+
+func (fsys dirFS) Chown(name string, uid, gid int) error {
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chown(path, uid, gid)
+}
+
+func (fsys dirFS) Chtimes(name string, atime, mtime time.Time) error {
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, atime, mtime)
 }
 
 func (fsys dirFS) Archive(name string) (io.ReadCloser, error) {
@@ -127,7 +392,19 @@ func (fsys dirFS) Archive(name string) (io.ReadCloser, error) {
 				return nil
 			}
 
-			header, err := tar.FileInfoHeader(fi, "")
+			if fsys.isMetadata(file) {
+				return filepath.SkipDir
+			}
+
+			var link string
+			if fi.Mode()&os.ModeSymlink != 0 {
+				link, err = os.Readlink(file)
+				if err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(fi, link)
 			if err != nil {
 				return err
 			}
@@ -141,7 +418,7 @@ func (fsys dirFS) Archive(name string) (io.ReadCloser, error) {
 				return err
 			}
 
-			if !fi.IsDir() {
+			if fi.Mode().IsRegular() {
 				f, err := os.Open(file)
 				if err != nil {
 					return err
@@ -163,23 +440,167 @@ func (fsys dirFS) Archive(name string) (io.ReadCloser, error) {
 	return pr, nil
 }
 
-func (fsys dirFS) safePath(path string) (string, error) {
-	absPath, err := filepath.Abs(filepath.Join(string(fsys), path))
-	if err != nil {
-		return "", err
-	}
+// safePath returns the path on the host of the named file, following any
+// symbolic links. Links are resolved within the root directory, absolute
+// link targets are relative to it (like on s3fs), and links pointing outside
+// of it don't exist.
+func (fsys dirFS) safePath(name string) (string, error) {
+	return fsys.resolvePath(name, true)
+}
+
+// safeLinkPath is like safePath, but a symbolic link in the final element of
+// the name isn't followed (eg. so that it can be removed).
+func (fsys dirFS) safeLinkPath(name string) (string, error) {
+	return fsys.resolvePath(name, false)
+}
 
-	if !strings.HasPrefix(absPath, string(fsys)) {
+func (fsys dirFS) resolvePath(name string, followLast bool) (string, error) {
+	name = gopath.Clean(filepath.ToSlash(name))
+	if name == ".." || strings.HasPrefix(name, "../") {
 		return "", writablefs.ErrPermission
 	}
 
-	return absPath, nil
+	if fsys.nativeSymlinks {
+		if first, _, _ := strings.Cut(strings.TrimPrefix(name, "/"), "/"); fsys.isMetadata(filepath.Join(fsys.root, first)) {
+			return "", &fs.PathError{Op: "open", Path: name, Err: writablefs.ErrPermission}
+		}
+
+		return filepath.Join(fsys.root, filepath.FromSlash(name)), nil
+	}
+
+	// The elements that have been resolved so far (none of which are links).
+	var resolved []string
+	pending := strings.Split(name, "/")
+	links := 0
+
+	for len(pending) > 0 {
+		elem := pending[0]
+		pending = pending[1:]
+
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", fmt.Errorf("symbolic link in %q points outside of the file system: %w", name, writablefs.ErrNotExist)
+			}
+
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		resolved = append(resolved, elem)
+
+		// The data used internally by dirfs can't be accessed.
+		if len(resolved) == 1 && fsys.isMetadata(filepath.Join(fsys.root, elem)) {
+			return "", &fs.PathError{Op: "open", Path: name, Err: writablefs.ErrPermission}
+		}
+
+		if len(pending) == 0 && !followLast {
+			break
+		}
+
+		path := filepath.Join(fsys.root, filepath.Join(resolved...))
+
+		fi, err := os.Lstat(path)
+		if err != nil {
+			// Something that doesn't exist (yet) isn't a link.
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				continue
+			}
+
+			return "", err
+		}
+
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: syscall.ELOOP}
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+
+		resolved = resolved[:len(resolved)-1]
+
+		if filepath.IsAbs(target) || strings.HasPrefix(filepath.ToSlash(target), "/") {
+			target = strings.TrimPrefix(target, filepath.VolumeName(target))
+			resolved = nil
+		}
+
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+
+	return filepath.Join(fsys.root, filepath.Join(resolved...)), nil
 }
 
 type fileWithXAttrs struct {
 	*os.File
+	fsys dirFS
+	// The name the file was opened with.
+	name string
+}
+
+func (f *fileWithXAttrs) Stat() (writablefs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return linkFileInfo(fi, f.name), nil
 }
 
 func (f *fileWithXAttrs) XAttrs() (writablefs.ExtendedAttributes, error) {
-	return &fileAttrs{f.File}, nil
+	return &fileAttrs{File: f.File, fsys: f.fsys, store: f.fsys.fileXAttrStore(f.File)}, nil
+}
+
+// namedFileInfo is the FileInfo of the target of a symbolic link, named after
+// the link.
+type namedFileInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string {
+	return fi.name
+}
+
+// linkFileInfo names the FileInfo of the file found by following any symbolic
+// links in name after the final element of name (as os.Stat does).
+func linkFileInfo(fi fs.FileInfo, name string) fs.FileInfo {
+	base := gopath.Base(gopath.Clean("/" + filepath.ToSlash(name)))
+	if base == "/" || fi.Name() == base {
+		return fi
+	}
+
+	return &namedFileInfo{FileInfo: fi, name: base}
+}
+
+// isNotEmpty reports whether the error is due to a directory not being empty.
+func isNotEmpty(err error) bool {
+	// Some platforms return EEXIST rather than ENOTEMPTY.
+	return errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST)
+}
+
+// isMetadata reports whether path is the directory holding the data used
+// internally by dirfs.
+func (fsys dirFS) isMetadata(path string) bool {
+	return fsys.sidecars != nil && path == filepath.Join(fsys.root, metadataDir)
+}
+
+// hideMetadata removes the directory holding the data used internally by
+// dirfs from the entries of the directory at path.
+func (fsys dirFS) hideMetadata(path string, entries []writablefs.DirEntry) []writablefs.DirEntry {
+	if fsys.sidecars == nil || path != fsys.root {
+		return entries
+	}
+
+	return slices.DeleteFunc(entries, func(entry writablefs.DirEntry) bool {
+		return entry.Name() == metadataDir
+	})
 }
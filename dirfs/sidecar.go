@@ -0,0 +1,303 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+const (
+	// The directory (in the root directory) that holds the data used
+	// internally by dirfs. It is hidden from listings, and can't be accessed
+	// through the file system.
+	metadataDir = ".writablefs"
+	// Sidecar files are stored under this directory, in a tree that mirrors
+	// the directories they belong to. The sidecar of a file "name" is named
+	// "f.name", the mirror of a directory "name" is named "d.name" (so the
+	// names of sidecars can't collide with the names of mirrored directories)
+	// and the sidecar of the root directory is "root".
+	sidecarDir = metadataDir + "/xattrs"
+)
+
+// sidecarXAttrs stores extended attributes in sidecar files, as a JSON
+// object of qualified names to values.
+type sidecarXAttrs struct {
+	root string
+	// Serializes updates of sidecar files (they are read, modified and then
+	// replaced).
+	mu sync.Mutex
+}
+
+func (s *sidecarXAttrs) store(path string) xattrStore {
+	return sidecarXAttrStore{s: s, path: path}
+}
+
+// sidecarXAttrStore is the extended attributes of the file at path.
+type sidecarXAttrStore struct {
+	s    *sidecarXAttrs
+	path string
+}
+
+func (st sidecarXAttrStore) get(name string) ([]byte, error) {
+	xattrs, err := st.s.load(st.path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := xattrs[name]
+	if !ok {
+		return nil, writablefs.ErrNoSuchAttr
+	}
+
+	return data, nil
+}
+
+func (st sidecarXAttrStore) set(name string, data []byte) error {
+	return st.s.update(st.path, func(xattrs map[string][]byte) bool {
+		xattrs[name] = data
+		return true
+	})
+}
+
+func (st sidecarXAttrStore) remove(name string) error {
+	return st.s.update(st.path, func(xattrs map[string][]byte) bool {
+		if _, ok := xattrs[name]; !ok {
+			return false
+		}
+
+		delete(xattrs, name)
+		return true
+	})
+}
+
+func (st sidecarXAttrStore) list() ([]string, error) {
+	xattrs, err := st.s.load(st.path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// load returns the extended attributes of the file at path.
+func (s *sidecarXAttrs) load(path string) (map[string][]byte, error) {
+	// Like native extended attributes, the file must exist.
+	if _, err := os.Lstat(path); err != nil {
+		return nil, err
+	}
+
+	return readSidecar(s.sidecarPath(path))
+}
+
+// update calls fn with the extended attributes of the file at path, and if
+// it returns true, replaces the sidecar file with the modified attributes.
+func (s *sidecarXAttrs) update(path string, fn func(xattrs map[string][]byte) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+
+	sidecarPath := s.sidecarPath(path)
+
+	xattrs, err := readSidecar(sidecarPath)
+	if err != nil {
+		return err
+	}
+
+	if !fn(xattrs) {
+		return nil
+	}
+
+	return writeSidecar(sidecarPath, xattrs)
+}
+
+// rename moves the sidecar file of oldPath (and of anything in it, if it is
+// a directory) to newPath, once the file itself has been renamed.
+func (s *sidecarXAttrs) rename(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if oldPath == newPath {
+		return nil
+	}
+
+	moves := [][2]string{
+		{s.sidecarPath(oldPath), s.sidecarPath(newPath)},
+		{s.mirrorPath(oldPath), s.mirrorPath(newPath)},
+	}
+
+	for _, move := range moves {
+		// Whatever was replaced had its attributes replaced too.
+		if err := os.RemoveAll(move[1]); err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(move[0]); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(move[1]), 0o755); err != nil {
+			return err
+		}
+
+		if err := os.Rename(move[0], move[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove removes the sidecar file of path (and of anything in it, if it is a
+// directory), once the file itself has been removed.
+func (s *sidecarXAttrs) remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.RemoveAll(s.sidecarPath(path)); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(s.mirrorPath(path))
+}
+
+// sidecarPath returns the path of the sidecar file of the file at path, eg.
+// ".writablefs/xattrs/d.dir/f.name" for "dir/name".
+func (s *sidecarXAttrs) sidecarPath(path string) string {
+	elems := s.relElems(path)
+	if len(elems) == 0 {
+		return filepath.Join(s.root, filepath.FromSlash(sidecarDir), "root")
+	}
+
+	return s.mirrorPath(filepath.Join(s.root, filepath.Join(elems[:len(elems)-1]...)), "f."+elems[len(elems)-1])
+}
+
+// mirrorPath returns the path of the directory holding the sidecar files of
+// the contents of the directory at path, eg. ".writablefs/xattrs/d.dir" for
+// "dir". Any extra elements are appended to it.
+func (s *sidecarXAttrs) mirrorPath(path string, elem ...string) string {
+	mirror := []string{s.root, filepath.FromSlash(sidecarDir)}
+	for _, e := range s.relElems(path) {
+		mirror = append(mirror, "d."+e)
+	}
+
+	return filepath.Join(append(mirror, elem...)...)
+}
+
+// relElems returns the elements of path relative to the root directory.
+func (s *sidecarXAttrs) relElems(path string) []string {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	return strings.Split(filepath.ToSlash(rel), "/")
+}
+
+func readSidecar(sidecarPath string) (map[string][]byte, error) {
+	xattrs := make(map[string][]byte)
+
+	data, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return xattrs, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &xattrs); err != nil {
+		return nil, fmt.Errorf("invalid extended attributes sidecar %q: %w", sidecarPath, err)
+	}
+
+	return xattrs, nil
+}
+
+// writeSidecar atomically replaces the sidecar file (so that readers never
+// see a partially written file), or removes it if there are no attributes.
+func writeSidecar(sidecarPath string, xattrs map[string][]byte) error {
+	if len(xattrs) == 0 {
+		return removeSidecar(sidecarPath)
+	}
+
+	data, err := json.Marshal(xattrs)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(sidecarPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// Named so that it can't collide with other sidecar files.
+	f, err := os.CreateTemp(dir, "t.*")
+	if err != nil {
+		return err
+	}
+
+	if err := writeSidecarFile(f, data); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), sidecarPath); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+func writeSidecarFile(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	// Temporary files are only readable by their owner.
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func removeSidecar(sidecarPath string) error {
+	if err := os.Remove(sidecarPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
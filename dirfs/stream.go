@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var _ writablefs.ReadDirStreamFS = dirFS{}
+
+// ReadDirStream lists the directory in name order. The names of its entries
+// are read up front (sorting them needs every name anyway), but their status
+// is only read as the listing is consumed. The token is the name of the last
+// returned entry, so a listing resumed after the directory has been modified
+// doesn't skip or repeat any entries that were there all along.
+func (fsys dirFS) ReadDirStream(ctx context.Context, name, token string) (writablefs.DirStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return fsys.isMetadata(filepath.Join(path, name))
+	})
+
+	sort.Strings(names)
+
+	// Resume after the last returned entry.
+	if token != "" {
+		names = names[sort.SearchStrings(names, token):]
+		if len(names) > 0 && names[0] == token {
+			names = names[1:]
+		}
+	}
+
+	return &dirStream{ctx: ctx, name: name, path: path, names: names}, nil
+}
+
+// dirStream is a listing of a local directory.
+type dirStream struct {
+	mu   sync.Mutex
+	ctx  context.Context
+	name string
+	path string
+	// The names of the entries that haven't been returned yet (sorted).
+	names []string
+	// The name of the last returned entry.
+	token  string
+	closed bool
+}
+
+func (s *dirStream) Next(n int) ([]writablefs.DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: s.name, Err: writablefs.ErrClosed}
+	}
+
+	var entries []writablefs.DirEntry
+	for len(s.names) > 0 && (n <= 0 || len(entries) < n) {
+		if err := s.ctx.Err(); err != nil {
+			return entries, err
+		}
+
+		fi, err := os.Lstat(filepath.Join(s.path, s.names[0]))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return entries, err
+		}
+
+		s.token = s.names[0]
+		s.names = s.names[1:]
+
+		// Skip entries that have been removed since the listing was opened.
+		if err == nil {
+			entries = append(entries, fs.FileInfoToDirEntry(fi))
+		}
+	}
+
+	if n > 0 && len(entries) == 0 {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	return entries, nil
+}
+
+func (s *dirStream) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+func (s *dirStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.names = nil
+
+	return nil
+}
@@ -0,0 +1,284 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var _ writablefs.XAttrFS = dirFS{}
+
+// XAttrStorage is where the extended attributes of files are stored.
+type XAttrStorage int
+
+const (
+	// XAttrStorageAuto uses the extended attributes of the underlying file
+	// system, unless it doesn't support them (eg. some tmpfs, overlayfs and
+	// NFS mounts, or non-unix platforms), in which case sidecar files are used.
+	XAttrStorageAuto XAttrStorage = iota
+	// XAttrStorageNative uses the extended attributes of the underlying file
+	// system.
+	XAttrStorageNative
+	// XAttrStorageSidecar stores the extended attributes of each file in a
+	// sidecar file, under the hidden ".writablefs" directory in the root
+	// directory.
+	XAttrStorageSidecar
+)
+
+// xattrStore reads and writes the extended attributes of a file, using
+// qualified names (eg. "user.foo").
+type xattrStore interface {
+	// get returns writablefs.ErrNoSuchAttr if the attribute doesn't exist.
+	get(name string) ([]byte, error)
+	set(name string, data []byte) error
+	// remove does nothing if the attribute doesn't exist.
+	remove(name string) error
+	list() ([]string, error)
+}
+
+func (fsys dirFS) GetXAttr(name, attr string) ([]byte, error) {
+	store, err := fsys.pathXAttrStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	qualifiedName, err := fsys.xattrName(attr)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.get(qualifiedName)
+}
+
+func (fsys dirFS) SetXAttr(name, attr string, data []byte) error {
+	store, err := fsys.pathXAttrStore(name)
+	if err != nil {
+		return err
+	}
+
+	qualifiedName, err := fsys.xattrName(attr)
+	if err != nil {
+		return err
+	}
+
+	return store.set(qualifiedName, data)
+}
+
+func (fsys dirFS) RemoveXAttr(name, attr string) error {
+	store, err := fsys.pathXAttrStore(name)
+	if err != nil {
+		return err
+	}
+
+	qualifiedName, err := fsys.xattrName(attr)
+	if err != nil {
+		return err
+	}
+
+	return store.remove(qualifiedName)
+}
+
+func (fsys dirFS) ListXAttrs(name string) ([]string, error) {
+	store, err := fsys.pathXAttrStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	return fsys.xattrNames(names), nil
+}
+
+// pathXAttrStore returns the extended attributes of the named file.
+func (fsys dirFS) pathXAttrStore(name string) (xattrStore, error) {
+	path, err := fsys.safePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fsys.xattrStore(path), nil
+}
+
+func (fsys dirFS) xattrStore(path string) xattrStore {
+	if fsys.sidecars != nil {
+		return fsys.sidecars.store(path)
+	}
+
+	return newNativeXAttrStore(path)
+}
+
+func (fsys dirFS) fileXAttrStore(f *os.File) xattrStore {
+	if fsys.sidecars != nil {
+		return fsys.sidecars.store(f.Name())
+	}
+
+	return newNativeFileXAttrStore(f)
+}
+
+type fileAttrs struct {
+	*os.File
+	fsys  dirFS
+	store xattrStore
+}
+
+func (a *fileAttrs) Get(name string) ([]byte, error) {
+	qualifiedName, err := a.fsys.xattrName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.store.get(qualifiedName)
+}
+
+func (a *fileAttrs) Set(name string, data []byte) error {
+	qualifiedName, err := a.fsys.xattrName(name)
+	if err != nil {
+		return err
+	}
+
+	return a.store.set(qualifiedName, data)
+}
+
+func (a *fileAttrs) Remove(name string) error {
+	qualifiedName, err := a.fsys.xattrName(name)
+	if err != nil {
+		return err
+	}
+
+	return a.store.remove(qualifiedName)
+}
+
+func (a *fileAttrs) List() ([]string, error) {
+	names, err := a.store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.fsys.xattrNames(names), nil
+}
+
+// xattrName returns the qualified name of the extended attribute, eg. "foo"
+// is "user.foo", and (if the trusted namespace is enabled) "trusted.foo" is
+// "trusted.foo". Names in namespaces that aren't enabled are unsupported
+// (rather than being stored in the user namespace, where they would be
+// mistaken for attributes in that namespace if it was enabled later).
+func (fsys dirFS) xattrName(name string) (string, error) {
+	if !fsys.preserveXAttrCase {
+		name = strings.ToLower(name)
+	}
+
+	namespace, attr := writablefs.SplitXAttrName(name)
+	if !fsys.hasXAttrNamespace(namespace) {
+		return "", fmt.Errorf("extended attribute namespace %q is not enabled: %w", namespace, writablefs.ErrUnsupported)
+	}
+
+	return string(namespace) + "." + attr, nil
+}
+
+// xattrNames returns the names of the extended attributes (from their
+// qualified names) in the namespaces that can be accessed.
+func (fsys dirFS) xattrNames(names []string) []string {
+	var attrNames []string
+	for _, name := range names {
+		namespace, attr, ok := strings.Cut(name, ".")
+		if !ok || !fsys.hasXAttrNamespace(writablefs.XAttrNamespace(namespace)) {
+			continue
+		}
+
+		// User attributes that look like they are in another namespace (eg.
+		// "user.trusted.foo", set by another program) can't be accessed.
+		if attrNamespace, _ := writablefs.SplitXAttrName(attr); namespace == string(writablefs.XAttrNamespaceUser) && attrNamespace != writablefs.XAttrNamespaceUser {
+			continue
+		}
+
+		name = writablefs.XAttrName(writablefs.XAttrNamespace(namespace), attr)
+		if !fsys.preserveXAttrCase {
+			name = strings.ToLower(name)
+		}
+
+		attrNames = append(attrNames, name)
+	}
+
+	return attrNames
+}
+
+func (fsys dirFS) hasXAttrNamespace(namespace writablefs.XAttrNamespace) bool {
+	return namespace == writablefs.XAttrNamespaceUser || slices.Contains(fsys.xattrNamespaces, namespace)
+}
+
+// copyXAttrs copies the extended attributes of src to dst, in the namespaces
+// that can be accessed.
+func (fsys dirFS) copyXAttrs(src, dst xattrStore) error {
+	names, err := src.list()
+	if err != nil {
+		// Nothing to copy if the file system doesn't support extended attributes.
+		if isXAttrUnsupported(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, name := range names {
+		namespace, _, ok := strings.Cut(name, ".")
+		if !ok || !fsys.hasXAttrNamespace(writablefs.XAttrNamespace(namespace)) {
+			continue
+		}
+
+		data, err := src.get(name)
+		if err != nil {
+			return err
+		}
+
+		if err := dst.set(name, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkXAttrStorage checks the extended attribute storage is supported (with
+// the given namespaces), and returns the storage to use for the directory.
+func checkXAttrStorage(dir string, storage XAttrStorage, namespaces []writablefs.XAttrNamespace) (XAttrStorage, error) {
+	switch storage {
+	case XAttrStorageAuto:
+		storage = XAttrStorageNative
+		if !supportsXAttrs(dir) {
+			storage = XAttrStorageSidecar
+		}
+	case XAttrStorageNative:
+		if !supportsXAttrs(dir) {
+			return storage, fmt.Errorf("native extended attributes: %w", writablefs.ErrUnsupported)
+		}
+	case XAttrStorageSidecar:
+	default:
+		return storage, fmt.Errorf("unknown extended attribute storage %d: %w", storage, writablefs.ErrInvalid)
+	}
+
+	// Sidecar files can't enforce the privileges required by other namespaces.
+	if storage == XAttrStorageSidecar {
+		for _, namespace := range namespaces {
+			if namespace != writablefs.XAttrNamespaceUser {
+				return storage, fmt.Errorf("extended attribute namespace %q with sidecar storage: %w", namespace, writablefs.ErrUnsupported)
+			}
+		}
+	}
+
+	return storage, nil
+}
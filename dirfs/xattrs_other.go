@@ -0,0 +1,69 @@
+//go:build !unix
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package dirfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+// Extended attributes are only supported natively on unix platforms,
+// elsewhere sidecar files are used.
+
+func newNativeXAttrStore(path string) xattrStore {
+	return unsupportedXAttrStore{}
+}
+
+func newNativeFileXAttrStore(f *os.File) xattrStore {
+	return unsupportedXAttrStore{}
+}
+
+type unsupportedXAttrStore struct{}
+
+func (unsupportedXAttrStore) get(name string) ([]byte, error) {
+	return nil, writablefs.ErrUnsupported
+}
+
+func (unsupportedXAttrStore) set(name string, data []byte) error {
+	return writablefs.ErrUnsupported
+}
+
+func (unsupportedXAttrStore) remove(name string) error {
+	return writablefs.ErrUnsupported
+}
+
+func (unsupportedXAttrStore) list() ([]string, error) {
+	return nil, writablefs.ErrUnsupported
+}
+
+func supportsXAttrs(path string) bool {
+	return false
+}
+
+func isXAttrUnsupported(err error) bool {
+	return errors.Is(err, writablefs.ErrUnsupported)
+}
+
+// checkXAttrNamespace checks the extended attribute namespace is supported.
+func checkXAttrNamespace(namespace writablefs.XAttrNamespace) error {
+	switch namespace {
+	case writablefs.XAttrNamespaceUser:
+		return nil
+	case writablefs.XAttrNamespaceTrusted, writablefs.XAttrNamespaceSecurity, writablefs.XAttrNamespaceSystem:
+		return fmt.Errorf("extended attribute namespace %q: %w", namespace, writablefs.ErrUnsupported)
+	default:
+		return fmt.Errorf("unknown extended attribute namespace %q: %w", namespace, writablefs.ErrInvalid)
+	}
+}
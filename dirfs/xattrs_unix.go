@@ -13,19 +13,26 @@ package dirfs
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-	"strings"
+	"path/filepath"
+	"runtime"
+	"syscall"
 
 	"github.com/bucket-sailor/writablefs"
 	"github.com/pkg/xattr"
 )
 
-type fileAttrs struct {
-	*os.File
+// nativeXAttrStore uses the extended attributes of the underlying file system.
+type nativeXAttrStore string
+
+func newNativeXAttrStore(path string) xattrStore {
+	return nativeXAttrStore(path)
 }
 
-func (a *fileAttrs) Get(name string) ([]byte, error) {
-	data, err := xattr.FGet(a.File, "user."+strings.ToLower(name))
+func (s nativeXAttrStore) get(name string) ([]byte, error) {
+	data, err := xattr.Get(string(s), name)
 	if err != nil {
 		if errors.Is(err, xattr.ENOATTR) {
 			return nil, writablefs.ErrNoSuchAttr
@@ -37,12 +44,12 @@ func (a *fileAttrs) Get(name string) ([]byte, error) {
 	return data, nil
 }
 
-func (a *fileAttrs) Set(name string, data []byte) error {
-	return xattr.FSet(a.File, "user."+strings.ToLower(name), data)
+func (s nativeXAttrStore) set(name string, data []byte) error {
+	return xattr.Set(string(s), name, data)
 }
 
-func (a *fileAttrs) Remove(name string) error {
-	if err := xattr.FRemove(a.File, "user."+strings.ToLower(name)); err != nil {
+func (s nativeXAttrStore) remove(name string) error {
+	if err := xattr.Remove(string(s), name); err != nil {
 		if errors.Is(err, xattr.ENOATTR) {
 			return nil
 		}
@@ -53,18 +60,85 @@ func (a *fileAttrs) Remove(name string) error {
 	return nil
 }
 
-func (a *fileAttrs) List() ([]string, error) {
-	names, err := xattr.FList(a.File)
+func (s nativeXAttrStore) list() ([]string, error) {
+	return xattr.List(string(s))
+}
+
+// nativeFileXAttrStore uses the extended attributes of an open file.
+type nativeFileXAttrStore struct {
+	f *os.File
+}
+
+func newNativeFileXAttrStore(f *os.File) xattrStore {
+	return nativeFileXAttrStore{f: f}
+}
+
+func (s nativeFileXAttrStore) get(name string) ([]byte, error) {
+	data, err := xattr.FGet(s.f, name)
 	if err != nil {
+		if errors.Is(err, xattr.ENOATTR) {
+			return nil, writablefs.ErrNoSuchAttr
+		}
+
 		return nil, err
 	}
 
-	var userAttrNames []string
-	for _, name := range names {
-		if strings.HasPrefix(name, "user.") {
-			userAttrNames = append(userAttrNames, strings.ToLower(strings.TrimPrefix(name, "user.")))
+	return data, nil
+}
+
+func (s nativeFileXAttrStore) set(name string, data []byte) error {
+	return xattr.FSet(s.f, name, data)
+}
+
+func (s nativeFileXAttrStore) remove(name string) error {
+	if err := xattr.FRemove(s.f, name); err != nil {
+		if errors.Is(err, xattr.ENOATTR) {
+			return nil
 		}
+
+		return err
 	}
 
-	return userAttrNames, nil
+	return nil
+}
+
+func (s nativeFileXAttrStore) list() ([]string, error) {
+	return xattr.FList(s.f)
+}
+
+// supportsXAttrs reports whether the file system containing path supports
+// user extended attributes (if path doesn't exist yet, its nearest existing
+// parent is checked instead).
+func supportsXAttrs(path string) bool {
+	for {
+		_, err := xattr.Get(path, "user.writablefs-probe")
+		if errors.Is(err, fs.ErrNotExist) && filepath.Dir(path) != path {
+			path = filepath.Dir(path)
+			continue
+		}
+
+		return !isXAttrUnsupported(err)
+	}
+}
+
+func isXAttrUnsupported(err error) bool {
+	// These are distinct on some platforms (eg. macOS).
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// checkXAttrNamespace checks the extended attribute namespace is supported.
+func checkXAttrNamespace(namespace writablefs.XAttrNamespace) error {
+	switch namespace {
+	case writablefs.XAttrNamespaceUser:
+		return nil
+	case writablefs.XAttrNamespaceTrusted, writablefs.XAttrNamespaceSecurity, writablefs.XAttrNamespaceSystem:
+		// Other platforms only have a user namespace (or don't use prefixes).
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("extended attribute namespace %q: %w", namespace, writablefs.ErrUnsupported)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown extended attribute namespace %q: %w", namespace, writablefs.ErrInvalid)
+	}
 }
@@ -0,0 +1,53 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"io/fs"
+)
+
+// ReadFile reads the named file and returns its contents. If the file system
+// implements fs.ReadFileFS, its ReadFile method is used, otherwise the file is
+// opened and read.
+func ReadFile(fsys FS, path string) ([]byte, error) {
+	fsys, path = resolve(fsys, path)
+
+	return fs.ReadFile(fsys, path)
+}
+
+// WriteFile writes data to the named file, creating it if necessary, and
+// replacing any existing contents. If the file system does not implement
+// WriteFileFS, the file is opened with FlagCreate|FlagTruncate and written.
+func WriteFile(fsys FS, path string, data []byte) error {
+	fsys, path = resolve(fsys, path)
+
+	if writeFileFsys, ok := fsys.(WriteFileFS); ok {
+		return writeFileFsys.WriteFile(path, data)
+	}
+
+	f, err := fsys.OpenFile(path, FlagCreate|FlagTruncate|FlagWriteOnly)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Glob returns the names of all files matching pattern (using the syntax of
+// path.Match). If the file system implements fs.GlobFS, its Glob method is
+// used, otherwise the matching directories are listed with ReadDir.
+func Glob(fsys FS, pattern string) ([]string, error) {
+	return fs.Glob(fsys, pattern)
+}
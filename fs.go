@@ -10,26 +10,37 @@
 package writablefs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	gofs "io/fs"
 	"os"
+	"time"
 )
 
 var (
-	ErrInvalid    = gofs.ErrInvalid                 // "invalid argument"
-	ErrPermission = gofs.ErrPermission              // "permission denied"
-	ErrExist      = gofs.ErrExist                   // "file already exists"
-	ErrNotExist   = gofs.ErrNotExist                // "file does not exist"
-	ErrClosed     = gofs.ErrClosed                  // "file already closed"
-	ErrNoSuchAttr = fmt.Errorf("no such attribute") // "no such attribute"
+	ErrInvalid     = gofs.ErrInvalid                              // "invalid argument"
+	ErrPermission  = gofs.ErrPermission                           // "permission denied"
+	ErrExist       = gofs.ErrExist                                // "file already exists"
+	ErrNotExist    = gofs.ErrNotExist                             // "file does not exist"
+	ErrClosed      = gofs.ErrClosed                               // "file already closed"
+	ErrNoSuchAttr  = fmt.Errorf("no such attribute")              // "no such attribute"
+	ErrConflict    = fmt.Errorf("file was modified concurrently") // "file was modified concurrently"
+	ErrUnsupported = errors.ErrUnsupported                        // "unsupported operation"
+	ErrNotEmpty    = fmt.Errorf("directory not empty")            // "directory not empty"
 )
 
 type FileMode = gofs.FileMode
 
 const (
-	ModeDir  = gofs.ModeDir
-	ModePerm = gofs.ModePerm
+	ModeDir     = gofs.ModeDir
+	ModeSymlink = gofs.ModeSymlink
+	ModeSetuid  = gofs.ModeSetuid
+	ModeSetgid  = gofs.ModeSetgid
+	ModeSticky  = gofs.ModeSticky
+	ModeType    = gofs.ModeType
+	ModePerm    = gofs.ModePerm
 )
 
 type DirEntry = gofs.DirEntry
@@ -39,14 +50,23 @@ type FileInfo = gofs.FileInfo
 type FileOpenFlag int
 
 const (
-	FlagReadOnly  = FileOpenFlag(os.O_RDONLY)
-	FlagWriteOnly = FileOpenFlag(os.O_WRONLY)
-	FlagReadWrite = FileOpenFlag(os.O_RDWR)
-	FlagCreate    = FileOpenFlag(os.O_CREATE)
+	FlagReadOnly  = FileOpenFlag(os.O_RDONLY) // open the file read-only.
+	FlagWriteOnly = FileOpenFlag(os.O_WRONLY) // open the file write-only.
+	FlagReadWrite = FileOpenFlag(os.O_RDWR)   // open the file read-write.
+	FlagCreate    = FileOpenFlag(os.O_CREATE) // create a new file if none exists.
+	FlagExclusive = FileOpenFlag(os.O_EXCL)   // used with FlagCreate, file must not exist.
+	FlagTruncate  = FileOpenFlag(os.O_TRUNC)  // truncate regular writable file when opened.
+	FlagAppend    = FileOpenFlag(os.O_APPEND) // append data to the file when writing.
 )
 
+// IsSet reports whether any of the bits of the given flag are set. As
+// FlagReadOnly is the zero value it is never set, use IsReadOnly instead.
 func (f FileOpenFlag) IsSet(flag FileOpenFlag) bool { return f&flag != 0 }
 
+// IsReadOnly reports whether the file is opened read-only, that is neither
+// FlagWriteOnly nor FlagReadWrite are set.
+func (f FileOpenFlag) IsReadOnly() bool { return f&(FlagWriteOnly|FlagReadWrite) == 0 }
+
 // FileReadOnly is the interface implemented by a read-only file.
 // This is kept for compatibility with io/fs.
 type FileReadOnly = gofs.File
@@ -115,3 +135,189 @@ type ArchiveFS interface {
 	// Archive creates a tar archive of the directory at the given path.
 	Archive(path string) (io.ReadCloser, error)
 }
+
+// CopyFS is the interface implemented by a file system that can copy files
+// without streaming their contents through the client (eg. server-side copies).
+type CopyFS interface {
+	FS
+
+	// Copy copies the file at src to dst, replacing dst if it already exists.
+	// Extended attributes are copied along with the file.
+	Copy(src, dst string) error
+
+	// CopyAll copies src, and any children it contains, to dst.
+	CopyAll(src, dst string) error
+}
+
+// SymlinkFS is the interface implemented by a file system that supports
+// symbolic links.
+type SymlinkFS interface {
+	FS
+
+	// Symlink creates newName as a symbolic link to oldName.
+	Symlink(oldName, newName string) error
+
+	// ReadLink returns the destination of the named symbolic link.
+	ReadLink(name string) (string, error)
+
+	// Lstat returns a FileInfo describing the named file. If the file is a
+	// symbolic link, the returned FileInfo describes the link itself.
+	Lstat(name string) (FileInfo, error)
+}
+
+// ChmodFS is the interface implemented by a file system that supports
+// changing file permissions.
+type ChmodFS interface {
+	FS
+
+	// Chmod changes the mode of the named file to mode.
+	Chmod(name string, mode FileMode) error
+}
+
+// ChownFS is the interface implemented by a file system that supports
+// changing file ownership.
+type ChownFS interface {
+	FS
+
+	// Chown changes the numeric uid and gid of the named file. A uid or gid
+	// of -1 means to not change that value.
+	Chown(name string, uid, gid int) error
+}
+
+// ChtimesFS is the interface implemented by a file system that supports
+// changing file access and modification times.
+type ChtimesFS interface {
+	FS
+
+	// Chtimes changes the access and modification times of the named file.
+	// A zero time.Time value will leave the corresponding file time unchanged.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// XAttrFS is the interface implemented by a file system that can access the
+// extended attributes of a file or directory by path, without opening it.
+// Changes take effect immediately (there is no need to call Sync).
+type XAttrFS interface {
+	FS
+
+	// GetXAttr returns the value of the named extended attribute of the file.
+	GetXAttr(path, name string) ([]byte, error)
+	// SetXAttr sets the value of the named extended attribute of the file.
+	SetXAttr(path, name string, data []byte) error
+	// RemoveXAttr removes the named extended attribute of the file.
+	RemoveXAttr(path, name string) error
+	// ListXAttrs returns the names of all extended attributes of the file.
+	ListXAttrs(path string) ([]string, error)
+}
+
+// MkdirFS is the interface implemented by a file system that can create a
+// single directory.
+type MkdirFS interface {
+	FS
+
+	// Mkdir creates a directory named path. Unlike MkdirAll, the parent
+	// directory must already exist, and ErrExist is returned if path exists.
+	Mkdir(path string) error
+}
+
+// RemoveFS is the interface implemented by a file system that can remove a
+// single file or empty directory.
+type RemoveFS interface {
+	FS
+
+	// Remove removes the named file or empty directory. ErrNotEmpty is
+	// returned if the directory has any children.
+	Remove(path string) error
+}
+
+// WriteFileFS is the interface implemented by a file system that can write a
+// whole file more efficiently than opening it (eg. with a single upload).
+type WriteFileFS interface {
+	FS
+
+	// WriteFile writes data to the named file, creating it if necessary, and
+	// replacing any existing contents.
+	WriteFile(path string, data []byte) error
+}
+
+// DirStream is an incremental listing of a directory.
+type DirStream interface {
+	io.Closer
+
+	// Next returns the next n entries of the directory. If n > 0, at most n
+	// entries are returned, and io.EOF is returned once the listing is
+	// exhausted. If n <= 0, all the remaining entries are returned.
+	Next(n int) ([]DirEntry, error)
+
+	// Token returns an opaque continuation token, that can be passed to
+	// ReadDirStream to resume the listing after the last returned entry.
+	Token() string
+}
+
+// ReadDirStreamFS is the interface implemented by a file system that can
+// list directories incrementally (eg. without holding every entry in memory).
+type ReadDirStreamFS interface {
+	FS
+
+	// ReadDirStream opens a listing of the named directory, resuming after
+	// token (if not empty). Unlike ReadDir, the entries aren't necessarily
+	// sorted. The listing stops if ctx is cancelled or the stream is closed.
+	ReadDirStream(ctx context.Context, path, token string) (DirStream, error)
+}
+
+// WalkFS is the interface implemented by a file system that can walk a file
+// tree more efficiently than fs.WalkDir (eg. with a single recursive listing).
+type WalkFS interface {
+	FS
+
+	// Walk walks the file tree rooted at root, calling fn for each file or
+	// directory in the tree (including root). It has the same semantics as
+	// fs.WalkDir, including the order that files are visited in and the
+	// handling of fs.SkipDir and fs.SkipAll.
+	Walk(root string, fn gofs.WalkDirFunc) error
+}
+
+// ContextFS is the interface implemented by a file system that supports
+// cancelling operations with a context. Operations without a context variant
+// (eg. Mkdir, Remove, Symlink, Chmod, Copy, Archive and the extended attribute
+// functions) can't be cancelled individually.
+type ContextFS interface {
+	FS
+
+	// OpenFileContext is like OpenFile, but aborts if ctx is cancelled.
+	OpenFileContext(ctx context.Context, path string, flag FileOpenFlag) (File, error)
+
+	// StatContext is like Stat, but aborts if ctx is cancelled.
+	StatContext(ctx context.Context, path string) (FileInfo, error)
+
+	// ReadDirContext is like ReadDir, but aborts if ctx is cancelled.
+	ReadDirContext(ctx context.Context, path string) ([]DirEntry, error)
+
+	// MkdirAllContext is like MkdirAll, but aborts if ctx is cancelled.
+	MkdirAllContext(ctx context.Context, path string) error
+
+	// RemoveAllContext is like RemoveAll, but aborts if ctx is cancelled.
+	RemoveAllContext(ctx context.Context, path string) error
+
+	// RenameContext is like Rename, but aborts if ctx is cancelled.
+	RenameContext(ctx context.Context, oldPath, newPath string) error
+}
+
+// ContextFile is the interface implemented by a file that supports
+// cancelling operations with a context. Operations without a context variant
+// (eg. Stat, Truncate and XAttrs) can't be cancelled individually.
+type ContextFile interface {
+	File
+
+	// ReadContext is like Read, but aborts if ctx is cancelled.
+	ReadContext(ctx context.Context, p []byte) (int, error)
+
+	// ReadAtContext is like ReadAt, but aborts if ctx is cancelled.
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+
+	// SyncContext is like Sync, but aborts if ctx is cancelled.
+	SyncContext(ctx context.Context) error
+
+	// CloseContext is like Close, but aborts if ctx is cancelled.
+	CloseContext(ctx context.Context) error
+}
@@ -0,0 +1,180 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3mem
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type multipartUpload struct {
+	bucketName  string
+	key         string
+	contentType string
+	metadata    map[string]string
+	parts       map[int]*object
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	s.nextID++
+	uploadID := strconv.Itoa(s.nextID)
+
+	s.uploads[uploadID] = &multipartUpload{
+		bucketName:  bucketName,
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    userMetadata(r.Header),
+		parts:       make(map[int]*object),
+	}
+
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, key string) {
+	upload, partNumber, ok := s.lookupPart(w, r, key)
+	if !ok {
+		return
+	}
+
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error(), r.URL.Path)
+		return
+	}
+
+	part := &object{data: data, etag: etagOf(data), modTime: now()}
+	upload.parts[partNumber] = part
+
+	w.Header().Set("ETag", part.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) uploadPartCopy(w http.ResponseWriter, r *http.Request, key string) {
+	upload, partNumber, ok := s.lookupPart(w, r, key)
+	if !ok {
+		return
+	}
+
+	src, ok := s.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	data := src.data
+	if sourceRange := r.Header.Get("X-Amz-Copy-Source-Range"); sourceRange != "" {
+		start, end, ok := parseRange(sourceRange, int64(len(src.data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable", r.URL.Path)
+			return
+		}
+
+		data = src.data[start : end+1]
+	}
+
+	part := &object{data: data, etag: etagOf(data), modTime: now()}
+	upload.parts[partNumber] = part
+
+	writeXML(w, http.StatusOK, copyObjectResult{
+		ETag:         part.etag,
+		LastModified: part.modTime.Format(timeFormat),
+	})
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	uploadID := r.URL.Query().Get("uploadId")
+
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist", r.URL.Path)
+		return
+	}
+
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error(), r.URL.Path)
+		return
+	}
+
+	if !checkPreconditions(w, r, b, key) {
+		return
+	}
+
+	var data bytes.Buffer
+	etags := md5.New()
+	lastPartNumber := 0
+
+	for _, p := range req.Parts {
+		part, ok := upload.parts[p.PartNumber]
+		if !ok || !etagMatches(p.ETag, part.etag) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found", r.URL.Path)
+			return
+		}
+
+		if p.PartNumber <= lastPartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order", r.URL.Path)
+			return
+		}
+		lastPartNumber = p.PartNumber
+
+		data.Write(part.data)
+
+		sum, _ := hex.DecodeString(part.etag[1 : len(part.etag)-1])
+		etags.Write(sum)
+	}
+
+	obj := &object{
+		data:        data.Bytes(),
+		etag:        fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(etags.Sum(nil)), len(req.Parts)),
+		modTime:     now(),
+		contentType: upload.contentType,
+		metadata:    upload.metadata,
+	}
+
+	b.objects[key] = obj
+	delete(s.uploads, uploadID)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Bucket: upload.bucketName,
+		Key:    key,
+		ETag:   obj.etag,
+	})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	delete(s.uploads, r.URL.Query().Get("uploadId"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) lookupPart(w http.ResponseWriter, r *http.Request, key string) (*multipartUpload, int, bool) {
+	query := r.URL.Query()
+
+	upload, ok := s.uploads[query.Get("uploadId")]
+	if !ok || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist", r.URL.Path)
+		return nil, 0, false
+	}
+
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000", r.URL.Path)
+		return nil, 0, false
+	}
+
+	return upload, partNumber, true
+}
@@ -0,0 +1,581 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package s3mem implements an in-memory S3 compatible server. It speaks just
+// enough of the S3 API for s3fs to be exercised without any external services.
+package s3mem
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data        []byte
+	etag        string
+	modTime     time.Time
+	contentType string
+	// User metadata, keyed by canonical header name (eg. X-Amz-Meta-Foo).
+	metadata map[string]string
+}
+
+type bucket struct {
+	objects map[string]*object
+}
+
+// Server is an in-memory S3 compatible server.
+type Server struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*multipartUpload
+	nextID  int
+}
+
+// New creates a new in-memory S3 server with the given buckets.
+func New(bucketNames ...string) *Server {
+	s := &Server{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*multipartUpload),
+	}
+
+	for _, name := range bucketNames {
+		s.buckets[name] = &bucket{objects: make(map[string]*object)}
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bucketName == "" {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "Listing buckets is not supported", r.URL.Path)
+		return
+	}
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		if r.Method == http.MethodPut && key == "" {
+			s.buckets[bucketName] = &bucket{objects: make(map[string]*object)}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist", r.URL.Path)
+		return
+	}
+
+	if key == "" {
+		switch {
+		case r.Method == http.MethodGet && query.Has("location"):
+			writeXML(w, http.StatusOK, locationConstraint{Xmlns: xmlns})
+		case r.Method == http.MethodGet:
+			s.listObjects(w, r, b)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPost && query.Has("delete"):
+			s.deleteObjects(w, r, b)
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "Unsupported bucket operation", r.URL.Path)
+		}
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.getObject(w, r, b, key)
+	case http.MethodPut:
+		switch {
+		case query.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+			s.uploadPartCopy(w, r, key)
+		case query.Has("uploadId"):
+			s.uploadPart(w, r, key)
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			s.copyObject(w, r, b, key)
+		default:
+			s.putObject(w, r, b, key)
+		}
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			s.createMultipartUpload(w, r, bucketName, key)
+		case query.Has("uploadId"):
+			s.completeMultipartUpload(w, r, b, key)
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "Unsupported object operation", r.URL.Path)
+		}
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			s.abortMultipartUpload(w, r)
+			return
+		}
+
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed", r.URL.Path)
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	obj, ok := b.objects[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist", r.URL.Path)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, obj.etag) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", r.URL.Path)
+		return
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, obj.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setObjectHeaders(w.Header(), obj)
+
+	start, end := int64(0), int64(len(obj.data))-1
+	status := http.StatusOK
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var ok bool
+		start, end, ok = parseRange(rangeHeader, int64(len(obj.data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable", r.URL.Path)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.data[start : end+1])
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error(), r.URL.Path)
+		return
+	}
+
+	if !checkPreconditions(w, r, b, key) {
+		return
+	}
+
+	obj := &object{
+		data:        data,
+		etag:        etagOf(data),
+		modTime:     now(),
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    userMetadata(r.Header),
+	}
+
+	b.objects[key] = obj
+
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, b *bucket, key string) {
+	src, ok := s.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	if !checkPreconditions(w, r, b, key) {
+		return
+	}
+
+	obj := &object{
+		data:        src.data,
+		etag:        src.etag,
+		modTime:     now(),
+		contentType: src.contentType,
+		metadata:    src.metadata,
+	}
+
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		obj.metadata = userMetadata(r.Header)
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			obj.contentType = contentType
+		}
+	}
+
+	b.objects[key] = obj
+
+	w.Header().Set("ETag", obj.etag)
+	writeXML(w, http.StatusOK, copyObjectResult{
+		ETag:         obj.etag,
+		LastModified: obj.modTime.Format(timeFormat),
+	})
+}
+
+// copySource resolves the object referenced by the X-Amz-Copy-Source header.
+func (s *Server) copySource(w http.ResponseWriter, r *http.Request) (*object, bool) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid copy source", r.URL.Path)
+		return nil, false
+	}
+
+	// Strip any version id, we don't support versioning.
+	source, _, _ = strings.Cut(source, "?")
+
+	srcBucketName, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	srcBucket, ok := s.buckets[srcBucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist", source)
+		return nil, false
+	}
+
+	src, ok := srcBucket.objects[srcKey]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist", source)
+		return nil, false
+	}
+
+	if ifMatch := r.Header.Get("X-Amz-Copy-Source-If-Match"); ifMatch != "" && !etagMatches(ifMatch, src.etag) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", source)
+		return nil, false
+	}
+
+	if ifNoneMatch := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, src.etag) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", source)
+		return nil, false
+	}
+
+	return src, true
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, b *bucket) {
+	query := r.URL.Query()
+
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	startAfter := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		startAfter = token
+	}
+
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys", r.URL.Path)
+			return
+		}
+
+		maxKeys = min(n, 1000)
+	}
+
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	encode := func(s string) string { return s }
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+
+	result := listBucketV2Result{
+		Name:         strings.TrimPrefix(r.URL.Path, "/"),
+		Prefix:       encode(prefix),
+		Delimiter:    encode(delimiter),
+		MaxKeys:      maxKeys,
+		StartAfter:   encode(query.Get("start-after")),
+		EncodingType: query.Get("encoding-type"),
+	}
+
+	var lastKey string
+	seenPrefixes := make(map[string]bool)
+
+	for _, key := range keys {
+		if key <= startAfter {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				// Like S3, a common prefix is repeated if there are keys
+				// sharing it after start-after (eg. start-after "dir/").
+				if seenPrefixes[commonPrefix] {
+					continue
+				}
+
+				if result.KeyCount == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+
+				seenPrefixes[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefixResult{Prefix: encode(commonPrefix)})
+				result.KeyCount++
+				// Resume after every key sharing this prefix.
+				lastKey = commonPrefix + "\xff"
+
+				continue
+			}
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		obj := b.objects[key]
+		result.Contents = append(result.Contents, objectResult{
+			Key:          encode(key),
+			LastModified: obj.modTime.Format(timeFormat),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		lastKey = key
+	}
+
+	if result.IsTruncated {
+		result.NextContinuationToken = lastKey
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, b *bucket) {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error(), r.URL.Path)
+		return
+	}
+
+	var result deleteResult
+	for _, obj := range req.Objects {
+		delete(b.objects, obj.Key)
+
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// checkPreconditions evaluates conditional write headers against the
+// current state of the destination key.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, b *bucket, key string) bool {
+	existing, exists := b.objects[key]
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			writeError(w, http.StatusNotImplemented, "NotImplemented", "If-None-Match only supports '*'", r.URL.Path)
+			return false
+		}
+
+		if exists {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", r.URL.Path)
+			return false
+		}
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist", r.URL.Path)
+			return false
+		}
+
+		if !etagMatches(ifMatch, existing.etag) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold", r.URL.Path)
+			return false
+		}
+	}
+
+	return true
+}
+
+func setObjectHeaders(h http.Header, obj *object) {
+	h.Set("ETag", obj.etag)
+	h.Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+
+	contentType := obj.contentType
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+
+	for name, value := range obj.metadata {
+		h.Set(name, value)
+	}
+}
+
+func userMetadata(h http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range h {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") && len(values) > 0 {
+			metadata[http.CanonicalHeaderKey(name)] = values[0]
+		}
+	}
+
+	return metadata
+}
+
+// parseRange parses a single range HTTP Range header.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range, eg. the last N bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+
+		return max(size-n, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+
+		end = min(end, size-1)
+	}
+
+	return start, end, true
+}
+
+// readBody reads the request payload, decoding aws-chunked (streaming
+// signature) bodies if necessary.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data, nil
+	}
+
+	var decoded bytes.Buffer
+	for {
+		line, rest, ok := bytes.Cut(data, []byte("\r\n"))
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		sizeHex, _, _ := bytes.Cut(line, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size: %w", err)
+		}
+
+		// The final chunk may be followed by trailing headers, which we ignore.
+		if size == 0 {
+			return decoded.Bytes(), nil
+		}
+
+		if int64(len(rest)) < size+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		decoded.Write(rest[:size])
+		data = rest[size+2:]
+	}
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func etagMatches(condition, etag string) bool {
+	for _, candidate := range strings.Split(condition, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(data)))
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message, resource string) {
+	writeXML(w, status, errorResponse{
+		Code:     code,
+		Message:  message,
+		Resource: resource,
+	})
+}
+
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+func now() time.Time {
+	// S3 only has millisecond precision.
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
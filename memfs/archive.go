@@ -0,0 +1,109 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package memfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	gopath "path"
+	"sort"
+	"syscall"
+)
+
+type archiveEntry struct {
+	path string
+	file *file
+}
+
+func (fsys *memFS) Archive(name string) (io.ReadCloser, error) {
+	fsys.mu.RLock()
+
+	dir, err := fsys.lookup("archive", name)
+	if err != nil {
+		fsys.mu.RUnlock()
+		return nil, err
+	}
+
+	if !dir.isDir() {
+		fsys.mu.RUnlock()
+		return nil, &fs.PathError{Op: "archive", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	// Take a snapshot of the directory structure, so we don't need to hold
+	// the lock while the archive is being read.
+	var entries []archiveEntry
+	var walk func(dir *file, dirPath string)
+	walk = func(dir *file, dirPath string) {
+		names := make([]string, 0, len(dir.children))
+		for childName := range dir.children {
+			names = append(names, childName)
+		}
+
+		sort.Strings(names)
+
+		for _, childName := range names {
+			child := dir.children[childName]
+			childPath := gopath.Join(dirPath, childName)
+
+			entries = append(entries, archiveEntry{path: childPath, file: child})
+
+			if child.isDir() {
+				walk(child, childPath)
+			}
+		}
+	}
+	walk(dir, "")
+
+	fsys.mu.RUnlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+
+		tw := tar.NewWriter(pw)
+		defer tw.Close()
+
+		for _, entry := range entries {
+			f := entry.file
+
+			f.mu.Lock()
+			hdr := &tar.Header{
+				Name:    entry.path,
+				Mode:    int64(f.mode.Perm()),
+				ModTime: f.modTime,
+			}
+
+			var data []byte
+			if f.isDir() {
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+			} else {
+				hdr.Typeflag = tar.TypeReg
+				data = bytes.Clone(f.data)
+				hdr.Size = int64(len(data))
+			}
+			f.mu.Unlock()
+
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			if _, err := tw.Write(data); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return pr, nil
+}
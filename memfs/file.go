@@ -0,0 +1,448 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	gopath "path"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var (
+	_ writablefs.File = (*fileHandle)(nil)
+	_ fs.ReadDirFile  = (*fileHandle)(nil)
+)
+
+// file is an in-memory file (or directory) that is shared between multiple
+// virtual file handles.
+type file struct {
+	mu sync.Mutex
+	// The filesystem this file is associated with.
+	fsys *memFS
+	// The directory containing this file (guarded by fsys.mu).
+	parent *file
+	// The children of a directory, keyed by name (guarded by fsys.mu).
+	children map[string]*file
+	mode     writablefs.FileMode
+	modTime  time.Time
+	data     []byte
+	xattrs   map[string][]byte
+	// Has the file been removed? Removed files remain usable by any open
+	// handles, but no longer count towards the size limits.
+	removed bool
+}
+
+// newHandle creates a new handle for this file.
+func (f *file) newHandle(name string, flag writablefs.FileOpenFlag) *fileHandle {
+	return &fileHandle{
+		file:     f,
+		name:     name,
+		readOnly: flag.IsReadOnly(),
+		append:   flag.IsSet(writablefs.FlagAppend),
+	}
+}
+
+func (f *file) isDir() bool {
+	return f.mode.IsDir()
+}
+
+// touch updates the modification time of the file.
+func (f *file) touch() {
+	f.mu.Lock()
+	f.modTime = f.fsys.clock()
+	f.mu.Unlock()
+}
+
+func (f *file) stat(name string) writablefs.FileInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return &fileInfo{
+		name:    name,
+		size:    int64(len(f.data)),
+		mode:    f.mode,
+		modTime: f.modTime,
+	}
+}
+
+// release removes the file (and any children it contains) from the size
+// accounting, fsys.mu must be held.
+func (f *file) release() {
+	for _, child := range f.children {
+		child.release()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.removed {
+		f.fsys.size.Add(-int64(len(f.data)))
+		f.removed = true
+	}
+}
+
+// resize changes the length of the file data, f.mu must be held.
+func (f *file) resize(size int64) error {
+	if !f.removed {
+		if err := f.fsys.reserve(int64(len(f.data)), size); err != nil {
+			return err
+		}
+	}
+
+	if size <= int64(cap(f.data)) {
+		oldSize := len(f.data)
+		f.data = f.data[:size]
+		// Zero any previously truncated data.
+		for i := oldSize; i < len(f.data); i++ {
+			f.data[i] = 0
+		}
+	} else {
+		data := make([]byte, size, max(size, 2*int64(cap(f.data))))
+		copy(data, f.data)
+		f.data = data
+	}
+
+	return nil
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		// ReaderAt requires an error for short reads.
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writeAt(p, off)
+}
+
+// Append writes to the end of the file.
+func (f *file) Append(p []byte) (int, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.writeAt(p, int64(len(f.data)))
+
+	return n, int64(len(f.data)), err
+}
+
+func (f *file) writeAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		if err := f.resize(end); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(f.data[off:], p)
+	if n > 0 {
+		f.modTime = f.fsys.clock()
+	}
+
+	return n, nil
+}
+
+func (f *file) truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.resize(size); err != nil {
+		return err
+	}
+
+	f.modTime = f.fsys.clock()
+
+	return nil
+}
+
+func (f *file) size() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return int64(len(f.data))
+}
+
+// fileHandle is a stateful virtual file handle. It keeps track of the
+// current file cursor and enforces read-only permissions.
+type fileHandle struct {
+	mu sync.Mutex
+	// The file this handle is associated with.
+	file *file
+	// The name the file was opened with.
+	name     string
+	readOnly bool
+	// Should writes always go to the end of the file?
+	append bool
+	// The current offset in the file.
+	offset int64
+	// The directory entries that haven't been returned yet (if listed).
+	entries []writablefs.DirEntry
+	listed  bool
+	closed  atomic.Bool
+}
+
+func (h *fileHandle) Close() error {
+	if h.closed.Swap(true) {
+		return h.pathError("close", writablefs.ErrClosed)
+	}
+
+	return nil
+}
+
+func (h *fileHandle) Read(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.checkReadable("read"); err != nil {
+		return 0, err
+	}
+
+	n, err := h.file.ReadAt(p, h.offset)
+	h.offset += int64(n)
+
+	// Unlike ReadAt, short reads aren't an error.
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (h *fileHandle) ReadAt(p []byte, off int64) (int, error) {
+	if err := h.checkReadable("read"); err != nil {
+		return 0, err
+	}
+
+	if off < 0 {
+		return 0, h.pathError("readat", fmt.Errorf("negative offset: %w", writablefs.ErrInvalid))
+	}
+
+	return h.file.ReadAt(p, off)
+}
+
+func (h *fileHandle) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.checkWritable("write"); err != nil {
+		return 0, err
+	}
+
+	if h.append {
+		n, end, err := h.file.Append(p)
+		h.offset = end
+		if err != nil {
+			return n, h.pathError("write", err)
+		}
+
+		return n, nil
+	}
+
+	n, err := h.file.WriteAt(p, h.offset)
+	h.offset += int64(n)
+	if err != nil {
+		return n, h.pathError("write", err)
+	}
+
+	return n, nil
+}
+
+func (h *fileHandle) WriteAt(p []byte, off int64) (int, error) {
+	if err := h.checkWritable("write"); err != nil {
+		return 0, err
+	}
+
+	// Match the behavior of os.File.
+	if h.append {
+		return 0, fmt.Errorf("invalid use of WriteAt on file opened with FlagAppend: %w", writablefs.ErrInvalid)
+	}
+
+	if off < 0 {
+		return 0, h.pathError("writeat", fmt.Errorf("negative offset: %w", writablefs.ErrInvalid))
+	}
+
+	n, err := h.file.WriteAt(p, off)
+	if err != nil {
+		return n, h.pathError("write", err)
+	}
+
+	return n, nil
+}
+
+func (h *fileHandle) Seek(offset int64, whence int) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("seek"); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += h.offset
+	case io.SeekEnd:
+		offset += h.file.size()
+	default:
+		return 0, h.pathError("seek", writablefs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, h.pathError("seek", writablefs.ErrInvalid)
+	}
+
+	h.offset = offset
+
+	// Rewind the directory listing.
+	h.entries, h.listed = nil, false
+
+	return h.offset, nil
+}
+
+// ReadDir reads the contents of a directory.
+func (h *fileHandle) ReadDir(n int) ([]writablefs.DirEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.check("readdir"); err != nil {
+		return nil, err
+	}
+
+	if !h.file.isDir() {
+		return nil, h.pathError("readdir", syscall.ENOTDIR)
+	}
+
+	if !h.listed {
+		fsys := h.file.fsys
+
+		fsys.mu.RLock()
+		h.entries = fsys.listDir(h.file)
+		fsys.mu.RUnlock()
+
+		h.listed = true
+	}
+
+	if n <= 0 {
+		entries := h.entries
+		h.entries = nil
+
+		return entries, nil
+	}
+
+	if len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(h.entries))
+	entries := h.entries[:n]
+	h.entries = h.entries[n:]
+
+	return entries, nil
+}
+
+func (h *fileHandle) Stat() (writablefs.FileInfo, error) {
+	if err := h.check("stat"); err != nil {
+		return nil, err
+	}
+
+	return h.file.stat(gopath.Base(h.name)), nil
+}
+
+func (h *fileHandle) Sync() error {
+	// Everything is already stored in memory.
+	return h.check("sync")
+}
+
+func (h *fileHandle) Truncate(size int64) error {
+	if err := h.checkWritable("truncate"); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return h.pathError("truncate", writablefs.ErrInvalid)
+	}
+
+	if err := h.file.truncate(size); err != nil {
+		return h.pathError("truncate", err)
+	}
+
+	return nil
+}
+
+func (h *fileHandle) XAttrs() (writablefs.ExtendedAttributes, error) {
+	if err := h.check("xattrs"); err != nil {
+		return nil, err
+	}
+
+	return &memAttrs{handle: h}, nil
+}
+
+// check returns an error if the handle has been closed.
+func (h *fileHandle) check(op string) error {
+	if h.closed.Load() {
+		return h.pathError(op, writablefs.ErrClosed)
+	}
+
+	return nil
+}
+
+// checkReadable returns an error if the handle can't be read from.
+func (h *fileHandle) checkReadable(op string) error {
+	if err := h.check(op); err != nil {
+		return err
+	}
+
+	if h.file.isDir() {
+		return h.pathError(op, syscall.EISDIR)
+	}
+
+	return nil
+}
+
+// checkWritable returns an error if the handle can't be written to.
+func (h *fileHandle) checkWritable(op string) error {
+	if err := h.check(op); err != nil {
+		return err
+	}
+
+	if h.file.isDir() {
+		return h.pathError(op, syscall.EISDIR)
+	}
+
+	if h.readOnly {
+		return h.pathError(op, writablefs.ErrPermission)
+	}
+
+	return nil
+}
+
+func (h *fileHandle) pathError(op string, err error) error {
+	return &fs.PathError{Op: op, Path: h.name, Err: err}
+}
@@ -0,0 +1,484 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package memfs implements a writable file system that is stored entirely in
+// memory. It is intended for tests and ephemeral scratch space.
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	gopath "path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+// ErrNoSpace is returned when a write would exceed the configured size limits.
+var ErrNoSpace = errors.New("no space left on device")
+
+// The maximum length of a single path component.
+const maxNameLength = 255
+
+var (
+	_ writablefs.ArchiveFS = (*memFS)(nil)
+	_ writablefs.MkdirFS   = (*memFS)(nil)
+	_ writablefs.RemoveFS  = (*memFS)(nil)
+)
+
+type memFS struct {
+	// Guards the directory structure (eg. the children of every directory).
+	mu   sync.RWMutex
+	root *file
+	// The total size of all the files.
+	size        atomic.Int64
+	maxSize     int64
+	maxFileSize int64
+	clock       func() time.Time
+}
+
+// Options for creating a new in-memory filesystem.
+type Options struct {
+	// MaxSize is the maximum total size of all the files, in bytes (zero means unlimited).
+	MaxSize int64
+	// MaxFileSize is the maximum size of a single file, in bytes (zero means unlimited).
+	MaxFileSize int64
+	// Clock returns the current time, used for modification times (defaults to time.Now).
+	Clock func() time.Time
+}
+
+// New creates a new, empty, in-memory filesystem.
+func New(opts Options) (writablefs.FS, error) {
+	if opts.MaxSize < 0 || opts.MaxFileSize < 0 {
+		return nil, writablefs.ErrInvalid
+	}
+
+	clock := opts.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+
+	fsys := &memFS{
+		maxSize:     opts.MaxSize,
+		maxFileSize: opts.MaxFileSize,
+		clock:       clock,
+	}
+
+	fsys.root = fsys.newDir()
+
+	return fsys, nil
+}
+
+func (fsys *memFS) Close() error {
+	return nil
+}
+
+func (fsys *memFS) Open(name string) (writablefs.FileReadOnly, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: writablefs.ErrInvalid}
+	}
+
+	return fsys.OpenFile(name, writablefs.FlagReadOnly)
+}
+
+func (fsys *memFS) OpenFile(name string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
+	readOnly := flag.IsReadOnly()
+	create := flag.IsSet(writablefs.FlagCreate)
+
+	var f *file
+	if create {
+		fsys.mu.Lock()
+		defer fsys.mu.Unlock()
+
+		parent, base, err := fsys.lookupParent("open", name)
+		if err != nil {
+			return nil, err
+		}
+
+		if base == "" {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+
+		var ok bool
+		f, ok = parent.children[base]
+		if ok && flag.IsSet(writablefs.FlagExclusive) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: writablefs.ErrExist}
+		}
+
+		if !ok {
+			f = fsys.newFile()
+			fsys.link(parent, base, f)
+		}
+	} else {
+		fsys.mu.RLock()
+		defer fsys.mu.RUnlock()
+
+		var err error
+		f, err = fsys.lookup("open", name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.isDir() && !readOnly {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	if flag.IsSet(writablefs.FlagTruncate) && !readOnly && !f.isDir() {
+		if err := f.truncate(0); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	return f.newHandle(name, flag), nil
+}
+
+func (fsys *memFS) MkdirAll(name string) error {
+	p, err := cleanPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	dir := fsys.root
+	for _, part := range splitPath(p) {
+		child, ok := dir.children[part]
+		if !ok {
+			child = fsys.newDir()
+			fsys.link(dir, part, child)
+		} else if !child.isDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+
+		dir = child
+	}
+
+	return nil
+}
+
+func (fsys *memFS) Mkdir(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	parent, base, err := fsys.lookupParent("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := parent.children[base]; ok || base == "" {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: writablefs.ErrExist}
+	}
+
+	fsys.link(parent, base, fsys.newDir())
+
+	return nil
+}
+
+func (fsys *memFS) ReadDir(name string) ([]writablefs.DirEntry, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	dir, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dir.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	return fsys.listDir(dir), nil
+}
+
+// listDir returns the entries of the directory sorted by name, fsys.mu must
+// be held.
+func (fsys *memFS) listDir(dir *file) []writablefs.DirEntry {
+	entries := make([]writablefs.DirEntry, 0, len(dir.children))
+	for childName, child := range dir.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.stat(childName)))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries
+}
+
+func (fsys *memFS) RemoveAll(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	parent, base, err := fsys.lookupParent("removeall", name)
+	if err != nil {
+		// Like os.RemoveAll, it's not an error if the path doesn't exist.
+		if errors.Is(err, writablefs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	// Removing the root directory removes everything in it.
+	if base == "" {
+		for childName := range fsys.root.children {
+			fsys.unlink(fsys.root, childName)
+		}
+
+		return nil
+	}
+
+	if _, ok := parent.children[base]; ok {
+		fsys.unlink(parent, base)
+	}
+
+	return nil
+}
+
+func (fsys *memFS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	parent, base, err := fsys.lookupParent("remove", name)
+	if err != nil {
+		return err
+	}
+
+	if base == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: writablefs.ErrInvalid}
+	}
+
+	f, ok := parent.children[base]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: writablefs.ErrNotExist}
+	}
+
+	if f.isDir() && len(f.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: writablefs.ErrNotEmpty}
+	}
+
+	fsys.unlink(parent, base)
+
+	return nil
+}
+
+func (fsys *memFS) Rename(oldName, newName string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	oldParent, oldBase, err := fsys.lookupParent("rename", oldName)
+	if err != nil {
+		return err
+	}
+
+	newParent, newBase, err := fsys.lookupParent("rename", newName)
+	if err != nil {
+		return err
+	}
+
+	if oldBase == "" || newBase == "" {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: writablefs.ErrInvalid}
+	}
+
+	f, ok := oldParent.children[oldBase]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: writablefs.ErrNotExist}
+	}
+
+	existing, ok := newParent.children[newBase]
+	if ok {
+		if existing == f {
+			return nil
+		}
+
+		switch {
+		case f.isDir() && !existing.isDir():
+			return &fs.PathError{Op: "rename", Path: newName, Err: syscall.ENOTDIR}
+		case !f.isDir() && existing.isDir():
+			return &fs.PathError{Op: "rename", Path: newName, Err: syscall.EISDIR}
+		case existing.isDir() && len(existing.children) > 0:
+			return &fs.PathError{Op: "rename", Path: newName, Err: writablefs.ErrNotEmpty}
+		}
+	}
+
+	// A directory can't be moved inside itself.
+	if f.isDir() {
+		for dir := newParent; dir != nil; dir = dir.parent {
+			if dir == f {
+				return &fs.PathError{Op: "rename", Path: oldName, Err: writablefs.ErrInvalid}
+			}
+		}
+	}
+
+	if ok {
+		fsys.unlink(newParent, newBase)
+	}
+
+	delete(oldParent.children, oldBase)
+	oldParent.touch()
+
+	fsys.link(newParent, newBase, f)
+
+	return nil
+}
+
+func (fsys *memFS) Stat(name string) (writablefs.FileInfo, error) {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	p, _ := cleanPath("stat", name)
+	if p == "" {
+		p = "."
+	}
+
+	return f.stat(gopath.Base(p)), nil
+}
+
+func (fsys *memFS) newFile() *file {
+	return &file{
+		fsys:    fsys,
+		mode:    0o644,
+		modTime: fsys.clock(),
+	}
+}
+
+func (fsys *memFS) newDir() *file {
+	return &file{
+		fsys:     fsys,
+		mode:     writablefs.ModeDir | 0o755,
+		modTime:  fsys.clock(),
+		children: make(map[string]*file),
+	}
+}
+
+// link adds f to the directory as name, fsys.mu must be held.
+func (fsys *memFS) link(dir *file, name string, f *file) {
+	f.parent = dir
+	dir.children[name] = f
+	dir.touch()
+}
+
+// unlink removes name (and any children it contains) from the directory,
+// fsys.mu must be held.
+func (fsys *memFS) unlink(dir *file, name string) {
+	f := dir.children[name]
+	delete(dir.children, name)
+	dir.touch()
+
+	f.release()
+}
+
+// lookup finds the file or directory at name, fsys.mu must be held.
+func (fsys *memFS) lookup(op, name string) (*file, error) {
+	parent, base, err := fsys.lookupParent(op, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == "" {
+		return parent, nil
+	}
+
+	f, ok := parent.children[base]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: writablefs.ErrNotExist}
+	}
+
+	return f, nil
+}
+
+// lookupParent finds the directory containing name, and the base name
+// within it. The base name is empty if name refers to the root directory.
+// fsys.mu must be held.
+func (fsys *memFS) lookupParent(op, name string) (*file, string, error) {
+	p, err := cleanPath(op, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return fsys.root, "", nil
+	}
+
+	for _, part := range parts {
+		if len(part) > maxNameLength {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.ENAMETOOLONG}
+		}
+	}
+
+	dir := fsys.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := dir.children[part]
+		if !ok {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: writablefs.ErrNotExist}
+		}
+
+		if !child.isDir() {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+		}
+
+		dir = child
+	}
+
+	return dir, parts[len(parts)-1], nil
+}
+
+// reserve accounts for a change in the size of a file from oldSize to newSize.
+func (fsys *memFS) reserve(oldSize, newSize int64) error {
+	if fsys.maxFileSize > 0 && newSize > fsys.maxFileSize && newSize > oldSize {
+		return ErrNoSpace
+	}
+
+	for {
+		size := fsys.size.Load()
+		if fsys.maxSize > 0 && size+newSize-oldSize > fsys.maxSize && newSize > oldSize {
+			return ErrNoSpace
+		}
+
+		if fsys.size.CompareAndSwap(size, size+newSize-oldSize) {
+			return nil
+		}
+	}
+}
+
+// cleanPath cleans name into a slash separated path relative to the root
+// directory (the empty string being the root itself).
+func cleanPath(op, name string) (string, error) {
+	p := gopath.Clean(strings.TrimPrefix(name, "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", &fs.PathError{Op: op, Path: name, Err: writablefs.ErrPermission}
+	}
+
+	if p == "." {
+		return "", nil
+	}
+
+	return p, nil
+}
+
+func splitPath(p string) []string {
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,180 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package memfs
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var _ writablefs.XAttrFS = (*memFS)(nil)
+
+func (fsys *memFS) GetXAttr(name, attr string) ([]byte, error) {
+	var value []byte
+	err := fsys.withFile("getxattr", name, func(f *file) error {
+		var ok bool
+		value, ok = f.xattrs[strings.ToLower(attr)]
+		if !ok {
+			return writablefs.ErrNoSuchAttr
+		}
+
+		value = bytes.Clone(value)
+
+		return nil
+	})
+
+	return value, err
+}
+
+func (fsys *memFS) SetXAttr(name, attr string, data []byte) error {
+	return fsys.withFile("setxattr", name, func(f *file) error {
+		if f.xattrs == nil {
+			f.xattrs = make(map[string][]byte)
+		}
+
+		f.xattrs[strings.ToLower(attr)] = bytes.Clone(data)
+
+		return nil
+	})
+}
+
+func (fsys *memFS) RemoveXAttr(name, attr string) error {
+	return fsys.withFile("removexattr", name, func(f *file) error {
+		delete(f.xattrs, strings.ToLower(attr))
+
+		return nil
+	})
+}
+
+func (fsys *memFS) ListXAttrs(name string) ([]string, error) {
+	var names []string
+	err := fsys.withFile("listxattr", name, func(f *file) error {
+		names = f.xattrNames()
+
+		return nil
+	})
+
+	return names, err
+}
+
+// withFile calls fn with the file or directory at name locked.
+func (fsys *memFS) withFile(op, name string, fn func(f *file) error) error {
+	fsys.mu.RLock()
+	defer fsys.mu.RUnlock()
+
+	f, err := fsys.lookup(op, name)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return fn(f)
+}
+
+// memAttrs are the extended attributes of a file, changes are visible to
+// every handle immediately so Sync has nothing to do.
+type memAttrs struct {
+	handle *fileHandle
+}
+
+func (a *memAttrs) Get(name string) ([]byte, error) {
+	if err := a.handle.check("getxattr"); err != nil {
+		return nil, err
+	}
+
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.xattrs[strings.ToLower(name)]
+	if !ok {
+		return nil, writablefs.ErrNoSuchAttr
+	}
+
+	return bytes.Clone(value), nil
+}
+
+func (a *memAttrs) Set(name string, data []byte) error {
+	if err := a.handle.check("setxattr"); err != nil {
+		return err
+	}
+
+	if a.handle.readOnly {
+		return writablefs.ErrPermission
+	}
+
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.xattrs == nil {
+		f.xattrs = make(map[string][]byte)
+	}
+
+	f.xattrs[strings.ToLower(name)] = bytes.Clone(data)
+
+	return nil
+}
+
+func (a *memAttrs) Remove(name string) error {
+	if err := a.handle.check("removexattr"); err != nil {
+		return err
+	}
+
+	if a.handle.readOnly {
+		return writablefs.ErrPermission
+	}
+
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.xattrs, strings.ToLower(name))
+
+	return nil
+}
+
+func (a *memAttrs) List() ([]string, error) {
+	if err := a.handle.check("listxattr"); err != nil {
+		return nil, err
+	}
+
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.xattrNames(), nil
+}
+
+func (a *memAttrs) Sync() error {
+	return a.handle.check("syncxattr")
+}
+
+// xattrNames returns the sorted names of the extended attributes, f.mu must
+// be held.
+func (f *file) xattrNames() []string {
+	names := make([]string, 0, len(f.xattrs))
+	for name := range f.xattrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
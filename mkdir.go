@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// Mkdir creates a directory named path, the parent directory must already
+// exist. If the file system does not implement MkdirFS, Mkdir falls back to
+// checking the parent exists and calling MkdirAll (which is not atomic).
+func Mkdir(fsys FS, path string) error {
+	fsys, path = resolve(fsys, path)
+
+	if mkdirFsys, ok := fsys.(MkdirFS); ok {
+		return mkdirFsys.Mkdir(path)
+	}
+
+	if _, err := fsys.Stat(path); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: ErrExist}
+	} else if !errors.Is(err, ErrNotExist) {
+		return err
+	}
+
+	fi, err := fsys.Stat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: ErrInvalid}
+	}
+
+	return fsys.MkdirAll(path)
+}
+
+// Remove removes the named file or empty directory. If the file system does
+// not implement RemoveFS, Remove falls back to checking the directory is
+// empty and calling RemoveAll (which is not atomic).
+func Remove(fsys FS, path string) error {
+	fsys, path = resolve(fsys, path)
+
+	if removeFsys, ok := fsys.(RemoveFS); ok {
+		return removeFsys.Remove(path)
+	}
+
+	fi, err := Lstat(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		entries, err := fsys.ReadDir(path)
+		if err != nil {
+			return err
+		}
+
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: path, Err: ErrNotEmpty}
+		}
+	}
+
+	return fsys.RemoveAll(path)
+}
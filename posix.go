@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Chmod changes the mode of the named file to mode. If the file system does
+// not implement ChmodFS, ErrUnsupported is returned.
+func Chmod(fsys FS, name string, mode FileMode) error {
+	fsys, name = resolve(fsys, name)
+
+	chmodFsys, ok := fsys.(ChmodFS)
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: ErrUnsupported}
+	}
+
+	return chmodFsys.Chmod(name, mode)
+}
+
+// Chown changes the numeric uid and gid of the named file. If the file system
+// does not implement ChownFS, ErrUnsupported is returned.
+func Chown(fsys FS, name string, uid, gid int) error {
+	fsys, name = resolve(fsys, name)
+
+	chownFsys, ok := fsys.(ChownFS)
+	if !ok {
+		return &fs.PathError{Op: "chown", Path: name, Err: ErrUnsupported}
+	}
+
+	return chownFsys.Chown(name, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the named file. If
+// the file system does not implement ChtimesFS, ErrUnsupported is returned.
+func Chtimes(fsys FS, name string, atime, mtime time.Time) error {
+	fsys, name = resolve(fsys, name)
+
+	chtimesFsys, ok := fsys.(ChtimesFS)
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: ErrUnsupported}
+	}
+
+	return chtimesFsys.Chtimes(name, atime, mtime)
+}
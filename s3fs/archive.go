@@ -41,6 +41,8 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 
 		var objects []minio.ObjectInfo
 		directories := make(map[string]bool)
+		// Directories with a marker object (that may hold POSIX attributes).
+		markers := make(map[string]bool)
 
 		for objInfo := range objCh {
 			if objInfo.Err != nil {
@@ -53,9 +55,16 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 				continue
 			}
 
+			// Skip objects used internally by s3fs.
+			if strings.HasPrefix(objInfo.Key, internalPrefix) {
+				continue
+			}
+
 			// Collect directories.
 			if strings.HasSuffix(objInfo.Key, "/") {
-				directories[strings.TrimSuffix(strings.TrimPrefix(objInfo.Key, key), "/")] = true
+				dir := strings.TrimSuffix(strings.TrimPrefix(objInfo.Key, key), "/")
+				directories[dir] = true
+				markers[dir] = true
 				continue
 			}
 
@@ -75,24 +84,49 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 
 		sort.Strings(dirPaths)
 
+		// Listings don't include user metadata, so get the POSIX attributes of
+		// directories from their markers.
+		dirHeaders := make([]*tar.Header, len(dirPaths))
+
+		q := queue.NewQueue(numConnections)
+
+		for i, path := range dirPaths {
+			i, path := i, path
+
+			if !markers[path] {
+				dirHeaders[i] = tarHeader(path, minio.ObjectInfo{Key: key + path + "/"})
+				continue
+			}
+
+			q.Add(func() error {
+				info, err := fsys.client.StatObject(fsys.ctx, fsys.bucketName, key+path+"/", minio.StatObjectOptions{})
+				if err != nil {
+					return err
+				}
+
+				dirHeaders[i] = tarHeader(path, info)
+
+				return nil
+			})
+		}
+
+		if err := q.Wait(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
 		var writerMu sync.Mutex
 		tw := tar.NewWriter(pw)
 
 		// Add the directories up front so we can add files in arbitrary order.
-		for _, path := range dirPaths {
-			hdr := &tar.Header{
-				Name:     path,
-				Typeflag: tar.TypeDir,
-				Mode:     0o755,
-			}
-
+		for _, hdr := range dirHeaders {
 			if err := tw.WriteHeader(hdr); err != nil {
 				pw.CloseWithError(err)
 				return
 			}
 		}
 
-		q := queue.NewQueue(numConnections)
+		q = queue.NewQueue(numConnections)
 
 		for _, objInfo := range objects {
 			objInfo := objInfo
@@ -106,16 +140,24 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 				}
 				defer obj.Close()
 
-				if objInfo.Size > largeObjectThresholdBytes {
+				// Listings don't include user metadata (eg. POSIX attributes).
+				info, err := obj.Stat()
+				if err != nil {
+					return err
+				}
+
+				hdr := tarHeader(strings.TrimPrefix(objInfo.Key, key), info)
+
+				if hdr.Typeflag == tar.TypeSymlink {
 					writerMu.Lock()
 					defer writerMu.Unlock()
 
-					hdr := &tar.Header{
-						Name:    strings.TrimPrefix(objInfo.Key, key),
-						Size:    objInfo.Size,
-						ModTime: objInfo.LastModified,
-						Mode:    0o644,
-					}
+					return tw.WriteHeader(hdr)
+				}
+
+				if objInfo.Size > largeObjectThresholdBytes {
+					writerMu.Lock()
+					defer writerMu.Unlock()
 
 					if err := tw.WriteHeader(hdr); err != nil {
 						return err
@@ -143,13 +185,6 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 					writerMu.Lock()
 					defer writerMu.Unlock()
 
-					hdr := &tar.Header{
-						Name:    strings.TrimPrefix(objInfo.Key, key),
-						Size:    objInfo.Size,
-						ModTime: objInfo.LastModified,
-						Mode:    0o644,
-					}
-
 					if err := tw.WriteHeader(hdr); err != nil {
 						return err
 					}
@@ -185,3 +220,41 @@ func (fsys *s3FS) Archive(name string) (io.ReadCloser, error) {
 
 	return pr, nil
 }
+
+// tarHeader returns a tar header for an object (or directory marker),
+// including any POSIX attributes.
+func tarHeader(name string, info minio.ObjectInfo) *tar.Header {
+	fi := &fileInfo{info: info}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size,
+		ModTime:  fi.ModTime(),
+		Mode:     int64(toUnixMode(fi.Mode()) & 0o7777),
+	}
+
+	if fi.IsDir() {
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	} else if target, ok := symlinkTarget(info); ok {
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+		hdr.Size = 0
+	}
+
+	stat := fi.Sys().(*Stat)
+	if stat.UID >= 0 {
+		hdr.Uid = stat.UID
+	}
+
+	if stat.GID >= 0 {
+		hdr.Gid = stat.GID
+	}
+
+	if !stat.Atime.IsZero() {
+		hdr.AccessTime = stat.Atime
+	}
+
+	return hdr
+}
@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+var errPreconditionFailed = errors.New("precondition failed")
+
+// preconditions are the conditional headers to attach to an upload.
+type preconditions struct {
+	ifMatch     string
+	ifNoneMatch string
+}
+
+type preconditionsKey struct{}
+
+// withPreconditions returns a context that will cause uploads made with it
+// to carry the given conditional headers.
+func withPreconditions(ctx context.Context, p preconditions) context.Context {
+	return context.WithValue(ctx, preconditionsKey{}, p)
+}
+
+// conditionalTransport injects conditional headers into upload requests.
+// The version of minio-go we use has no way of setting these directly.
+type conditionalTransport struct {
+	http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, ok := req.Context().Value(preconditionsKey{}).(preconditions)
+	if !ok || !isUpload(req) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request.
+	req = req.Clone(req.Context())
+
+	if p.ifMatch != "" {
+		req.Header.Set("If-Match", p.ifMatch)
+	}
+
+	if p.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", p.ifNoneMatch)
+	}
+
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// isUpload returns true if the request creates an object, eg. a PutObject
+// or a CompleteMultipartUpload (but not the individual part uploads).
+func isUpload(req *http.Request) bool {
+	query := req.URL.Query()
+
+	switch req.Method {
+	case http.MethodPut:
+		return !query.Has("partNumber") && req.Header.Get("X-Amz-Copy-Source") == ""
+	case http.MethodPost:
+		return query.Has("uploadId")
+	default:
+		return false
+	}
+}
+
+// createExclusive atomically creates an empty object, failing with
+// writablefs.ErrExist if it already exists.
+func (fsys *s3FS) createExclusive(ctx context.Context, key string) (minio.UploadInfo, error) {
+	fsys.logger.Debug("Creating object if it does not exist", "key", key)
+
+	info, err := fsys.putObjectConditional(ctx, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	}, preconditions{ifNoneMatch: "*"})
+	if errors.Is(err, errPreconditionFailed) {
+		return info, writablefs.ErrExist
+	}
+
+	return info, err
+}
+
+// putObjectConditional uploads an object, provided the given preconditions
+// hold. If they don't, errPreconditionFailed is returned.
+func (fsys *s3FS) putObjectConditional(ctx context.Context, key string, r io.Reader, size int64, opts minio.PutObjectOptions, p preconditions) (minio.UploadInfo, error) {
+	if fsys.conditionalWritesSupported() {
+		info, err := fsys.client.PutObject(withPreconditions(ctx, p), fsys.bucketName, key, r, size, opts)
+		if err == nil {
+			return info, nil
+		}
+
+		if isPreconditionFailed(err) {
+			return info, errPreconditionFailed
+		}
+
+		if !fsys.checkConditionalWritesUnsupported(err) {
+			return info, err
+		}
+
+		// Nothing should have been read from the reader, but just in case.
+		if seeker, ok := r.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return info, err
+			}
+		}
+	}
+
+	// Fallback for providers that don't support conditional writes.
+	// This is not atomic, but it's the best we can do.
+	fsys.logger.Debug("Checking preconditions before uploading object", "key", key)
+
+	info, err := fsys.client.StatObject(ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+	exists := err == nil
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return minio.UploadInfo{}, err
+	}
+
+	if p.ifNoneMatch == "*" && exists {
+		return minio.UploadInfo{}, errPreconditionFailed
+	}
+
+	if p.ifMatch != "" && (!exists || strings.Trim(p.ifMatch, `"`) != strings.Trim(info.ETag, `"`)) {
+		return minio.UploadInfo{}, errPreconditionFailed
+	}
+
+	return fsys.client.PutObject(ctx, fsys.bucketName, key, r, size, opts)
+}
+
+func (fsys *s3FS) conditionalWritesSupported() bool {
+	return !fsys.disableConditionalWrites && !fsys.conditionalWritesUnsupported.Load()
+}
+
+// checkConditionalWritesUnsupported returns true (and remembers it) if the
+// error indicates the provider doesn't support conditional writes.
+func (fsys *s3FS) checkConditionalWritesUnsupported(err error) bool {
+	errResp := minio.ToErrorResponse(err)
+	if errResp.StatusCode != http.StatusNotImplemented && errResp.Code != "NotImplemented" {
+		return false
+	}
+
+	fsys.logger.Warn("Conditional writes are not supported by the provider, falling back to non-atomic checks")
+
+	fsys.conditionalWritesUnsupported.Store(true)
+
+	return true
+}
+
+func isPreconditionFailed(err error) bool {
+	errResp := minio.ToErrorResponse(err)
+
+	switch {
+	case errResp.StatusCode == http.StatusPreconditionFailed, errResp.Code == "PreconditionFailed":
+		return true
+	case errResp.Code == "ConditionalRequestConflict":
+		// A concurrent conditional write to the same key is in progress.
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bucket-sailor/queue"
+	"github.com/bucket-sailor/writablefs"
+	"github.com/hashicorp/go-multierror"
+	"github.com/minio/minio-go/v7"
+)
+
+// Objects larger than this can't be copied with a single CopyObject request.
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+func (fsys *s3FS) Copy(srcPath, dstPath string) error {
+	fsys.logger.Debug("Copying object", "srcPath", srcPath, "dstPath", dstPath)
+
+	srcKey := toKey(srcPath, false)
+	dstKey := toKey(dstPath, false)
+
+	if srcKey == "" || dstKey == "" {
+		return writablefs.ErrInvalid
+	}
+
+	objInfo, err := fsys.client.StatObject(fsys.ctx, fsys.bucketName, srcKey, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return writablefs.ErrNotExist
+		}
+
+		return err
+	}
+
+	if err := fsys.copyXAttrSidecar(fsys.ctx, srcKey, dstKey, objInfo.UserMetadata); err != nil {
+		return err
+	}
+
+	return fsys.copyObject(fsys.ctx, srcKey, dstKey, objInfo.Size)
+}
+
+func (fsys *s3FS) CopyAll(srcPath, dstPath string) error {
+	fsys.logger.Debug("Copying objects", "srcPath", srcPath, "dstPath", dstPath)
+
+	isDir, err := fsys.isDir(fsys.ctx, srcPath)
+	if err != nil {
+		return err
+	}
+
+	if !isDir {
+		return fsys.Copy(srcPath, dstPath)
+	}
+
+	srcKey := toKey(srcPath, true)
+	dstKey := toKey(dstPath, true)
+
+	if strings.HasPrefix(dstKey, srcKey) {
+		// Can't copy a directory into itself.
+		return writablefs.ErrInvalid
+	}
+
+	_, err = fsys.copyObjects(fsys.ctx, srcKey, dstKey)
+	return err
+}
+
+// copyObject copies an object server-side. The user metadata (and therefore
+// xattrs) is copied along with the object.
+func (fsys *s3FS) copyObject(ctx context.Context, srcKey, dstKey string, size int64) error {
+	src := minio.CopySrcOptions{
+		Bucket: fsys.bucketName,
+		Object: srcKey,
+	}
+	dst := minio.CopyDestOptions{
+		Bucket: fsys.bucketName,
+		Object: dstKey,
+	}
+
+	if size > maxCopyObjectSize {
+		// ComposeObject splits the copy into multiple parts.
+		_, err := fsys.client.ComposeObject(ctx, dst, src)
+		return err
+	}
+
+	_, err := fsys.client.CopyObject(ctx, dst, src)
+	return err
+}
+
+// copyObjects copies every object under srcPrefix to dstPrefix (along with
+// their extended attribute sidecars), returning the keys of the objects that
+// were copied.
+func (fsys *s3FS) copyObjects(ctx context.Context, srcPrefix, dstPrefix string) ([]string, error) {
+	const numConnections = 20
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefixes := [][2]string{{srcPrefix, dstPrefix}}
+	if srcPrefix != "" {
+		// The sidecars are stored under their own prefix (unless we are
+		// copying the root directory, which already includes them).
+		prefixes = append(prefixes, [2]string{xattrSidecarKeyPrefix(srcPrefix), xattrSidecarKeyPrefix(dstPrefix)})
+	}
+
+	var resultMu sync.Mutex
+	var result *multierror.Error
+	var srcKeys []string
+
+	q := queue.NewQueue(numConnections)
+
+prefixes:
+	for _, prefix := range prefixes {
+		srcPrefix, dstPrefix := prefix[0], prefix[1]
+
+		objCh := fsys.client.ListObjects(listCtx, fsys.bucketName, minio.ListObjectsOptions{
+			Prefix:    srcPrefix,
+			Recursive: true,
+		})
+
+		for objInfo := range objCh {
+			if objInfo.Err != nil {
+				resultMu.Lock()
+				result = multierror.Append(result, objInfo.Err)
+				resultMu.Unlock()
+
+				break prefixes
+			}
+
+			srcKey := objInfo.Key
+			size := objInfo.Size
+			srcKeys = append(srcKeys, srcKey)
+
+			q.Add(func() error {
+				dstKey := dstPrefix + strings.TrimPrefix(srcKey, srcPrefix)
+
+				fsys.logger.Debug("Copying object", "srcKey", srcKey, "dstKey", dstKey)
+
+				if err := fsys.copyObject(ctx, srcKey, dstKey, size); err != nil {
+					resultMu.Lock()
+					result = multierror.Append(result, fmt.Errorf("failed to copy %q to %q: %w", srcKey, dstKey, err))
+					resultMu.Unlock()
+				}
+
+				// Errors are collected rather than returned, so that we attempt every copy.
+				return nil
+			})
+		}
+	}
+
+	_ = q.Wait()
+
+	// The listing ends early (without an error) if the context is cancelled.
+	if err := ctx.Err(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return srcKeys, result.ErrorOrNil()
+}
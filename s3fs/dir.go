@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"io"
+	"io/fs"
+	"sync"
+	"syscall"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+var (
+	_ writablefs.File = (*dirHandle)(nil)
+	_ fs.ReadDirFile  = (*dirHandle)(nil)
+)
+
+// dirHandle is a read-only handle for a directory, it can only be used to
+// list the directory.
+type dirHandle struct {
+	mu   sync.Mutex
+	fsys *s3FS
+	path string
+	info *fileInfo
+	// The directory listing (started on first use).
+	stream writablefs.DirStream
+}
+
+func (h *dirHandle) ReadDir(n int) ([]writablefs.DirEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stream == nil {
+		stream, err := h.fsys.ReadDirStream(h.fsys.ctx, h.path, "")
+		if err != nil {
+			return nil, err
+		}
+
+		h.stream = stream
+	}
+
+	return h.stream.Next(n)
+}
+
+func (h *dirHandle) Seek(offset int64, whence int) (int64, error) {
+	// Only rewinding the directory is supported.
+	if offset != 0 || whence != io.SeekStart {
+		return 0, &fs.PathError{Op: "seek", Path: h.path, Err: writablefs.ErrInvalid}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stream != nil {
+		_ = h.stream.Close()
+		h.stream = nil
+	}
+
+	return 0, nil
+}
+
+func (h *dirHandle) Stat() (writablefs.FileInfo, error) {
+	return h.info, nil
+}
+
+func (h *dirHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stream != nil {
+		_ = h.stream.Close()
+		h.stream = nil
+	}
+
+	return nil
+}
+
+func (h *dirHandle) Sync() error {
+	return nil
+}
+
+func (h *dirHandle) Read(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: h.path, Err: syscall.EISDIR}
+}
+
+func (h *dirHandle) ReadAt(_ []byte, _ int64) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: h.path, Err: syscall.EISDIR}
+}
+
+func (h *dirHandle) Write(_ []byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: h.path, Err: syscall.EISDIR}
+}
+
+func (h *dirHandle) WriteAt(_ []byte, _ int64) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: h.path, Err: syscall.EISDIR}
+}
+
+func (h *dirHandle) Truncate(_ int64) error {
+	return &fs.PathError{Op: "truncate", Path: h.path, Err: syscall.EISDIR}
+}
+
+func (h *dirHandle) XAttrs() (writablefs.ExtendedAttributes, error) {
+	return nil, &fs.PathError{Op: "xattrs", Path: h.path, Err: writablefs.ErrUnsupported}
+}
@@ -11,6 +11,8 @@ package s3fs
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"path/filepath"
 	"sync"
@@ -20,7 +22,8 @@ import (
 )
 
 var (
-	_ writablefs.File = (*fileHandle)(nil)
+	_ writablefs.File        = (*fileHandle)(nil)
+	_ writablefs.ContextFile = (*fileHandle)(nil)
 )
 
 // file is an s3 object that is shared between multiple virtual file handles.
@@ -37,53 +40,113 @@ type file struct {
 	stagingFile writablefs.File
 	// Are there any staged changes?
 	dirty bool
+	// The version of the remote object the staging file is based on.
+	// An empty etag means the object did not exist.
+	etag      string
+	versionID string
+	// The user metadata of the remote object, carried over when uploading.
+	userMetadata map[string]string
+	// The extended attributes of the file (decoded), shared by every handle.
+	// If there are staged changes, these are the attributes of the version
+	// the changes are based on.
+	xattrs map[string]string
+	// Incremented whenever xattrs is replaced, so that a load that was
+	// started earlier doesn't overwrite a newer version.
+	xattrsGen int
+	// Pending changes to the extended attributes, made through any handle.
+	xattrChanges map[string]attrChange
+	// Serializes uploads of the object. The etag of an object only depends on
+	// its contents, so conditional requests can't detect concurrent changes
+	// to its metadata made through other handles.
+	syncMu sync.Mutex
 	// The file handles that are currently open.
 	handles map[*fileHandle]struct{}
 }
 
 // newHandle creates a new handle for this file.
-func (f *file) newHandle(flag writablefs.FileOpenFlag) (*fileHandle, error) {
+func (f *file) newHandle(ctx context.Context, flag writablefs.FileOpenFlag) (*fileHandle, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	readOnly := flag.IsSet(writablefs.FlagReadOnly)
+	readOnly := flag.IsReadOnly()
+	create := flag.IsSet(writablefs.FlagCreate)
+	exclusive := create && flag.IsSet(writablefs.FlagExclusive)
+	truncate := flag.IsSet(writablefs.FlagTruncate) && !readOnly
 
-	if !readOnly && f.stagingFile == nil {
-		f.fsys.logger.Debug("Creating staging file", "key", f.key)
-
-		if err := f.fsys.stagingFS.MkdirAll(filepath.Dir(f.key)); err != nil {
+	var exists, checked bool
+	var info minio.ObjectInfo
+	if f.stagingFile != nil {
+		exists, checked = true, true
+	} else if readOnly || truncate {
+		// We only need to check for the object up front if we aren't going to
+		// download it (which will tell us if it exists).
+		var err error
+		info, exists, err = f.stat(ctx)
+		if err != nil {
 			return nil, err
 		}
+		checked = true
 
-		var err error
-		f.stagingFile, err = f.fsys.stagingFS.OpenFile(f.key, writablefs.FlagReadWrite|writablefs.FlagCreate)
+		if !exists && !create {
+			return nil, writablefs.ErrNotExist
+		}
+
+		if target, ok := symlinkTarget(info); ok {
+			return nil, &symlinkError{target: target}
+		}
+	}
+
+	if exclusive {
+		if exists {
+			return nil, writablefs.ErrExist
+		}
+
+		// Claim the key by creating an empty object, so that concurrent
+		// writers (possibly on other machines) can't also create it.
+		uploadInfo, err := f.fsys.createExclusive(ctx, f.key)
 		if err != nil {
 			return nil, err
 		}
 
-		f.fsys.logger.Debug("Attempting to download existing object into staging file", "key", f.key)
+		info = minio.ObjectInfo{ETag: uploadInfo.ETag, VersionID: uploadInfo.VersionID}
+	}
 
-		obj, err := f.fsys.client.GetObject(f.fsys.ctx, f.fsys.bucketName, f.key, minio.GetObjectOptions{})
-		if err != nil {
+	if (!readOnly || create) && f.stagingFile == nil {
+		if err := f.createStagingFile(); err != nil {
 			return nil, err
 		}
-		defer obj.Close()
 
-		if _, err = io.Copy(f.stagingFile, obj); err != nil {
-			if minio.ToErrorResponse(err).Code == "NoSuchKey" && flag.IsSet(writablefs.FlagCreate) {
-				// If the object doesn't exist, that's fine.
-				f.fsys.logger.Debug("Creating new object", "key", f.key)
-				f.dirty = true
-			} else {
-				return nil, err
-			}
+		if exclusive {
+			f.fsys.logger.Debug("Created new object", "key", f.key)
+
+			f.etag, f.versionID = info.ETag, info.VersionID
+			f.userMetadata = nil
+		} else if truncate || (checked && !exists) {
+			f.fsys.logger.Debug("Skipping download of existing object", "key", f.key)
+
+			f.etag, f.versionID = info.ETag, info.VersionID
+			f.userMetadata = info.UserMetadata
+			f.dirty = true
+		} else if err := f.download(ctx, create); err != nil {
+			_ = f.removeStagingFile()
+
+			return nil, err
+		}
+	} else if truncate {
+		f.fsys.logger.Debug("Truncating staging file", "key", f.key)
+
+		if err := f.stagingFile.Truncate(0); err != nil {
+			return nil, err
 		}
+
+		f.dirty = true
 	}
 
 	h := &fileHandle{
 		fsys:     f.fsys,
 		file:     f,
 		readOnly: readOnly,
+		append:   flag.IsSet(writablefs.FlagAppend),
 	}
 
 	f.handles[h] = struct{}{}
@@ -91,35 +154,139 @@ func (f *file) newHandle(flag writablefs.FileOpenFlag) (*fileHandle, error) {
 	return h, nil
 }
 
-func (f *file) Close() error {
+// stat gets the status of the remote object (and whether it exists).
+func (f *file) stat(ctx context.Context) (minio.ObjectInfo, bool, error) {
+	info, err := f.fsys.client.StatObject(ctx, f.fsys.bucketName, f.key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return minio.ObjectInfo{}, false, nil
+		}
+
+		return minio.ObjectInfo{}, false, err
+	}
+
+	return info, true, nil
+}
+
+func (f *file) createStagingFile() error {
+	f.fsys.logger.Debug("Creating staging file", "key", f.key)
+
+	if err := f.fsys.stagingFS.MkdirAll(filepath.Dir(f.key)); err != nil {
+		return err
+	}
+
+	var err error
+	f.stagingFile, err = f.fsys.stagingFS.OpenFile(f.key, writablefs.FlagReadWrite|writablefs.FlagCreate|writablefs.FlagTruncate)
+	return err
+}
+
+func (f *file) removeStagingFile() error {
+	f.fsys.logger.Debug("Removing staging file", "key", f.key)
+
+	if err := f.stagingFile.Close(); err != nil {
+		return err
+	}
+
+	f.stagingFile = nil
+
+	return f.fsys.stagingFS.RemoveAll(f.key)
+}
+
+// download copies the existing object (if any) into the staging file.
+func (f *file) download(ctx context.Context, create bool) error {
+	f.fsys.logger.Debug("Attempting to download existing object into staging file", "key", f.key)
+
+	obj, err := f.fsys.client.GetObject(ctx, f.fsys.bucketName, f.key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	if _, err = io.Copy(f.stagingFile, obj); err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return err
+		}
+
+		if !create {
+			return writablefs.ErrNotExist
+		}
+
+		// If the object doesn't exist, that's fine.
+		f.fsys.logger.Debug("Creating new object", "key", f.key)
+		f.etag, f.versionID = "", ""
+		f.userMetadata = nil
+		f.dirty = true
+
+		return nil
+	}
+
+	// Record the version we downloaded so we can detect concurrent modifications.
+	info, err := obj.Stat()
+	if err != nil {
+		return err
+	}
+
+	if target, ok := symlinkTarget(info); ok {
+		return &symlinkError{target: target}
+	}
+
+	f.etag, f.versionID = info.ETag, info.VersionID
+	f.userMetadata = info.UserMetadata
+
+	return nil
+}
+
+// metadataUpdated is called when the user metadata of the remote object has
+// been replaced (without modifying its contents) by an upload with oldETag.
+// The (decoded) metadata is also provided.
+func (f *file) metadataUpdated(oldETag string, uploadInfo minio.UploadInfo, userMetadata, metadata map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Only if the file is based on the version we just replaced.
+	if f.etag == oldETag {
+		f.etag, f.versionID = uploadInfo.ETag, uploadInfo.VersionID
+		f.userMetadata = userMetadata
+	}
+
+	if f.xattrs != nil {
+		f.setXAttrs(metadata)
+	}
+}
+
+func (f *file) Close(ctx context.Context) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	lastClose := len(f.handles) == 0
 	if lastClose {
 		if f.dirty {
+			// Uncommitted changes to the extended attributes are uploaded
+			// along with the contents.
 			f.mu.Unlock()
-			err := f.Sync()
+			err := f.Sync(ctx)
 			f.mu.Lock()
+			if errors.Is(err, writablefs.ErrConflict) && f.stagingFile != nil {
+				// Our changes can never be uploaded, so don't keep them around
+				// for the next time the file is opened.
+				f.dirty = false
+				_ = f.removeStagingFile()
+			}
 			if err != nil {
 				return err
 			}
 		}
 
+		// Otherwise they are discarded.
+		f.xattrs, f.xattrChanges = nil, nil
+		f.xattrsGen++
+
 		// TODO: maybe we should keep this laying around so that we can potentially
 		// avoid re-downloading the object if it's opened again soon.
 		if f.stagingFile != nil {
-			f.fsys.logger.Debug("Removing staging file", "key", f.key)
-
-			if err := f.stagingFile.Close(); err != nil {
-				return err
-			}
-
-			if err := f.fsys.stagingFS.RemoveAll(f.key); err != nil {
+			if err := f.removeStagingFile(); err != nil {
 				return err
 			}
-
-			f.stagingFile = nil
 		}
 	}
 
@@ -142,11 +309,30 @@ func (f *file) WriteAt(p []byte, off int64) (int, error) {
 	return n, nil
 }
 
+// Append writes to the end of the staging file.
+func (f *file) Append(p []byte) (int, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := f.stagingFile.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := f.stagingFile.WriteAt(p, fi.Size())
+	if n > 0 {
+		f.dirty = true
+	}
+
+	return n, fi.Size() + int64(n), err
+}
+
 func (f *file) Stat() (writablefs.FileInfo, error) {
 	f.mu.Lock()
 
 	if f.stagingFile != nil {
 		fi, err := f.stagingFile.Stat()
+		userMetadata := uploadMetadata(f.userMetadata)
 		f.mu.Unlock()
 		if err != nil {
 			return nil, err
@@ -157,6 +343,7 @@ func (f *file) Stat() (writablefs.FileInfo, error) {
 				Key:          f.key,
 				Size:         fi.Size(),
 				LastModified: fi.ModTime(),
+				UserMetadata: userMetadata,
 			},
 		}, nil
 	}
@@ -166,13 +353,44 @@ func (f *file) Stat() (writablefs.FileInfo, error) {
 	return f.fsys.Stat(f.key)
 }
 
-func (f *file) Sync() error {
+func (f *file) Sync(ctx context.Context) error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	return f.sync(ctx)
+}
+
+// sync uploads any staged changes, along with any pending changes to the
+// extended attributes. f.syncMu and f.mu must be held.
+func (f *file) sync(ctx context.Context) error {
 	if f.dirty {
 		f.fsys.logger.Debug("Uploading modified object", "key", f.key)
 
+		userMetadata := uploadMetadata(f.userMetadata)
+
+		var metadata map[string]string
+		changes := f.xattrChanges
+		if len(changes) > 0 {
+			// The metadata of the version the staged changes are based on.
+			var err error
+			metadata, err = f.fsys.decodeMetadata(ctx, f.key, f.userMetadata)
+			if err != nil {
+				return err
+			}
+
+			applyXAttrChanges(metadata, changes)
+
+			encodedMetadata, err := f.fsys.encodeMetadata(ctx, f.key, metadata)
+			if err != nil {
+				return err
+			}
+
+			userMetadata = uploadMetadata(encodedMetadata)
+		}
+
 		if _, err := f.stagingFile.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
@@ -186,22 +404,82 @@ func (f *file) Sync() error {
 			return err
 		}
 
-		_, err = f.fsys.client.PutObject(f.ctx, f.fsys.bucketName, f.key, f.stagingFile, fi.Size(), minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
-		})
+		// Only overwrite the version of the object we started with.
+		p := preconditions{ifNoneMatch: "*"}
+		if f.etag != "" {
+			p = preconditions{ifMatch: `"` + f.etag + `"`}
+		}
+
+		info, err := f.fsys.putObjectConditional(ctx, f.key, f.stagingFile, fi.Size(), minio.PutObjectOptions{
+			ContentType:  "application/octet-stream",
+			UserMetadata: userMetadata,
+		}, p)
 		if err != nil {
+			if len(changes) > 0 {
+				f.fsys.removeXAttrSidecar(ctx, f.key, userMetadata)
+			}
+
+			if errors.Is(err, errPreconditionFailed) {
+				f.fsys.logger.Debug("Remote object was modified concurrently", "key", f.key)
+
+				return writablefs.ErrConflict
+			}
+
 			return err
 		}
 
+		if len(changes) > 0 {
+			// The previous sidecar (if any) has been replaced.
+			f.fsys.removeXAttrSidecar(ctx, f.key, f.userMetadata)
+
+			f.setXAttrs(metadata)
+			f.xattrChanges = nil
+		}
+
+		f.etag, f.versionID = info.ETag, info.VersionID
+		f.userMetadata = userMetadata
 		f.dirty = false
+	} else if f.stagingFile != nil && f.fsys.refreshOnSync {
+		if err := f.refresh(ctx); err != nil {
+			return err
+		}
 	}
 
-	// TODO: we should also check if the remote object has been modified and
-	// download it if it has.
-
 	return nil
 }
 
+// refresh re-downloads the staging file if the remote object has changed.
+// It must only be called when there are no pending changes.
+func (f *file) refresh(ctx context.Context) error {
+	info, exists, err := f.stat(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		// We'll recreate the object on the next sync.
+		f.fsys.logger.Debug("Remote object was removed", "key", f.key)
+
+		return nil
+	}
+
+	if info.ETag == f.etag && info.VersionID == f.versionID {
+		return nil
+	}
+
+	f.fsys.logger.Debug("Remote object was modified, refreshing staging file", "key", f.key)
+
+	if err := f.stagingFile.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := f.stagingFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return f.download(ctx, false)
+}
+
 func (f *file) Truncate(size int64) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -228,33 +506,53 @@ type fileHandle struct {
 	// The file this handle is associated with.
 	file     *file
 	readOnly bool
+	// Should writes always go to the end of the file?
+	append bool
 	// The current offset in the file.
 	offset int64
 	// An open object handle (if any).
 	// This is used in sequential read mode.
 	obj *minio.Object
+	// Aborts the request of the object handle.
+	objCancel context.CancelFunc
 }
 
 func (h *fileHandle) Close() error {
+	return h.CloseContext(h.file.ctx)
+}
+
+func (h *fileHandle) CloseContext(ctx context.Context) error {
+	ctx, cancel := h.fsys.mergeContext(ctx)
+	defer cancel()
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	h.fsys.logger.Debug("Closing object", "key", h.file.key)
 
-	if h.obj != nil {
-		h.obj.Close()
-	}
+	_ = h.closeObject()
 
 	h.file.mu.Lock()
 	delete(h.file.handles, h)
 	h.file.mu.Unlock()
 
-	return h.file.Close()
+	return h.file.Close(ctx)
 }
 
-func (h *fileHandle) Read(p []byte) (n int, err error) {
+func (h *fileHandle) Read(p []byte) (int, error) {
+	return h.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but if ctx is cancelled the remote object stream
+// is aborted (and reopened by the next read). Otherwise the stream is kept
+// open between reads.
+func (h *fileHandle) ReadContext(ctx context.Context, p []byte) (n int, err error) {
 	h.fsys.logger.Debug("Reading from object", "key", h.file.key)
 
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	h.file.mu.Lock()
 
 	if h.file.stagingFile != nil {
@@ -273,18 +571,40 @@ func (h *fileHandle) Read(p []byte) (n int, err error) {
 
 		if h.obj == nil {
 			var opts minio.GetObjectOptions
-			if err := opts.SetRange(h.offset, -1); err != nil {
-				return 0, err
+			if h.offset > 0 {
+				if err := opts.SetRange(h.offset, 0); err != nil {
+					return 0, err
+				}
 			}
 
+			objCtx, objCancel := context.WithCancel(h.file.ctx)
+
 			var err error
-			h.obj, err = h.fsys.client.GetObject(h.file.ctx, h.fsys.bucketName, h.file.key, opts)
+			h.obj, err = h.fsys.client.GetObject(objCtx, h.fsys.bucketName, h.file.key, opts)
 			if err != nil {
+				objCancel()
 				return 0, err
 			}
+
+			h.objCancel = objCancel
 		}
 
+		stop := context.AfterFunc(ctx, h.objCancel)
+
 		n, err = h.obj.Read(p)
+		if err != nil && minio.ToErrorResponse(err).Code == "InvalidRange" {
+			// We're at (or past) the end of the object.
+			err = io.EOF
+		}
+
+		if !stop() {
+			// The stream was aborted, so don't reuse it.
+			_ = h.closeObject()
+
+			if err != nil {
+				err = ctx.Err()
+			}
+		}
 	}
 
 	h.offset += int64(n)
@@ -292,7 +612,29 @@ func (h *fileHandle) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// closeObject closes the remote object stream (if any), h.mu must be held.
+func (h *fileHandle) closeObject() error {
+	if h.obj == nil {
+		return nil
+	}
+
+	err := h.obj.Close()
+	h.objCancel()
+
+	h.obj = nil
+	h.objCancel = nil
+
+	return err
+}
+
 func (h *fileHandle) ReadAt(p []byte, off int64) (int, error) {
+	return h.ReadAtContext(h.file.ctx, p, off)
+}
+
+func (h *fileHandle) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	ctx, cancel := h.fsys.mergeContext(ctx)
+	defer cancel()
+
 	h.fsys.logger.Debug("Reading from object at offset", "key", h.file.key, "offset", off)
 
 	h.file.mu.Lock()
@@ -308,19 +650,29 @@ func (h *fileHandle) ReadAt(p []byte, off int64) (int, error) {
 
 		h.fsys.logger.Debug("Reading from remote object", "key", h.file.key, "offset", off)
 
+		if len(p) == 0 {
+			return 0, nil
+		}
+
 		opts := minio.GetObjectOptions{}
-		if err := opts.SetRange(off, -1); err != nil {
+		if err := opts.SetRange(off, off+int64(len(p))-1); err != nil {
 			return 0, err
 		}
 
 		var err error
-		obj, err := h.fsys.client.GetObject(h.file.ctx, h.fsys.bucketName, h.file.key, opts)
+		obj, err := h.fsys.client.GetObject(ctx, h.fsys.bucketName, h.file.key, opts)
 		if err != nil {
 			return 0, err
 		}
 		defer obj.Close()
 
-		return obj.Read(p)
+		n, err := io.ReadFull(obj, p)
+		if errors.Is(err, io.ErrUnexpectedEOF) || (err != nil && minio.ToErrorResponse(err).Code == "InvalidRange") {
+			// ReaderAt requires an error for short reads.
+			err = io.EOF
+		}
+
+		return n, err
 	}
 }
 
@@ -331,6 +683,13 @@ func (h *fileHandle) Write(p []byte) (n int, err error) {
 		return 0, writablefs.ErrPermission
 	}
 
+	if h.append {
+		var end int64
+		n, end, err = h.file.Append(p)
+		h.offset = end
+		return n, err
+	}
+
 	n, err = h.file.WriteAt(p, h.offset)
 	h.offset += int64(n)
 	return n, err
@@ -343,6 +702,11 @@ func (h *fileHandle) WriteAt(p []byte, off int64) (int, error) {
 		return 0, writablefs.ErrPermission
 	}
 
+	// Match the behavior of os.File.
+	if h.append {
+		return 0, fmt.Errorf("invalid use of WriteAt on file opened with FlagAppend: %w", writablefs.ErrInvalid)
+	}
+
 	return h.file.WriteAt(p, off)
 }
 
@@ -366,12 +730,8 @@ func (h *fileHandle) Seek(offset int64, whence int) (int64, error) {
 		h.offset = fi.Size() + offset
 	}
 
-	if h.obj != nil {
-		if err := h.obj.Close(); err != nil {
-			return 0, err
-		}
-
-		h.obj = nil
+	if err := h.closeObject(); err != nil {
+		return 0, err
 	}
 
 	return h.offset, nil
@@ -384,9 +744,16 @@ func (h *fileHandle) Stat() (writablefs.FileInfo, error) {
 }
 
 func (h *fileHandle) Sync() error {
+	return h.SyncContext(h.file.ctx)
+}
+
+func (h *fileHandle) SyncContext(ctx context.Context) error {
+	ctx, cancel := h.fsys.mergeContext(ctx)
+	defer cancel()
+
 	h.fsys.logger.Debug("Syncing object", "key", h.file.key)
 
-	return h.file.Sync()
+	return h.file.Sync(ctx)
 }
 
 func (h *fileHandle) Truncate(size int64) error {
@@ -0,0 +1,133 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"io/fs"
+	gopath "path"
+	"sort"
+	"strings"
+)
+
+var _ fs.GlobFS = (*s3FS)(nil)
+
+// Glob works like fs.Glob, but each directory listing is limited to the keys
+// starting with the literal prefix of the pattern (eg. "data-*.csv" only lists
+// the keys starting with "data-").
+func (fsys *s3FS) Glob(pattern string) ([]string, error) {
+	// Check the pattern is well-formed.
+	if _, err := gopath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return fsys.glob(pattern, 0)
+}
+
+func (fsys *s3FS) glob(pattern string, depth int) ([]string, error) {
+	// Protect against deeply nested patterns (like fs.Glob).
+	if depth > 10000 {
+		return nil, gopath.ErrBadPattern
+	}
+
+	if !hasMeta(pattern) {
+		if _, err := fsys.Stat(pattern); err != nil {
+			return nil, nil
+		}
+
+		return []string{pattern}, nil
+	}
+
+	dir, file := gopath.Split(pattern)
+	dir = cleanGlobPath(dir)
+
+	if !hasMeta(dir) {
+		return fsys.globDir(dir, file, nil)
+	}
+
+	// Prevent infinite recursion.
+	if dir == pattern {
+		return nil, gopath.ErrBadPattern
+	}
+
+	dirs, err := fsys.glob(dir, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	for _, dir := range dirs {
+		matches, err = fsys.globDir(dir, file, matches)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return matches, nil
+}
+
+// globDir appends the entries in dir that match the pattern to matches.
+// Errors listing the directory are ignored (like fs.Glob).
+func (fsys *s3FS) globDir(dir, pattern string, matches []string) ([]string, error) {
+	s, err := fsys.openDirStream(fsys.ctx, dir, "", literalPrefix(pattern))
+	if err != nil {
+		return matches, nil
+	}
+	defer s.Close()
+
+	entries, err := s.Next(-1)
+	if err != nil {
+		return matches, nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		matched, err := gopath.Match(pattern, name)
+		if err != nil {
+			return matches, err
+		}
+
+		if matched {
+			matches = append(matches, gopath.Join(dir, name))
+		}
+	}
+
+	return matches, nil
+}
+
+// literalPrefix returns the part of the pattern before any special characters.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+
+	return pattern
+}
+
+// cleanGlobPath prepares path for glob matching.
+func cleanGlobPath(path string) string {
+	switch path {
+	case "":
+		return "."
+	default:
+		return path[0 : len(path)-1] // chop off trailing separator
+	}
+}
+
+// hasMeta reports whether path contains any of the magic characters
+// recognized by path.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
@@ -12,6 +12,7 @@ package s3fs
 import (
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bucket-sailor/writablefs"
@@ -19,10 +20,17 @@ import (
 )
 
 type dirEntry struct {
-	name    string
-	size    int64
-	modTime time.Time
-	isDir   bool
+	fsys *s3FS
+	// The key of the object (directories have a trailing slash).
+	key   string
+	name  string
+	isDir bool
+	// User metadata (if known), used to identify symbolic links.
+	userMetadata minio.StringMap
+	// Listings don't include user metadata (eg. POSIX attributes), so the
+	// full status of the object is fetched on demand.
+	infoMu sync.Mutex
+	info   *fileInfo
 }
 
 func (e *dirEntry) Name() string {
@@ -31,27 +39,47 @@ func (e *dirEntry) Name() string {
 
 func (e *dirEntry) IsDir() bool {
 	return e.isDir
-
 }
+
 func (e *dirEntry) Type() writablefs.FileMode {
+	if e.isDir {
+		return writablefs.ModeDir
+	}
+
+	if _, ok := symlinkTarget(minio.ObjectInfo{UserMetadata: e.userMetadata}); ok {
+		return writablefs.ModeSymlink
+	}
+
 	return 0
 }
 
 func (e *dirEntry) Info() (writablefs.FileInfo, error) {
-	return &fileInfo{
-		info: minio.ObjectInfo{
-			Key:          e.name,
-			Size:         e.size,
-			LastModified: e.modTime,
-		},
-	}, nil
+	e.infoMu.Lock()
+	defer e.infoMu.Unlock()
+
+	if e.info == nil {
+		fi, err := e.fsys.lstat(e.fsys.ctx, e.key)
+		if err != nil {
+			return nil, pathError("stat", e.key, err)
+		}
+
+		e.info = fi
+	}
+
+	return e.info, nil
 }
 
 type fileInfo struct {
 	info minio.ObjectInfo
+	// Overrides the name derived from the key (eg. for symbolic links).
+	name string
 }
 
 func (fi *fileInfo) Name() string {
+	if fi.name != "" {
+		return fi.name
+	}
+
 	return filepath.Base(fi.info.Key)
 }
 
@@ -60,10 +88,29 @@ func (fi *fileInfo) Size() int64 {
 }
 
 func (fi *fileInfo) Mode() writablefs.FileMode {
-	return 0
+	var fileType, perm writablefs.FileMode
+
+	switch _, isSymlink := symlinkTarget(fi.info); {
+	case fi.IsDir():
+		fileType, perm = writablefs.ModeDir, 0o755
+	case isSymlink:
+		fileType, perm = writablefs.ModeSymlink, 0o777
+	default:
+		perm = 0o644
+	}
+
+	if storedPerm, ok := permissions(fi.info); ok {
+		perm = storedPerm
+	}
+
+	return fileType | perm
 }
 
 func (fi *fileInfo) ModTime() time.Time {
+	if mtime, ok := storedTime(fi.info, fileMtimeMetadataKey, fuseMtimeMetadataKey); ok {
+		return mtime
+	}
+
 	return fi.info.LastModified
 }
 
@@ -72,5 +119,12 @@ func (fi *fileInfo) IsDir() bool {
 }
 
 func (fi *fileInfo) Sys() any {
-	return nil
+	uid, gid := owner(fi.info)
+	atime, _ := storedTime(fi.info, fileAtimeMetadataKey, fuseAtimeMetadataKey)
+
+	return &Stat{
+		UID:   uid,
+		GID:   gid,
+		Atime: atime,
+	}
 }
@@ -0,0 +1,374 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+// POSIX attributes are stored in user metadata using the same keys as AWS FSx
+// for Lustre (https://docs.aws.amazon.com/fsx/latest/LustreGuide/posix-metadata-support.html).
+const (
+	filePermissionsMetadataKey = "file-permissions" // octal mode, including the file type.
+	fileOwnerMetadataKey       = "file-owner"       // decimal uid.
+	fileGroupMetadataKey       = "file-group"       // decimal gid.
+	fileAtimeMetadataKey       = "file-atime"       // nanoseconds since the epoch, with a "ns" suffix.
+	fileMtimeMetadataKey       = "file-mtime"       // nanoseconds since the epoch, with a "ns" suffix.
+)
+
+// The keys used by s3fs-fuse, which we read if the FSx keys are missing.
+const (
+	fuseModeMetadataKey  = "mode"  // decimal mode, including the file type.
+	fuseUIDMetadataKey   = "uid"   // decimal uid.
+	fuseGIDMetadataKey   = "gid"   // decimal gid.
+	fuseAtimeMetadataKey = "atime" // seconds since the epoch.
+	fuseMtimeMetadataKey = "mtime" // seconds since the epoch.
+)
+
+// Unix file type and permission bits.
+const (
+	unixTypeDir     = 0o040000
+	unixTypeRegular = 0o100000
+	unixTypeSymlink = 0o120000
+	unixSetuid      = 0o4000
+	unixSetgid      = 0o2000
+	unixSticky      = 0o1000
+)
+
+// Stat contains the POSIX attributes of an object, it is returned by
+// FileInfo.Sys().
+type Stat struct {
+	// The owner of the object (or -1 if unknown).
+	UID int
+	GID int
+	// The last access time of the object (or zero if unknown).
+	Atime time.Time
+}
+
+func (fsys *s3FS) Chmod(name string, mode writablefs.FileMode) error {
+	fsys.logger.Debug("Changing mode", "name", name, "mode", mode)
+
+	key, fi, err := fsys.metadataKey(name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.updateMetadata(fsys.ctx, key, func(userMetadata map[string]string) {
+		userMetadata[filePermissionsMetadataKey] = fmt.Sprintf("%#o", toUnixMode(fi.Mode().Type()|mode&^writablefs.ModeType))
+	})
+}
+
+func (fsys *s3FS) Chown(name string, uid, gid int) error {
+	fsys.logger.Debug("Changing owner", "name", name, "uid", uid, "gid", gid)
+
+	key, _, err := fsys.metadataKey(name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.updateMetadata(fsys.ctx, key, func(userMetadata map[string]string) {
+		// Like os.Chown, -1 means leave unchanged.
+		if uid != -1 {
+			userMetadata[fileOwnerMetadataKey] = strconv.Itoa(uid)
+		}
+
+		if gid != -1 {
+			userMetadata[fileGroupMetadataKey] = strconv.Itoa(gid)
+		}
+	})
+}
+
+func (fsys *s3FS) Chtimes(name string, atime, mtime time.Time) error {
+	fsys.logger.Debug("Changing times", "name", name, "atime", atime, "mtime", mtime)
+
+	key, _, err := fsys.metadataKey(name)
+	if err != nil {
+		return err
+	}
+
+	return fsys.updateMetadata(fsys.ctx, key, func(userMetadata map[string]string) {
+		// Like os.Chtimes, the zero time means leave unchanged.
+		if !atime.IsZero() {
+			userMetadata[fileAtimeMetadataKey] = formatTime(atime)
+		}
+
+		if !mtime.IsZero() {
+			userMetadata[fileMtimeMetadataKey] = formatTime(mtime)
+		}
+	})
+}
+
+// metadataKey returns the key of the object that holds the POSIX attributes
+// for the named file (following symbolic links).
+func (fsys *s3FS) metadataKey(name string) (string, *fileInfo, error) {
+	resolvedPath, fi, err := fsys.followSymlinks(fsys.ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := toKey(resolvedPath, fi.IsDir())
+	if key == "" {
+		// The root directory has no object to store attributes on.
+		return "", nil, fmt.Errorf("cannot change attributes of root directory: %w", writablefs.ErrInvalid)
+	}
+
+	return key, fi, nil
+}
+
+// updateMetadata replaces the user metadata of an object, without modifying
+// its contents. The update function is given the decoded metadata (see
+// decodeMetadata).
+func (fsys *s3FS) updateMetadata(ctx context.Context, key string, update func(metadata map[string]string)) error {
+	info, err := fsys.client.StatObject(ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return err
+		}
+
+		if !strings.HasSuffix(key, "/") {
+			return writablefs.ErrNotExist
+		}
+
+		// A directory without a marker object, so create one.
+		metadata := make(map[string]string)
+		update(metadata)
+
+		userMetadata, err := fsys.encodeMetadata(ctx, key, metadata)
+		if err != nil {
+			return err
+		}
+
+		_, err = fsys.client.PutObject(ctx, fsys.bucketName, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+			UserMetadata: userMetadata,
+		})
+		if err != nil {
+			fsys.removeXAttrSidecar(ctx, key, userMetadata)
+		}
+
+		return err
+	}
+
+	metadata, err := fsys.decodeMetadata(ctx, key, info.UserMetadata)
+	if err != nil {
+		return err
+	}
+
+	update(metadata)
+
+	_, err = fsys.replaceMetadata(ctx, key, info, metadata)
+	return err
+}
+
+// replaceMetadata replaces the user metadata of an object with the (decoded)
+// metadata, provided the object hasn't changed since info was retrieved. The
+// stored user metadata is returned.
+func (fsys *s3FS) replaceMetadata(ctx context.Context, key string, info minio.ObjectInfo, metadata map[string]string) (map[string]string, error) {
+	userMetadata, err := fsys.encodeMetadata(ctx, key, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure we don't clobber a concurrent modification.
+	src := minio.CopySrcOptions{
+		Bucket:    fsys.bucketName,
+		Object:    key,
+		MatchETag: info.ETag,
+	}
+	dst := minio.CopyDestOptions{
+		Bucket:          fsys.bucketName,
+		Object:          key,
+		UserMetadata:    userMetadata,
+		ReplaceMetadata: true,
+	}
+
+	var uploadInfo minio.UploadInfo
+	if info.Size > maxCopyObjectSize {
+		uploadInfo, err = fsys.client.ComposeObject(ctx, dst, src)
+	} else {
+		uploadInfo, err = fsys.client.CopyObject(ctx, dst, src)
+	}
+	if err != nil {
+		fsys.removeXAttrSidecar(ctx, key, userMetadata)
+
+		if isPreconditionFailed(err) {
+			return nil, writablefs.ErrConflict
+		}
+
+		return nil, err
+	}
+
+	// The previous sidecar (if any) has been replaced.
+	fsys.removeXAttrSidecar(ctx, key, info.UserMetadata)
+
+	// Don't treat our own change as a conflicting one for any open files.
+	fsys.filesMu.Lock()
+	defer fsys.filesMu.Unlock()
+
+	for _, f := range fsys.files {
+		if f.key == key {
+			f.metadataUpdated(info.ETag, uploadInfo, userMetadata, metadata)
+		}
+	}
+
+	return userMetadata, nil
+}
+
+// uploadMetadata returns the user metadata to carry over when the contents of
+// an object are replaced.
+func uploadMetadata(userMetadata map[string]string) map[string]string {
+	uploadMetadata := make(map[string]string, len(userMetadata))
+	for key, value := range userMetadata {
+		switch strings.ToLower(key) {
+		case fileAtimeMetadataKey, fileMtimeMetadataKey, fuseAtimeMetadataKey, fuseMtimeMetadataKey:
+			// The times will now be that of the upload.
+		default:
+			uploadMetadata[key] = value
+		}
+	}
+
+	return uploadMetadata
+}
+
+// permissions returns the stored permission bits of an object (if any).
+func permissions(info minio.ObjectInfo) (writablefs.FileMode, bool) {
+	if value, ok := metadataValue(info, filePermissionsMetadataKey); ok {
+		if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return fromUnixMode(uint32(mode)), true
+		}
+	}
+
+	if value, ok := metadataValue(info, fuseModeMetadataKey); ok {
+		if mode, err := strconv.ParseUint(value, 10, 32); err == nil {
+			return fromUnixMode(uint32(mode)), true
+		}
+	}
+
+	return 0, false
+}
+
+// owner returns the stored uid and gid of an object (or -1 if unknown).
+func owner(info minio.ObjectInfo) (int, int) {
+	uid, gid := -1, -1
+
+	for _, key := range []string{fileOwnerMetadataKey, fuseUIDMetadataKey} {
+		if value, ok := metadataValue(info, key); ok {
+			if id, err := strconv.Atoi(value); err == nil {
+				uid = id
+				break
+			}
+		}
+	}
+
+	for _, key := range []string{fileGroupMetadataKey, fuseGIDMetadataKey} {
+		if value, ok := metadataValue(info, key); ok {
+			if id, err := strconv.Atoi(value); err == nil {
+				gid = id
+				break
+			}
+		}
+	}
+
+	return uid, gid
+}
+
+// storedTime returns a time stored in the given FSx (or s3fs-fuse) user metadata key.
+func storedTime(info minio.ObjectInfo, key, fuseKey string) (time.Time, bool) {
+	if value, ok := metadataValue(info, key); ok {
+		if t, err := parseTime(value); err == nil {
+			return t, true
+		}
+	}
+
+	if value, ok := metadataValue(info, fuseKey); ok {
+		secs, _, _ := strings.Cut(value, ".")
+		if sec, err := strconv.ParseInt(secs, 10, 64); err == nil {
+			return time.Unix(sec, 0), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// isPOSIXMetadataKey reports whether the user metadata key holds a POSIX
+// attribute. This includes the s3fs-fuse keys, as they are read as POSIX
+// attributes (so they can't also be extended attributes).
+func isPOSIXMetadataKey(key string) bool {
+	switch strings.ToLower(key) {
+	case filePermissionsMetadataKey, fileOwnerMetadataKey, fileGroupMetadataKey, fileAtimeMetadataKey, fileMtimeMetadataKey,
+		fuseModeMetadataKey, fuseUIDMetadataKey, fuseGIDMetadataKey, fuseAtimeMetadataKey, fuseMtimeMetadataKey:
+		return true
+	default:
+		return false
+	}
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10) + "ns"
+}
+
+func parseTime(value string) (time.Time, error) {
+	ns, err := strconv.ParseInt(strings.TrimSuffix(value, "ns"), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, ns), nil
+}
+
+func toUnixMode(mode writablefs.FileMode) uint32 {
+	unixMode := uint32(mode.Perm())
+
+	switch {
+	case mode.IsDir():
+		unixMode |= unixTypeDir
+	case mode&writablefs.ModeSymlink != 0:
+		unixMode |= unixTypeSymlink
+	default:
+		unixMode |= unixTypeRegular
+	}
+
+	if mode&writablefs.ModeSetuid != 0 {
+		unixMode |= unixSetuid
+	}
+	if mode&writablefs.ModeSetgid != 0 {
+		unixMode |= unixSetgid
+	}
+	if mode&writablefs.ModeSticky != 0 {
+		unixMode |= unixSticky
+	}
+
+	return unixMode
+}
+
+// fromUnixMode converts the permission bits of a unix mode (the file type is
+// determined by the object itself).
+func fromUnixMode(unixMode uint32) writablefs.FileMode {
+	mode := writablefs.FileMode(unixMode & 0o777)
+
+	if unixMode&unixSetuid != 0 {
+		mode |= writablefs.ModeSetuid
+	}
+	if unixMode&unixSetgid != 0 {
+		mode |= writablefs.ModeSetgid
+	}
+	if unixMode&unixSticky != 0 {
+		mode |= writablefs.ModeSticky
+	}
+
+	return mode
+}
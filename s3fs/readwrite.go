@@ -0,0 +1,244 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	_ fs.ReadFileFS          = (*s3FS)(nil)
+	_ writablefs.WriteFileFS = (*s3FS)(nil)
+)
+
+// ReadFile reads the object with a single request, without staging it. If the
+// file is already open with pending changes, it is read from the staging file.
+func (fsys *s3FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: writablefs.ErrInvalid}
+	}
+
+	path := name
+	for i := 0; i < maxSymlinkHops; i++ {
+		if fsys.isStaged(path) {
+			return fsys.readStagedFile(path)
+		}
+
+		data, err := fsys.readObject(fsys.ctx, path)
+		if err != nil {
+			var symlinkErr *symlinkError
+			if errors.As(err, &symlinkErr) {
+				// Follow the symbolic link.
+				path, err = resolveSymlink(path, symlinkErr.target)
+				if err != nil {
+					return nil, pathError("readfile", name, err)
+				}
+
+				continue
+			}
+
+			return nil, pathError("readfile", name, err)
+		}
+
+		return data, nil
+	}
+
+	return nil, pathError("readfile", name, errTooManySymlinks)
+}
+
+// WriteFile uploads the object with a single request, without staging it,
+// replacing whatever was at the key (including a symbolic link, and the
+// attributes of an existing file). With Options.StatOnWriteFile the status of
+// the object is retrieved first, so that like os.WriteFile symbolic links are
+// followed and the extended and POSIX attributes of an existing file are kept
+// (apart from its times). The upload is then conditional on the object not
+// having changed since, otherwise writablefs.ErrConflict is returned. If the
+// file is already open with pending changes, it is written to the staging
+// file instead.
+func (fsys *s3FS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "writefile", Path: name, Err: writablefs.ErrInvalid}
+	}
+
+	path := name
+	for i := 0; i < maxSymlinkHops; i++ {
+		if fsys.isStaged(path) {
+			return fsys.writeStagedFile(path, data)
+		}
+
+		err := fsys.writeObject(fsys.ctx, path, data)
+		if err != nil {
+			var symlinkErr *symlinkError
+			if errors.As(err, &symlinkErr) {
+				// Follow the symbolic link.
+				path, err = resolveSymlink(path, symlinkErr.target)
+				if err != nil {
+					return pathError("writefile", name, err)
+				}
+
+				continue
+			}
+
+			return pathError("writefile", name, err)
+		}
+
+		return nil
+	}
+
+	return pathError("writefile", name, errTooManySymlinks)
+}
+
+// readObject downloads the contents of an object.
+func (fsys *s3FS) readObject(ctx context.Context, path string) ([]byte, error) {
+	key := toKey(path, false)
+	if key == "" {
+		return nil, syscall.EISDIR
+	}
+
+	fsys.logger.Debug("Downloading object", "key", key)
+
+	obj, err := fsys.client.GetObject(ctx, fsys.bucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	// Reading first means the status comes from the same request (rather than
+	// a separate HEAD request).
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return nil, err
+		}
+
+		// Is it a directory?
+		if fi, err := fsys.lstat(ctx, path); err == nil && fi.IsDir() {
+			return nil, syscall.EISDIR
+		}
+
+		return nil, writablefs.ErrNotExist
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if target, ok := symlinkTarget(info); ok {
+		return nil, &symlinkError{target: target}
+	}
+
+	return data, nil
+}
+
+// writeObject replaces the contents of an object (or creates it), keeping
+// the metadata of an existing object if fsys.statOnWriteFile is set.
+func (fsys *s3FS) writeObject(ctx context.Context, path string, data []byte) error {
+	key := toKey(path, false)
+	if key == "" {
+		return syscall.EISDIR
+	}
+
+	if !fsys.statOnWriteFile {
+		fsys.logger.Debug("Uploading object", "key", key)
+
+		_, err := fsys.client.PutObject(ctx, fsys.bucketName, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+			ContentType: "application/octet-stream",
+		})
+		return err
+	}
+
+	// Only overwrite the version of the object we've looked at.
+	p := preconditions{ifNoneMatch: "*"}
+	var userMetadata map[string]string
+
+	fi, err := fsys.lstat(ctx, path)
+	if err != nil && !errors.Is(err, writablefs.ErrNotExist) {
+		return err
+	} else if err == nil {
+		if fi.IsDir() {
+			return syscall.EISDIR
+		}
+
+		if target, ok := symlinkTarget(fi.info); ok {
+			return &symlinkError{target: target}
+		}
+
+		p = preconditions{ifMatch: `"` + fi.info.ETag + `"`}
+		userMetadata = uploadMetadata(fi.info.UserMetadata)
+	}
+
+	fsys.logger.Debug("Uploading object", "key", key)
+
+	_, err = fsys.putObjectConditional(ctx, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: userMetadata,
+	}, p)
+	if err != nil {
+		if errors.Is(err, errPreconditionFailed) {
+			fsys.logger.Debug("Remote object was modified concurrently", "key", key)
+
+			return writablefs.ErrConflict
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// isStaged reports whether the file is open with a staging file (which may
+// contain changes that haven't been uploaded yet).
+func (fsys *s3FS) isStaged(path string) bool {
+	fsys.filesMu.Lock()
+	f, ok := fsys.files[path]
+	fsys.filesMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stagingFile != nil
+}
+
+func (fsys *s3FS) readStagedFile(path string) ([]byte, error) {
+	f, err := fsys.OpenFile(path, writablefs.FlagReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+func (fsys *s3FS) writeStagedFile(path string, data []byte) error {
+	f, err := fsys.OpenFile(path, writablefs.FlagCreate|writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,361 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"syscall"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/hashicorp/go-multierror"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Objects used internally by s3fs are stored under this prefix.
+	// They are hidden from directory listings.
+	internalPrefix = ".writablefs/"
+	// Directory renames in progress are journaled under this prefix.
+	renameJournalPrefix = internalPrefix + "renames/"
+)
+
+// renameJournal records a directory rename that is in progress, so that it
+// can be completed or rolled back if we crash part way through.
+type renameJournal struct {
+	OldKey string `json:"oldKey"`
+	NewKey string `json:"newKey"`
+}
+
+func (fsys *s3FS) Rename(oldPath string, newPath string) error {
+	return fsys.RenameContext(fsys.ctx, oldPath, newPath)
+}
+
+func (fsys *s3FS) RenameContext(ctx context.Context, oldPath string, newPath string) error {
+	ctx, cancel := fsys.mergeContext(ctx)
+	defer cancel()
+
+	fsys.logger.Debug("Renaming object", "oldPath", oldPath, "newPath", newPath)
+
+	isDir, err := fsys.isDir(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	if isDir {
+		// Like POSIX, a directory can't replace a file.
+		if newKey := toKey(newPath, false); newKey != "" && newKey != toKey(oldPath, false) {
+			_, err := fsys.client.StatObject(ctx, fsys.bucketName, newKey, minio.StatObjectOptions{})
+			if err == nil {
+				return &fs.PathError{Op: "rename", Path: newPath, Err: syscall.ENOTDIR}
+			} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+				return err
+			}
+		}
+
+		return fsys.renameDir(ctx, toKey(oldPath, true), toKey(newPath, true))
+	}
+
+	oldKey := toKey(oldPath, false)
+
+	objInfo, err := fsys.client.StatObject(ctx, fsys.bucketName, oldKey, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	newKey := toKey(newPath, false)
+	if newKey == oldKey {
+		return nil
+	}
+
+	// Nor can a file replace a directory.
+	newIsDir, err := fsys.isDir(ctx, newPath)
+	if err != nil && !errors.Is(err, writablefs.ErrNotExist) {
+		return err
+	}
+
+	if newIsDir {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: syscall.EISDIR}
+	}
+
+	if err := fsys.copyXAttrSidecar(ctx, oldKey, newKey, objInfo.UserMetadata); err != nil {
+		return err
+	}
+
+	if err := fsys.copyObject(ctx, oldKey, newKey, objInfo.Size); err != nil {
+		return err
+	}
+
+	if err := fsys.client.RemoveObject(ctx, fsys.bucketName, oldKey, minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+
+	fsys.removeXAttrSidecar(ctx, oldKey, objInfo.UserMetadata)
+
+	return nil
+}
+
+// CompleteRenames completes any directory renames that were interrupted (eg.
+// by a crash). Renames are journaled so this can be done at any point.
+func CompleteRenames(fsys writablefs.FS) error {
+	s3fsys, ok := fsys.(*s3FS)
+	if !ok {
+		return writablefs.ErrInvalid
+	}
+
+	return s3fsys.recoverRenames(s3fsys.ctx, false)
+}
+
+// RollbackRenames undoes any directory renames that were interrupted (eg.
+// by a crash), moving objects back to their original location.
+func RollbackRenames(fsys writablefs.FS) error {
+	s3fsys, ok := fsys.(*s3FS)
+	if !ok {
+		return writablefs.ErrInvalid
+	}
+
+	return s3fsys.recoverRenames(s3fsys.ctx, true)
+}
+
+func (fsys *s3FS) renameDir(ctx context.Context, oldKey, newKey string) error {
+	// Renaming a directory to itself does nothing.
+	if oldKey != "" && oldKey == newKey {
+		return nil
+	}
+
+	if oldKey == "" || newKey == "" || strings.HasPrefix(newKey, oldKey) {
+		// Can't move the root directory, or a directory into itself.
+		return writablefs.ErrInvalid
+	}
+
+	// Like POSIX, only allow replacing empty directories.
+	empty, err := fsys.isEmptyDir(ctx, newKey)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		return writablefs.ErrExist
+	}
+
+	journal := renameJournal{
+		OldKey: oldKey,
+		NewKey: newKey,
+	}
+
+	journalKey, err := fsys.writeRenameJournal(ctx, journal)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.moveObjects(ctx, oldKey, newKey); err != nil {
+		return err
+	}
+
+	return fsys.client.RemoveObject(ctx, fsys.bucketName, journalKey, minio.RemoveObjectOptions{})
+}
+
+func (fsys *s3FS) recoverRenames(ctx context.Context, rollback bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
+		Prefix:    renameJournalPrefix,
+		Recursive: true,
+	})
+
+	var journalKeys []string
+	for objInfo := range objCh {
+		if objInfo.Err != nil {
+			return objInfo.Err
+		}
+
+		journalKeys = append(journalKeys, objInfo.Key)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var result *multierror.Error
+	for _, journalKey := range journalKeys {
+		journal, err := fsys.readRenameJournal(ctx, journalKey)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		if rollback {
+			fsys.logger.Info("Rolling back interrupted directory rename", "oldKey", journal.OldKey, "newKey", journal.NewKey)
+
+			err = fsys.moveObjects(ctx, journal.NewKey, journal.OldKey)
+		} else {
+			fsys.logger.Info("Completing interrupted directory rename", "oldKey", journal.OldKey, "newKey", journal.NewKey)
+
+			err = fsys.moveObjects(ctx, journal.OldKey, journal.NewKey)
+		}
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		if err := fsys.client.RemoveObject(ctx, fsys.bucketName, journalKey, minio.RemoveObjectOptions{}); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// moveObjects moves every object under srcPrefix to dstPrefix. Objects are
+// only removed once everything has been copied, so moveObjects can be safely
+// retried (in either direction) if it fails part way through.
+func (fsys *s3FS) moveObjects(ctx context.Context, srcPrefix, dstPrefix string) error {
+	fsys.logger.Debug("Moving objects", "srcPrefix", srcPrefix, "dstPrefix", dstPrefix)
+
+	srcKeys, err := fsys.copyObjects(ctx, srcPrefix, dstPrefix)
+	if err != nil {
+		return err
+	}
+
+	fsys.logger.Debug("Removing moved objects", "srcPrefix", srcPrefix, "count", len(srcKeys))
+
+	objToDeleteCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objToDeleteCh)
+
+		for _, key := range srcKeys {
+			objToDeleteCh <- minio.ObjectInfo{Key: key}
+		}
+	}()
+
+	var result *multierror.Error
+	for err := range fsys.client.RemoveObjects(ctx, fsys.bucketName, objToDeleteCh, minio.RemoveObjectsOptions{}) {
+		if err.Err != nil {
+			result = multierror.Append(result, fmt.Errorf("failed to remove %q: %w", err.ObjectName, err.Err))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (fsys *s3FS) writeRenameJournal(ctx context.Context, journal renameJournal) (string, error) {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(journal.OldKey + "\n" + journal.NewKey))
+	journalKey := renameJournalPrefix + hex.EncodeToString(sum[:]) + ".json"
+
+	_, err = fsys.client.PutObject(ctx, fsys.bucketName, journalKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return journalKey, nil
+}
+
+func (fsys *s3FS) readRenameJournal(ctx context.Context, journalKey string) (*renameJournal, error) {
+	obj, err := fsys.client.GetObject(ctx, fsys.bucketName, journalKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal renameJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("invalid rename journal %q: %w", journalKey, err)
+	}
+
+	if journal.OldKey == "" || journal.NewKey == "" {
+		return nil, fmt.Errorf("invalid rename journal %q: %w", journalKey, writablefs.ErrInvalid)
+	}
+
+	return &journal, nil
+}
+
+// isDir checks if the path refers to a directory (rather than an object).
+func (fsys *s3FS) isDir(ctx context.Context, path string) (bool, error) {
+	key := toKey(path, false)
+	if key == "" {
+		return true, nil
+	}
+
+	_, err := fsys.client.StatObject(ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+	if err == nil {
+		return false, nil
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return false, err
+	}
+
+	empty, err := fsys.isEmptyDir(ctx, toKey(path, true))
+	if err != nil {
+		return false, err
+	}
+
+	if empty {
+		// Could still be an empty directory marker.
+		_, err := fsys.client.StatObject(ctx, fsys.bucketName, toKey(path, true), minio.StatObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return false, writablefs.ErrNotExist
+			}
+
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isEmptyDir checks if there are any objects under the directory key (not
+// counting the directory marker itself).
+func (fsys *s3FS) isEmptyDir(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
+		Prefix:    key,
+		Recursive: true,
+		MaxKeys:   2,
+	})
+
+	for objInfo := range objCh {
+		if objInfo.Err != nil {
+			return false, objInfo.Err
+		}
+
+		if objInfo.Key != key {
+			return false, nil
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
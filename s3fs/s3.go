@@ -13,14 +13,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	gopath "path"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bucket-sailor/writablefs"
 	"github.com/bucket-sailor/writablefs/dirfs"
@@ -40,6 +44,14 @@ type s3FS struct {
 	stagingFS  writablefs.FS
 	filesMu    sync.Mutex
 	files      map[string]*file
+	// Conditional writes (eg. If-None-Match) support.
+	disableConditionalWrites     bool
+	conditionalWritesUnsupported atomic.Bool
+	refreshOnSync                bool
+	statOnWriteFile              bool
+	listSymlinksAsFiles          bool
+	// The maximum number of keys to fetch per listing request.
+	listPageSize int
 }
 
 // Options for opening a new S3 filesystem.
@@ -49,6 +61,31 @@ type Options struct {
 	TLSClientConfig *tls.Config
 	Credentials     *credentials.Credentials
 	BucketName      string
+	// DisableConditionalWrites disables the use of conditional writes (eg. the
+	// If-None-Match header) for providers that don't support them. Exclusive
+	// creates will instead check for an existing object first, which is not atomic.
+	// Providers that reject conditional writes outright are detected automatically.
+	DisableConditionalWrites bool
+	// RefreshOnSync causes Sync to re-download an open file if the remote object
+	// has been modified and there are no local changes pending.
+	RefreshOnSync bool
+	// StatOnWriteFile causes WriteFile to get the status of an existing object
+	// before replacing it, so that symbolic links are followed and attributes
+	// are kept (like os.WriteFile). This costs up to three extra requests, and
+	// the upload fails with writablefs.ErrConflict if the object is modified
+	// concurrently.
+	StatOnWriteFile bool
+	// ListSymlinksAsFiles skips checking whether the zero-byte objects in
+	// directory listings (ReadDir, ReadDirStream and WalkDir) are symbolic
+	// links, which takes a HEAD request for each of them. Set it for buckets
+	// without symbolic links, any links are listed as empty files (but are
+	// still followed when opened by path).
+	ListSymlinksAsFiles bool
+	// ListPageSize is the maximum number of keys to fetch per request when
+	// streaming directory listings (ReadDirStream) or walking trees (WalkDir).
+	// Defaults to 1000, the most S3 returns, and is at least 2 (as a page can
+	// start with a repeat of the last key of the previous one).
+	ListPageSize int
 }
 
 // New opens a new S3 filesystem.
@@ -79,7 +116,7 @@ func New(ctx context.Context, logger *slog.Logger, opts Options) (writablefs.FS,
 
 	client, err := minio.New(endpointURL.Host, &minio.Options{
 		Region:    opts.Region,
-		Transport: transport,
+		Transport: &conditionalTransport{transport},
 		Secure:    endpointURL.Scheme == "https",
 		Creds:     opts.Credentials,
 	})
@@ -103,6 +140,13 @@ func New(ctx context.Context, logger *slog.Logger, opts Options) (writablefs.FS,
 
 	logger.Debug("Using staging directory", "path", stagingDir)
 
+	listPageSize := opts.ListPageSize
+	if listPageSize <= 0 || listPageSize > maxListPageSize {
+		listPageSize = maxListPageSize
+	} else if listPageSize < minListPageSize {
+		listPageSize = minListPageSize
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	return &s3FS{
@@ -114,6 +158,12 @@ func New(ctx context.Context, logger *slog.Logger, opts Options) (writablefs.FS,
 		stagingDir: stagingDir,
 		stagingFS:  stagingFS,
 		files:      make(map[string]*file),
+
+		disableConditionalWrites: opts.DisableConditionalWrites,
+		refreshOnSync:            opts.RefreshOnSync,
+		statOnWriteFile:          opts.StatOnWriteFile,
+		listSymlinksAsFiles:      opts.ListSymlinksAsFiles,
+		listPageSize:             listPageSize,
 	}, nil
 }
 
@@ -147,17 +197,71 @@ func (fsys *s3FS) Close() error {
 }
 
 func (fsys *s3FS) Open(path string) (writablefs.FileReadOnly, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: writablefs.ErrInvalid}
+	}
+
 	return fsys.OpenFile(path, writablefs.FlagReadOnly)
 }
 
 func (fsys *s3FS) OpenFile(path string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
+	return fsys.OpenFileContext(fsys.ctx, path, flag)
+}
+
+func (fsys *s3FS) OpenFileContext(ctx context.Context, name string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
+	ctx, cancel := fsys.mergeContext(ctx)
+	defer cancel()
+
+	// Directories can only be opened read-only (for listing).
+	openDir := flag.IsReadOnly() && !flag.IsSet(writablefs.FlagCreate)
+
+	path := name
+	for i := 0; i < maxSymlinkHops; i++ {
+		if openDir && toKey(path, false) == "" {
+			fi, err := fsys.lstat(ctx, path)
+			if err != nil {
+				return nil, pathError("open", name, err)
+			}
+
+			return &dirHandle{fsys: fsys, path: path, info: fi}, nil
+		}
+
+		h, err := fsys.openFile(ctx, path, flag)
+		if err != nil {
+			var symlinkErr *symlinkError
+			if errors.As(err, &symlinkErr) {
+				// Follow the symbolic link.
+				path, err = resolveSymlink(path, symlinkErr.target)
+				if err != nil {
+					return nil, pathError("open", name, err)
+				}
+
+				continue
+			}
+
+			if openDir && errors.Is(err, writablefs.ErrNotExist) {
+				if fi, statErr := fsys.lstat(ctx, path); statErr == nil && fi.IsDir() {
+					return &dirHandle{fsys: fsys, path: path, info: fi}, nil
+				}
+			}
+
+			return nil, pathError("open", name, err)
+		}
+
+		return h, nil
+	}
+
+	return nil, pathError("open", name, errTooManySymlinks)
+}
+
+func (fsys *s3FS) openFile(ctx context.Context, path string, flag writablefs.FileOpenFlag) (*fileHandle, error) {
 	fsys.filesMu.Lock()
 	f, ok := fsys.files[path]
 	if !ok {
-		ctx, cancel := context.WithCancel(fsys.ctx)
+		fileCtx, cancel := context.WithCancel(fsys.ctx)
 
 		f = &file{
-			ctx:     ctx,
+			ctx:     fileCtx,
 			cancel:  cancel,
 			fsys:    fsys,
 			key:     toKey(path, false),
@@ -168,10 +272,17 @@ func (fsys *s3FS) OpenFile(path string, flag writablefs.FileOpenFlag) (writablef
 	}
 	fsys.filesMu.Unlock()
 
-	return f.newHandle(flag)
+	return f.newHandle(ctx, flag)
 }
 
 func (fsys *s3FS) MkdirAll(path string) error {
+	return fsys.MkdirAllContext(fsys.ctx, path)
+}
+
+func (fsys *s3FS) MkdirAllContext(ctx context.Context, path string) error {
+	ctx, cancel := fsys.mergeContext(ctx)
+	defer cancel()
+
 	key := toKey(path, true)
 
 	fsys.logger.Debug("Creating directory structure", "key", key)
@@ -187,7 +298,7 @@ func (fsys *s3FS) MkdirAll(path string) error {
 		fsys.logger.Debug("Creating directory", "key", partialKey)
 
 		// Represent directories as a zero-length object with a slash suffix.
-		_, err := fsys.client.PutObject(fsys.ctx, fsys.bucketName, partialKey, bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+		_, err := fsys.client.PutObject(ctx, fsys.bucketName, partialKey, bytes.NewReader(nil), 0, minio.PutObjectOptions{})
 		if err != nil {
 			fsys.logger.Error("Failed to create directory", "key", partialKey, "error", err)
 			return err
@@ -197,68 +308,90 @@ func (fsys *s3FS) MkdirAll(path string) error {
 	return nil
 }
 
-func (fsys *s3FS) ReadDir(path string) ([]writablefs.DirEntry, error) {
+func (fsys *s3FS) Mkdir(path string) error {
 	key := toKey(path, true)
 
-	fsys.logger.Debug("Listing objects in directory", "key", key)
-
-	ctx, cancel := context.WithCancel(fsys.ctx)
-	defer cancel()
+	fsys.logger.Debug("Creating directory", "key", key)
 
-	objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
-		Prefix:    key,
-		Recursive: false,
-	})
+	if key == "" {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: writablefs.ErrExist}
+	}
 
-	var entries []writablefs.DirEntry
-	for objInfo := range objCh {
-		if objInfo.Err != nil {
-			return nil, objInfo.Err
+	if parentKey := parentKey(key); parentKey != "" {
+		isDir, err := fsys.isDir(fsys.ctx, parentKey)
+		if err != nil {
+			return err
 		}
 
-		// Skip the directory itself (not all S3 implementations will return it).
-		if objInfo.Key == key {
-			continue
+		if !isDir {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: writablefs.ErrInvalid}
 		}
+	}
 
-		entries = append(entries, &dirEntry{
-			name:    strings.TrimPrefix(objInfo.Key, key),
-			size:    objInfo.Size,
-			modTime: objInfo.LastModified,
-			isDir:   strings.HasSuffix(objInfo.Key, "/"),
-		})
+	// Is there already a file or directory with this name?
+	if _, err := fsys.isDir(fsys.ctx, path); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: writablefs.ErrExist}
+	} else if !errors.Is(err, writablefs.ErrNotExist) {
+		return err
 	}
 
-	// Check if the directory exists.
-	// We only do this as a last resort as it can be an expensive operation.
-	if len(entries) == 0 && key != "" {
-		fsys.logger.Debug("Checking if directory actually exists", "key", key, "parentKey", parentKey)
+	// Creating the marker is atomic (where supported), so that concurrent
+	// callers (eg. using directories as locks) can't both succeed.
+	_, err := fsys.putObjectConditional(fsys.ctx, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{}, preconditions{ifNoneMatch: "*"})
+	if errors.Is(err, errPreconditionFailed) {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: writablefs.ErrExist}
+	}
 
-		if _, err := fsys.Stat(key); err != nil {
-			return nil, writablefs.ErrNotExist
-		}
+	return err
+}
+
+func (fsys *s3FS) ReadDir(path string) ([]writablefs.DirEntry, error) {
+	return fsys.ReadDirContext(fsys.ctx, path)
+}
+
+func (fsys *s3FS) ReadDirContext(ctx context.Context, name string) ([]writablefs.DirEntry, error) {
+	stream, err := fsys.ReadDirStream(ctx, name, "")
+	if err != nil {
+		return nil, err
 	}
+	defer stream.Close()
 
-	if len(entries) > 0 {
-		fsys.logger.Debug("Found objects in directory", "key", key, "count", len(entries))
+	entries, err := stream.Next(-1)
+	if err != nil {
+		return nil, err
 	}
 
+	// Keys are sorted with the trailing slash of directories (eg. "dir/" sorts
+	// after "dir.txt"), but ReadDir must be sorted by name.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
 	return entries, nil
 }
 
 func (fsys *s3FS) RemoveAll(path string) error {
-	// Is it an object instead of a directory?
-	fi, err := fsys.Stat(path)
+	return fsys.RemoveAllContext(fsys.ctx, path)
+}
+
+func (fsys *s3FS) RemoveAllContext(ctx context.Context, path string) error {
+	ctx, cancel := fsys.mergeContext(ctx)
+	defer cancel()
+
+	// Is it an object (or symbolic link) instead of a directory?
+	fi, err := fsys.lstat(ctx, path)
 	if err == nil && !fi.IsDir() {
 		key := toKey(path, false)
 
 		fsys.logger.Debug("Removing object", "key", key)
 
-		err := fsys.client.RemoveObject(fsys.ctx, fsys.bucketName, key, minio.RemoveObjectOptions{})
+		err := fsys.client.RemoveObject(ctx, fsys.bucketName, key, minio.RemoveObjectOptions{})
 		if err != nil {
 			return err
 		}
 
+		fsys.removeXAttrSidecar(ctx, key, fi.info.UserMetadata)
+
 		return nil
 	}
 
@@ -266,10 +399,12 @@ func (fsys *s3FS) RemoveAll(path string) error {
 
 	fsys.logger.Debug("Removing directory", "key", key)
 
-	objCh := fsys.client.ListObjects(fsys.ctx, fsys.bucketName, minio.ListObjectsOptions{
-		Prefix:    key,
-		Recursive: true,
-	})
+	prefixes := []string{key}
+	if key != "" {
+		// Along with any extended attribute sidecars (which are under the
+		// root directory, so already included if we are removing it).
+		prefixes = append(prefixes, xattrSidecarKeyPrefix(key))
+	}
 
 	var resultMu sync.Mutex
 	var result *multierror.Error
@@ -279,20 +414,27 @@ func (fsys *s3FS) RemoveAll(path string) error {
 	go func() {
 		defer close(objToDeleteCh)
 
-		for objInfo := range objCh {
-			if objInfo.Err != nil {
-				resultMu.Lock()
-				result = multierror.Append(result, objInfo.Err)
-				resultMu.Unlock()
+		for _, prefix := range prefixes {
+			objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
+				Prefix:    prefix,
+				Recursive: true,
+			})
 
-				continue
-			}
+			for objInfo := range objCh {
+				if objInfo.Err != nil {
+					resultMu.Lock()
+					result = multierror.Append(result, objInfo.Err)
+					resultMu.Unlock()
 
-			objToDeleteCh <- objInfo
+					continue
+				}
+
+				objToDeleteCh <- objInfo
+			}
 		}
 	}()
 
-	removeErrorCh := fsys.client.RemoveObjects(fsys.ctx, fsys.bucketName, objToDeleteCh, minio.RemoveObjectsOptions{})
+	removeErrorCh := fsys.client.RemoveObjects(ctx, fsys.bucketName, objToDeleteCh, minio.RemoveObjectsOptions{})
 
 	for err := range removeErrorCh {
 		if err.Err != nil {
@@ -302,37 +444,90 @@ func (fsys *s3FS) RemoveAll(path string) error {
 		}
 	}
 
-	if err := fsys.client.RemoveObject(fsys.ctx, fsys.bucketName, key, minio.RemoveObjectOptions{}); err != nil {
-		resultMu.Lock()
-		result = multierror.Append(result, err)
-		resultMu.Unlock()
+	// The listing ends early (without an error) if the context is cancelled.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The root directory has no marker.
+	if key != "" {
+		if err := fsys.client.RemoveObject(ctx, fsys.bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+			resultMu.Lock()
+			result = multierror.Append(result, err)
+			resultMu.Unlock()
+		}
 	}
 
 	return result.ErrorOrNil()
 }
 
-func (fsys *s3FS) Rename(oldPath string, newPath string) error {
-	fsys.logger.Debug("Renaming object", "oldPath", oldPath, "newPath", newPath)
+func (fsys *s3FS) Remove(path string) error {
+	fi, err := fsys.lstat(fsys.ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		key := toKey(path, false)
+
+		fsys.logger.Debug("Removing object", "key", key)
+
+		if err := fsys.client.RemoveObject(fsys.ctx, fsys.bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
 
-	// TODO: Implement directory renames.
+		fsys.removeXAttrSidecar(fsys.ctx, key, fi.info.UserMetadata)
 
-	src := minio.CopySrcOptions{
-		Bucket: fsys.bucketName,
-		Object: toKey(oldPath, false),
+		return nil
 	}
-	dst := minio.CopyDestOptions{
-		Bucket: fsys.bucketName,
-		Object: toKey(newPath, false),
+
+	key := toKey(path, true)
+	if key == "" {
+		return fmt.Errorf("cannot remove root directory: %w", writablefs.ErrInvalid)
 	}
 
-	if _, err := fsys.client.CopyObject(fsys.ctx, dst, src); err != nil {
+	fsys.logger.Debug("Removing empty directory", "key", key)
+
+	empty, err := fsys.isEmptyDir(fsys.ctx, key)
+	if err != nil {
 		return err
 	}
 
-	return fsys.client.RemoveObject(fsys.ctx, fsys.bucketName, src.Object, minio.RemoveObjectOptions{})
+	if !empty {
+		return &fs.PathError{Op: "remove", Path: path, Err: writablefs.ErrNotEmpty}
+	}
+
+	if err := fsys.client.RemoveObject(fsys.ctx, fsys.bucketName, key, minio.RemoveObjectOptions{}); err != nil {
+		return err
+	}
+
+	fsys.removeXAttrSidecar(fsys.ctx, key, fi.info.UserMetadata)
+
+	return nil
 }
 
 func (fsys *s3FS) Stat(path string) (writablefs.FileInfo, error) {
+	return fsys.StatContext(fsys.ctx, path)
+}
+
+func (fsys *s3FS) StatContext(ctx context.Context, path string) (writablefs.FileInfo, error) {
+	ctx, cancel := fsys.mergeContext(ctx)
+	defer cancel()
+
+	resolvedPath, fi, err := fsys.followSymlinks(ctx, path)
+	if err != nil {
+		return nil, pathError("stat", path, err)
+	}
+
+	// Like os.Stat, use the name of the link rather than the target.
+	if resolvedPath != path {
+		fi.name = gopath.Base(path)
+	}
+
+	return fi, nil
+}
+
+func (fsys *s3FS) lstat(ctx context.Context, path string) (*fileInfo, error) {
 	key := toKey(path, false)
 
 	fsys.logger.Debug("Getting status of object", "key", key)
@@ -348,22 +543,32 @@ func (fsys *s3FS) Stat(path string) (writablefs.FileInfo, error) {
 		}, nil
 	}
 
-	info, err := fsys.client.StatObject(fsys.ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+	info, err := fsys.client.StatObject(ctx, fsys.bucketName, key, minio.StatObjectOptions{})
 	if err != nil {
 		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			parentKey := parentKey(key)
+			// Is there a directory marker? (which may carry POSIX attributes).
+			info, err := fsys.client.StatObject(ctx, fsys.bucketName, toKey(path, true), minio.StatObjectOptions{})
+			if err == nil {
+				return &fileInfo{
+					info: info,
+				}, nil
+			} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+				return nil, err
+			}
 
-			fsys.logger.Debug("Attempting to get status of directory by listing parent",
-				"key", key, "parentKey", parentKey)
+			dirKey := toKey(path, true)
 
-			ctx, cancel := context.WithCancel(fsys.ctx)
+			fsys.logger.Debug("Attempting to get status of directory by listing its contents", "key", dirKey)
+
+			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
 
+			// Listing the parent directory isn't enough, as keys like "dir-1.txt"
+			// sort before "dir/". So look for anything inside the directory.
 			objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
-				Prefix:     parentKey,
-				Recursive:  false,
-				StartAfter: key,
-				MaxKeys:    1,
+				Prefix:    dirKey,
+				Recursive: false,
+				MaxKeys:   1,
 			})
 
 			for objInfo := range objCh {
@@ -371,17 +576,18 @@ func (fsys *s3FS) Stat(path string) (writablefs.FileInfo, error) {
 					return nil, objInfo.Err
 				}
 
-				if strings.TrimSuffix(objInfo.Key, "/") == strings.TrimSuffix(key, "/") {
-					fsys.logger.Debug("Found directory in parent", "key", key)
+				fsys.logger.Debug("Found objects in directory", "key", dirKey)
 
-					return &fileInfo{
-						info: minio.ObjectInfo{
-							Key:          objInfo.Key,
-							LastModified: objInfo.LastModified,
-							Size:         objInfo.Size,
-						},
-					}, nil
-				}
+				return &fileInfo{
+					info: minio.ObjectInfo{
+						Key: dirKey,
+					},
+				}, nil
+			}
+
+			// The listing ends early (without an error) if the context is cancelled.
+			if err := ctx.Err(); err != nil {
+				return nil, err
 			}
 
 			return nil, writablefs.ErrNotExist
@@ -395,6 +601,28 @@ func (fsys *s3FS) Stat(path string) (writablefs.FileInfo, error) {
 	}, nil
 }
 
+// mergeContext returns a context that is cancelled when either ctx or the
+// file system is.
+func (fsys *s3FS) mergeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(fsys.ctx, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// pathError wraps err in a *fs.PathError, unless it already is one.
+func pathError(op, path string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return err
+	}
+
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
+
 func parentKey(key string) string {
 	return toKey(gopath.Dir(strings.TrimSuffix(key, "/")), true)
 }
@@ -0,0 +1,257 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Extended attributes that don't fit in the metadata of an object are
+	// stored in a sidecar object under this prefix (followed by the key of
+	// the object, so directories can be moved or removed by prefix).
+	xattrSidecarPrefix = internalPrefix + "xattrs/"
+	// The user metadata key that holds the ID of the sidecar object.
+	xattrSidecarMetadataKey = reservedMetadataPrefix + "xattrs-sidecar"
+	// Values that can't be stored in a metadata header as is are base64
+	// encoded, with this prefix.
+	encodedValuePrefix = "base64:"
+	// S3 limits user metadata to 2 KB (the sum of the key and value lengths).
+	maxUserMetadataSize = 2 * 1024
+)
+
+// decodeMetadata returns the user metadata of the object at key, with the
+// extended attribute values decoded (and any stored in a sidecar object
+// included). Keys are lowercased, and reserved keys are returned as is
+// (except for the sidecar ID).
+func (fsys *s3FS) decodeMetadata(ctx context.Context, key string, userMetadata map[string]string) (map[string]string, error) {
+	metadata := make(map[string]string, len(userMetadata))
+
+	var sidecarID string
+	for name, value := range userMetadata {
+		name = strings.ToLower(name)
+
+		switch {
+		case name == xattrSidecarMetadataKey:
+			sidecarID = value
+		case isReservedMetadataKey(name):
+			metadata[name] = value
+		default:
+			metadata[name] = decodeXAttrValue(value)
+		}
+	}
+
+	if sidecarID == "" {
+		return metadata, nil
+	}
+
+	sidecarKey := xattrSidecarKey(key, sidecarID)
+
+	fsys.logger.Debug("Reading extended attributes sidecar", "key", key, "sidecarKey", sidecarKey)
+
+	obj, err := fsys.client.GetObject(ctx, fsys.bucketName, sidecarKey, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// The attributes are gone, but don't prevent the object from being used.
+			fsys.logger.Warn("Extended attributes sidecar is missing", "key", key, "sidecarKey", sidecarKey)
+
+			return metadata, nil
+		}
+
+		return nil, err
+	}
+
+	var xattrs map[string][]byte
+	if err := json.Unmarshal(data, &xattrs); err != nil {
+		return nil, fmt.Errorf("invalid extended attributes sidecar %q: %w", sidecarKey, err)
+	}
+
+	for name, value := range xattrs {
+		metadata[strings.ToLower(name)] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// encodeMetadata returns the user metadata to store for the object at key.
+// Extended attribute values are encoded if need be, and if they don't fit
+// in the metadata of the object they are uploaded to a new sidecar object.
+func (fsys *s3FS) encodeMetadata(ctx context.Context, key string, metadata map[string]string) (map[string]string, error) {
+	userMetadata := make(map[string]string, len(metadata))
+	xattrs := make(map[string][]byte)
+
+	var size int
+	for name, value := range metadata {
+		if name == xattrSidecarMetadataKey {
+			continue
+		}
+
+		if !isReservedMetadataKey(name) {
+			xattrs[name] = []byte(value)
+			value = encodeXAttrValue(value)
+		}
+
+		userMetadata[name] = value
+		size += len(name) + len(value)
+	}
+
+	if size <= maxUserMetadataSize || len(xattrs) == 0 {
+		return userMetadata, nil
+	}
+
+	sidecarID, err := newSidecarID()
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarKey := xattrSidecarKey(key, sidecarID)
+
+	fsys.logger.Debug("Writing extended attributes sidecar", "key", key, "sidecarKey", sidecarKey)
+
+	data, err := json.Marshal(xattrs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fsys.client.PutObject(ctx, fsys.bucketName, sidecarKey, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range xattrs {
+		delete(userMetadata, name)
+	}
+
+	userMetadata[xattrSidecarMetadataKey] = sidecarID
+
+	return userMetadata, nil
+}
+
+// copyXAttrSidecar copies the sidecar object (if any) of the object at srcKey,
+// so that it is found by the copy of the object at dstKey.
+func (fsys *s3FS) copyXAttrSidecar(ctx context.Context, srcKey, dstKey string, userMetadata map[string]string) error {
+	sidecarID := xattrSidecarID(userMetadata)
+	if sidecarID == "" {
+		return nil
+	}
+
+	src := minio.CopySrcOptions{
+		Bucket: fsys.bucketName,
+		Object: xattrSidecarKey(srcKey, sidecarID),
+	}
+	dst := minio.CopyDestOptions{
+		Bucket: fsys.bucketName,
+		Object: xattrSidecarKey(dstKey, sidecarID),
+	}
+
+	_, err := fsys.client.CopyObject(ctx, dst, src)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+
+	return nil
+}
+
+// removeXAttrSidecar removes the sidecar object (if any) of the object at key.
+// Failures are only logged, as an orphaned sidecar is harmless.
+func (fsys *s3FS) removeXAttrSidecar(ctx context.Context, key string, userMetadata map[string]string) {
+	sidecarID := xattrSidecarID(userMetadata)
+	if sidecarID == "" {
+		return
+	}
+
+	sidecarKey := xattrSidecarKey(key, sidecarID)
+
+	fsys.logger.Debug("Removing extended attributes sidecar", "key", key, "sidecarKey", sidecarKey)
+
+	if err := fsys.client.RemoveObject(ctx, fsys.bucketName, sidecarKey, minio.RemoveObjectOptions{}); err != nil {
+		fsys.logger.Warn("Failed to remove extended attributes sidecar", "sidecarKey", sidecarKey, "error", err)
+	}
+}
+
+// xattrSidecarKey returns the key of a sidecar object. The ID is a suffix
+// (rather than a path component), so that the sidecars of a directory and
+// its contents share a prefix.
+func xattrSidecarKey(key, sidecarID string) string {
+	return xattrSidecarPrefix + key + "@" + sidecarID
+}
+
+// xattrSidecarKeyPrefix returns the prefix of the sidecar objects for every
+// object under the directory key.
+func xattrSidecarKeyPrefix(dirKey string) string {
+	return xattrSidecarPrefix + dirKey
+}
+
+func xattrSidecarID(userMetadata map[string]string) string {
+	value, _ := metadataValue(minio.ObjectInfo{UserMetadata: userMetadata}, xattrSidecarMetadataKey)
+	return value
+}
+
+// Every update gets a new sidecar, so that the sidecar an object refers to
+// is never modified (eg. by an update that then fails its precondition).
+func newSidecarID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+// encodeXAttrValue encodes values that can't be stored in a metadata header
+// as is (non-printable or non-ASCII characters, or whitespace that would be
+// trimmed). Values that look like they are encoded are also encoded.
+func encodeXAttrValue(value string) string {
+	encode := value == "" || strings.HasPrefix(value, encodedValuePrefix) ||
+		strings.TrimSpace(value) != value
+
+	for i := 0; i < len(value) && !encode; i++ {
+		encode = value[i] < 0x20 || value[i] > 0x7e
+	}
+
+	if !encode {
+		return value
+	}
+
+	return encodedValuePrefix + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func decodeXAttrValue(value string) string {
+	encoded, ok := strings.CutPrefix(value, encodedValuePrefix)
+	if !ok {
+		return value
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		// Not something we encoded.
+		return value
+	}
+
+	return string(data)
+}
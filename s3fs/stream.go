@@ -0,0 +1,419 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/bucket-sailor/queue"
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+var _ writablefs.ReadDirStreamFS = (*s3FS)(nil)
+
+const (
+	// The maximum number of keys S3 returns per listing request.
+	maxListPageSize = 1000
+	// Some S3 implementations repeat the common prefix a page was listed after,
+	// so smaller pages might not make any progress.
+	minListPageSize = 2
+	// The maximum number of objects to get the status of at once.
+	maxConcurrentStats = 20
+)
+
+// ReadDirStream lists the directory page by page (as the listing is consumed),
+// rather than loading every key under the prefix into memory. Entries are
+// returned in key order, so directories sort after files with the same prefix
+// (eg. "dir.txt" before "dir/"). The token is the name of the last returned
+// entry, and is only valid for the same directory.
+//
+// Listings don't include user metadata, so every zero-byte object needs a
+// HEAD request to tell whether it is a symbolic link. These are made
+// concurrently for each page, but listing a directory with many empty files
+// costs a request per file (unless Options.ListSymlinksAsFiles is set).
+func (fsys *s3FS) ReadDirStream(ctx context.Context, name, token string) (writablefs.DirStream, error) {
+	s, err := fsys.openDirStream(ctx, name, token, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// openDirStream opens a listing of the directory, limited to the entries with
+// names starting with namePrefix.
+func (fsys *s3FS) openDirStream(ctx context.Context, name, token, namePrefix string) (*dirStream, error) {
+	ctx, cancel := fsys.mergeContext(ctx)
+
+	s := &dirStream{
+		fsys:       fsys,
+		ctx:        ctx,
+		cancel:     cancel,
+		name:       name,
+		path:       name,
+		key:        toKey(name, true),
+		namePrefix: namePrefix,
+	}
+
+	if token != "" {
+		// Resolve the directory up front, as the token is relative to it.
+		path, fi, err := fsys.followSymlinks(ctx, name)
+		if err != nil {
+			cancel()
+			return nil, pathError("readdir", name, err)
+		}
+
+		if !fi.IsDir() {
+			cancel()
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+		}
+
+		s.path = path
+		s.key = toKey(path, true)
+		s.startAfter = s.key + token
+		s.returnedKey = s.startAfter
+		s.seen = true
+	}
+
+	// Start the listing, so that missing directories are reported immediately.
+	entry, err := s.next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		cancel()
+		return nil, pathError("readdir", name, err)
+	}
+
+	s.pending = entry
+
+	return s, nil
+}
+
+// dirStream is a lazily paginated listing of a directory.
+type dirStream struct {
+	mu     sync.Mutex
+	fsys   *s3FS
+	ctx    context.Context
+	cancel context.CancelFunc
+	// The name the directory was opened with.
+	name string
+	// The path and key of the directory (after following any symbolic links).
+	path string
+	key  string
+	// Only list entries with names starting with this prefix.
+	namePrefix string
+	// The key the listing was resumed from (if any).
+	startAfter string
+	// The key of the last entry returned to the caller.
+	returnedKey string
+	// The objects from the current page that haven't been read yet.
+	page []minio.ObjectInfo
+	// The status of the zero-byte objects in the current page.
+	pageInfo map[string]minio.ObjectInfo
+	// The key the current page was listed after, and the last key of the
+	// current page (the next page is listed after it).
+	pageAfter string
+	listedKey string
+	// Has the first page been fetched, and are there more pages?
+	started   bool
+	truncated bool
+	// An entry that has been read from the listing but not yet returned.
+	pending writablefs.DirEntry
+	// Has the directory been shown to exist?
+	seen bool
+	hops int
+	// The error that ended the listing (io.EOF if exhausted).
+	err    error
+	closed bool
+}
+
+func (s *dirStream) Next(n int) ([]writablefs.DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: s.name, Err: writablefs.ErrClosed}
+	}
+
+	var entries []writablefs.DirEntry
+	for n <= 0 || len(entries) < n {
+		entry := s.pending
+		s.pending = nil
+
+		if entry == nil {
+			var err error
+			entry, err = s.next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return entries, pathError("readdir", s.name, err)
+			}
+		}
+
+		entries = append(entries, entry)
+		s.returnedKey = entry.(*dirEntry).key
+	}
+
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+
+	return entries, nil
+}
+
+func (s *dirStream) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return strings.TrimPrefix(s.returnedKey, s.key)
+}
+
+func (s *dirStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.pending = nil
+		// Stops the underlying listing.
+		s.cancel()
+	}
+
+	return nil
+}
+
+// next returns the next entry from the listing, fetching the next page of
+// keys as required, s.mu must be held.
+func (s *dirStream) next() (writablefs.DirEntry, error) {
+	for {
+		if s.err != nil {
+			return nil, s.err
+		}
+
+		if len(s.page) == 0 {
+			if s.started && !s.truncated {
+				s.err = s.finish()
+			} else {
+				s.err = s.fetchPage()
+			}
+
+			continue
+		}
+
+		objInfo := s.page[0]
+		s.page = s.page[1:]
+
+		s.seen = true
+
+		// Skip the directory itself (not all S3 implementations will return it).
+		if objInfo.Key == s.key {
+			continue
+		}
+
+		// Skip objects used internally by s3fs.
+		if strings.HasPrefix(objInfo.Key, internalPrefix) {
+			continue
+		}
+
+		// Some S3 implementations repeat the common prefix that a page was
+		// listed after (eg. when the previous page ended with "dir/").
+		if objInfo.Key <= s.pageAfter {
+			continue
+		}
+
+		entry := &dirEntry{
+			fsys:  s.fsys,
+			key:   objInfo.Key,
+			name:  strings.TrimPrefix(objInfo.Key, s.key),
+			isDir: strings.HasSuffix(objInfo.Key, "/"),
+		}
+
+		// Zero-byte objects could be symbolic links.
+		if info, ok := s.pageInfo[objInfo.Key]; ok {
+			entry.userMetadata = info.UserMetadata
+			entry.info = &fileInfo{info: info}
+		}
+
+		return entry, nil
+	}
+}
+
+// fetchPage fetches the next page of the listing, s.mu must be held.
+func (s *dirStream) fetchPage() error {
+	startAfter := s.startAfter
+	if s.started {
+		startAfter = s.listedKey
+	}
+
+	s.fsys.logger.Debug("Listing objects in directory", "key", s.key, "namePrefix", s.namePrefix, "startAfter", startAfter)
+
+	// Each page is listed separately (resuming after the last key of the
+	// previous one), so that the next page isn't fetched until it's needed.
+	ctx, cancel := context.WithCancel(s.ctx)
+	objCh := s.fsys.client.ListObjects(ctx, s.fsys.bucketName, minio.ListObjectsOptions{
+		Prefix:     s.key + s.namePrefix,
+		StartAfter: startAfter,
+		MaxKeys:    s.fsys.listPageSize,
+	})
+
+	var page []minio.ObjectInfo
+	for objInfo := range objCh {
+		if objInfo.Err != nil {
+			stopListing(cancel, objCh)
+			return objInfo.Err
+		}
+
+		page = append(page, objInfo)
+		if len(page) == s.fsys.listPageSize {
+			break
+		}
+	}
+
+	stopListing(cancel, objCh)
+
+	// The listing ends early (without an error) if the context is cancelled.
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	// Only the last page of a listing has fewer keys than were asked for.
+	s.started = true
+	s.truncated = len(page) == s.fsys.listPageSize
+
+	// Objects and common prefixes are returned separately, but the listing
+	// must be in key order (which resuming relies on).
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].Key < page[j].Key
+	})
+
+	if len(page) > 0 {
+		s.listedKey = page[len(page)-1].Key
+	}
+
+	s.page = page
+	s.pageAfter = startAfter
+
+	var err error
+	s.pageInfo, err = s.fsys.statEmptyObjects(s.ctx, s.page)
+	return err
+}
+
+// stopListing cancels a listing, and waits for it to finish.
+func stopListing(cancel context.CancelFunc, objCh <-chan minio.ObjectInfo) {
+	cancel()
+
+	for range objCh {
+	}
+}
+
+// statEmptyObjects gets the status of the zero-byte objects (concurrently),
+// as listings don't include user metadata and any of them could be symbolic
+// links. Objects that have since been removed are left out, and nothing is
+// checked if fsys.listSymlinksAsFiles is set.
+func (fsys *s3FS) statEmptyObjects(ctx context.Context, objects []minio.ObjectInfo) (map[string]minio.ObjectInfo, error) {
+	if fsys.listSymlinksAsFiles {
+		return nil, nil
+	}
+
+	var mu sync.Mutex
+	infos := make(map[string]minio.ObjectInfo)
+
+	q := queue.NewQueue(maxConcurrentStats)
+
+	for _, objInfo := range objects {
+		if objInfo.Size != 0 || strings.HasSuffix(objInfo.Key, "/") || strings.HasPrefix(objInfo.Key, internalPrefix) {
+			continue
+		}
+
+		key := objInfo.Key
+
+		q.Add(func() error {
+			info, err := fsys.client.StatObject(ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+			if err != nil {
+				if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+					return nil
+				}
+
+				return err
+			}
+
+			mu.Lock()
+			infos[key] = info
+			mu.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := q.Wait(); err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// finish is called once the listing is exhausted, it returns io.EOF if the
+// directory exists, s.mu must be held.
+func (s *dirStream) finish() error {
+	// Don't mistake a cancelled listing for a missing directory.
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	if s.seen || s.key == "" {
+		return io.EOF
+	}
+
+	// Check if the directory exists.
+	// We only do this as a last resort as it can be an expensive operation.
+	s.fsys.logger.Debug("Checking if directory actually exists", "key", s.key)
+
+	fi, err := s.fsys.lstat(s.ctx, s.key)
+	if err != nil {
+		return writablefs.ErrNotExist
+	}
+
+	if target, ok := symlinkTarget(fi.info); ok {
+		if s.hops++; s.hops >= maxSymlinkHops {
+			return errTooManySymlinks
+		}
+
+		// Follow the symbolic link.
+		path, err := resolveSymlink(s.path, target)
+		if err != nil {
+			return err
+		}
+
+		s.path = path
+		s.key = toKey(path, true)
+
+		// Restart the listing at the target.
+		s.started = false
+		s.listedKey = ""
+
+		return nil
+	}
+
+	if !fi.IsDir() {
+		return syscall.ENOTDIR
+	}
+
+	s.seen = true
+
+	return io.EOF
+}
@@ -0,0 +1,186 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	gopath "path"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// User metadata keys with this prefix (and the POSIX attributes) are used
+	// internally by s3fs, they are hidden from (and can't be set as) extended
+	// attributes.
+	reservedMetadataPrefix = "writablefs-"
+	// Symbolic links are stored as zero-byte objects, with the (url escaped)
+	// link target stored in this user metadata key.
+	symlinkTargetMetadataKey = reservedMetadataPrefix + "symlink-target"
+	// The maximum number of symbolic links to follow when resolving a path.
+	maxSymlinkHops = 40
+)
+
+var errTooManySymlinks = fmt.Errorf("too many levels of symbolic links: %w", writablefs.ErrInvalid)
+
+// symlinkError is returned when opening an object that turns out to be a
+// symbolic link, so that the caller can follow it.
+type symlinkError struct {
+	target string
+}
+
+func (e *symlinkError) Error() string {
+	return "object is a symbolic link to " + e.target
+}
+
+func (fsys *s3FS) Symlink(oldName, newName string) error {
+	key := toKey(newName, false)
+
+	fsys.logger.Debug("Creating symbolic link", "key", key, "target", oldName)
+
+	if oldName == "" || key == "" {
+		return writablefs.ErrInvalid
+	}
+
+	// Don't clobber existing directories.
+	if _, err := fsys.isDir(fsys.ctx, newName); err == nil {
+		return writablefs.ErrExist
+	} else if !errors.Is(err, writablefs.ErrNotExist) {
+		return err
+	}
+
+	_, err := fsys.putObjectConditional(fsys.ctx, key, bytes.NewReader(nil), 0, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		UserMetadata: map[string]string{
+			symlinkTargetMetadataKey: url.PathEscape(oldName),
+		},
+	}, preconditions{ifNoneMatch: "*"})
+	if errors.Is(err, errPreconditionFailed) {
+		return writablefs.ErrExist
+	}
+
+	return err
+}
+
+func (fsys *s3FS) ReadLink(name string) (string, error) {
+	key := toKey(name, false)
+
+	fsys.logger.Debug("Reading symbolic link", "key", key)
+
+	info, err := fsys.client.StatObject(fsys.ctx, fsys.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: writablefs.ErrNotExist}
+		}
+
+		return "", err
+	}
+
+	target, ok := symlinkTarget(info)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: writablefs.ErrInvalid}
+	}
+
+	return target, nil
+}
+
+func (fsys *s3FS) Lstat(path string) (writablefs.FileInfo, error) {
+	fi, err := fsys.lstat(fsys.ctx, path)
+	if err != nil {
+		return nil, pathError("lstat", path, err)
+	}
+
+	return fi, nil
+}
+
+// followSymlinks resolves any symbolic links in the final component of path,
+// returning the resolved path and its status.
+func (fsys *s3FS) followSymlinks(ctx context.Context, path string) (string, *fileInfo, error) {
+	for i := 0; i < maxSymlinkHops; i++ {
+		fi, err := fsys.lstat(ctx, path)
+		if err != nil {
+			return "", nil, err
+		}
+
+		target, ok := symlinkTarget(fi.info)
+		if !ok {
+			return path, fi, nil
+		}
+
+		fsys.logger.Debug("Following symbolic link", "path", path, "target", target)
+
+		path, err = resolveSymlink(path, target)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return "", nil, errTooManySymlinks
+}
+
+// symlinkTarget returns the target of the object if it is a symbolic link.
+func symlinkTarget(info minio.ObjectInfo) (string, bool) {
+	value, ok := metadataValue(info, symlinkTargetMetadataKey)
+	if !ok {
+		return "", false
+	}
+
+	target, err := url.PathUnescape(value)
+	if err != nil {
+		return value, true
+	}
+
+	return target, true
+}
+
+// resolveSymlink returns the path that a symbolic link at linkPath, pointing
+// to target, refers to. Absolute targets are relative to the root of the
+// file system.
+func resolveSymlink(linkPath, target string) (string, error) {
+	var path string
+	if gopath.IsAbs(target) {
+		path = gopath.Clean(target)
+	} else {
+		path = gopath.Join(gopath.Dir(linkPath), target)
+	}
+
+	path = strings.TrimPrefix(path, "/")
+
+	// Links can't point outside of the file system.
+	if path == ".." || strings.HasPrefix(path, "../") {
+		return "", writablefs.ErrNotExist
+	}
+
+	return path, nil
+}
+
+func isReservedMetadataKey(key string) bool {
+	key = strings.ToLower(key)
+
+	return strings.HasPrefix(key, reservedMetadataPrefix) || isPOSIXMetadataKey(key)
+}
+
+// metadataValue looks up a user metadata value (keys are case insensitive).
+func metadataValue(info minio.ObjectInfo, key string) (string, bool) {
+	for k, value := range info.UserMetadata {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
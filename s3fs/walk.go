@@ -0,0 +1,306 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	gopath "path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/minio/minio-go/v7"
+)
+
+var _ writablefs.WalkFS = (*s3FS)(nil)
+
+// Walk walks the file tree using a single recursive listing, rather than
+// listing each directory separately. Directories that only exist implicitly
+// (as the prefix of other keys) are included. Entries are visited as the
+// listing is read, rather than once it is complete.
+func (fsys *s3FS) Walk(root string, fn fs.WalkDirFunc) error {
+	resolvedRoot, fi, err := fsys.followSymlinks(fsys.ctx, root)
+	if err != nil {
+		err = fn(root, nil, pathError("stat", root, err))
+		if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+			return nil
+		}
+
+		return err
+	}
+
+	// Like fs.WalkDir, use the name of the link rather than the target.
+	if resolvedRoot != root {
+		fi.name = gopath.Base(root)
+	}
+
+	rootEntry := fs.FileInfoToDirEntry(fi)
+
+	err = fn(root, rootEntry, nil)
+	if err != nil || !fi.IsDir() {
+		if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+			return nil
+		}
+
+		return err
+	}
+
+	prefix := toKey(resolvedRoot, true)
+
+	// Any entries under this prefix are skipped.
+	var skipPrefix string
+	var fnErr error
+
+	err = fsys.walkEntries(fsys.ctx, prefix, func(entry *dirEntry) error {
+		rel := strings.TrimPrefix(entry.key, prefix)
+
+		if skipPrefix != "" && strings.HasPrefix(rel, skipPrefix) {
+			return nil
+		}
+
+		if err := fn(gopath.Join(root, rel), entry, nil); err != nil {
+			if !errors.Is(err, fs.SkipDir) {
+				fnErr = err
+				return err
+			}
+
+			if entry.isDir {
+				skipPrefix = rel
+				return nil
+			}
+
+			// Skip the remaining files in the containing directory.
+			parent := gopath.Dir(strings.TrimSuffix(rel, "/"))
+			if parent == "." {
+				fnErr = fs.SkipAll
+				return fnErr
+			}
+
+			skipPrefix = parent + "/"
+		}
+
+		return nil
+	})
+	if fnErr != nil {
+		if errors.Is(fnErr, fs.SkipAll) {
+			return nil
+		}
+
+		return fnErr
+	} else if err != nil {
+		err = fn(root, rootEntry, pathError("readdir", root, err))
+		if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// walkEntries recursively lists the objects under the prefix (including any
+// implicit directories), calling visit for each of them in the order they
+// would be visited by fs.WalkDir.
+//
+// Keys are listed in byte order, which only differs from the walk order
+// where a separator is compared with a character that sorts before it (eg.
+// "dir/" and its contents are visited before "dir-1.txt", but listed after
+// it). So rather than collecting the whole listing, entries are only held
+// back until no key that is yet to be listed could be visited before them.
+func (fsys *s3FS) walkEntries(ctx context.Context, prefix string, visit func(entry *dirEntry) error) error {
+	fsys.logger.Debug("Recursively listing objects", "prefix", prefix)
+
+	// The listing is stopped as soon as the walk ends.
+	ctx, cancel := context.WithCancel(ctx)
+	objCh := fsys.client.ListObjects(ctx, fsys.bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+		MaxKeys:   fsys.listPageSize,
+	})
+	defer stopListing(cancel, objCh)
+
+	order := walkOrder{prefix: prefix}
+	var page []minio.ObjectInfo
+
+	// Each page of objects is checked for symbolic links before it is added.
+	addPage := func() error {
+		infos, err := fsys.statEmptyObjects(ctx, page)
+		if err != nil {
+			return err
+		}
+
+		for _, objInfo := range page {
+			// Synthesize any parent directories that don't have a marker.
+			// Keys with the same prefix are listed together, so if the
+			// previous key wasn't in a directory, it hasn't been seen yet.
+			rel := strings.TrimPrefix(strings.TrimSuffix(objInfo.Key, "/"), prefix)
+			for i := 0; i < len(rel); i++ {
+				if rel[i] != '/' {
+					continue
+				}
+
+				if dirKey := prefix + rel[:i+1]; !strings.HasPrefix(order.lastKey, dirKey) {
+					order.add(fsys.newWalkEntry(dirKey))
+				}
+			}
+
+			entry := fsys.newWalkEntry(objInfo.Key)
+			if info, ok := infos[objInfo.Key]; ok {
+				entry.userMetadata = info.UserMetadata
+				entry.info = &fileInfo{info: info}
+			}
+
+			order.add(entry)
+			order.lastKey = objInfo.Key
+		}
+
+		page = page[:0]
+
+		for _, entry := range order.settled() {
+			if err := visit(entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for objInfo := range objCh {
+		if objInfo.Err != nil {
+			return objInfo.Err
+		}
+
+		// Skip the directory itself (not all S3 implementations will return it).
+		if objInfo.Key == prefix {
+			continue
+		}
+
+		// Skip objects used internally by s3fs.
+		if strings.HasPrefix(objInfo.Key, internalPrefix) {
+			continue
+		}
+
+		page = append(page, objInfo)
+		if len(page) == fsys.listPageSize {
+			if err := addPage(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The listing ends early (without an error) if the context is cancelled.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := addPage(); err != nil {
+		return err
+	}
+
+	// Everything has been listed.
+	for _, entry := range order.pending {
+		if err := visit(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fsys *s3FS) newWalkEntry(key string) *dirEntry {
+	// Directory names have a trailing slash (like in a listing).
+	isDir := strings.HasSuffix(key, "/")
+	name := gopath.Base(key)
+	if isDir {
+		name += "/"
+	}
+
+	return &dirEntry{
+		fsys:  fsys,
+		key:   key,
+		name:  name,
+		isDir: isDir,
+	}
+}
+
+// walkOrder reorders the entries of a listing (in key order) into walk order.
+type walkOrder struct {
+	// The prefix of every listed key.
+	prefix string
+	// Entries that haven't been visited yet, in walk order.
+	pending []*dirEntry
+	// The last key that was listed.
+	lastKey string
+}
+
+func (o *walkOrder) add(entry *dirEntry) {
+	i := sort.Search(len(o.pending), func(i int) bool {
+		return walkLess(entry.key, o.pending[i].key)
+	})
+
+	o.pending = slices.Insert(o.pending, i, entry)
+}
+
+// settled removes and returns the pending entries that can be visited, as no
+// key listed after the last key could be visited before them.
+func (o *walkOrder) settled() []*dirEntry {
+	n := 0
+	for n < len(o.pending) && o.isSettled(o.pending[n].key) {
+		n++
+	}
+
+	settled := slices.Clone(o.pending[:n])
+	o.pending = slices.Delete(o.pending, 0, n)
+
+	return settled
+}
+
+// isSettled returns true if no key listed after the last key could be visited
+// before key. Such a key would have to be in a directory named by a prefix of
+// key (below the listed prefix) that is followed by a character that sorts
+// before the separator (eg. "dir/2.txt" for "dir-1.txt"), and the listing
+// would have to not have passed the contents of that directory yet.
+func (o *walkOrder) isSettled(key string) bool {
+	for i := len(o.prefix); i < len(key); i++ {
+		if key[i] >= '/' {
+			continue
+		}
+
+		dirKey := key[:i] + "/"
+		if o.lastKey < dirKey || strings.HasPrefix(o.lastKey, dirKey) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// walkLess orders keys depth-first, with each directory sorted by name. This
+// is key order, except that the separator sorts before any other character
+// (eg. "dir/" and its contents come before "dir-1.txt").
+func walkLess(a, b string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] == '/' {
+				return true
+			} else if b[i] == '/' {
+				return false
+			}
+
+			return a[i] < b[i]
+		}
+	}
+
+	return len(a) < len(b)
+}
@@ -10,42 +10,167 @@
 package s3fs
 
 import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"sort"
 	"strings"
-	"sync"
 
 	"github.com/bucket-sailor/writablefs"
 	"github.com/minio/minio-go/v7"
 )
 
+// Extended attribute names are lowercased (metadata headers are case
+// insensitive), and used as user metadata keys as is. So names in the user
+// namespace are unqualified (eg. "foo"), and names in other namespaces keep
+// their prefix (eg. "trusted.foo"), the same as writablefs.XAttrName(). This
+// means attributes listed by dirfs can be copied to s3fs (and back again).
+var _ writablefs.XAttrFS = (*s3FS)(nil)
+
+// GetXAttr reads the attribute from the object metadata, with a single HEAD
+// request for files (directories also check for a directory marker).
+func (fsys *s3FS) GetXAttr(name, attr string) ([]byte, error) {
+	attr = strings.ToLower(attr)
+
+	fsys.logger.Debug("Getting extended attribute", "name", name, "attr", attr)
+
+	xattrs, err := fsys.loadXAttrs(name)
+	if err != nil {
+		return nil, pathError("getxattr", name, err)
+	}
+
+	value, ok := xattrs[attr]
+	if !ok {
+		return nil, writablefs.ErrNoSuchAttr
+	}
+
+	return []byte(value), nil
+}
+
+// SetXAttr replaces the object metadata (with a server-side copy), without
+// downloading the object. Directories without a marker object have one
+// created to hold their attributes.
+func (fsys *s3FS) SetXAttr(name, attr string, data []byte) error {
+	attr = strings.ToLower(attr)
+
+	fsys.logger.Debug("Setting extended attribute", "name", name, "attr", attr)
+
+	if isReservedMetadataKey(attr) {
+		return &fs.PathError{Op: "setxattr", Path: name, Err: fmt.Errorf("extended attribute %q is reserved: %w", attr, writablefs.ErrInvalid)}
+	}
+
+	key, _, err := fsys.metadataKey(name)
+	if err != nil {
+		return pathError("setxattr", name, err)
+	}
+
+	err = fsys.updateMetadata(fsys.ctx, key, func(userMetadata map[string]string) {
+		userMetadata[attr] = string(data)
+	})
+	if err != nil {
+		return pathError("setxattr", name, err)
+	}
+
+	return nil
+}
+
+func (fsys *s3FS) RemoveXAttr(name, attr string) error {
+	attr = strings.ToLower(attr)
+
+	fsys.logger.Debug("Removing extended attribute", "name", name, "attr", attr)
+
+	if isReservedMetadataKey(attr) {
+		return &fs.PathError{Op: "removexattr", Path: name, Err: fmt.Errorf("extended attribute %q is reserved: %w", attr, writablefs.ErrInvalid)}
+	}
+
+	key, fi, err := fsys.metadataKey(name)
+	if err != nil {
+		return pathError("removexattr", name, err)
+	}
+
+	// Nothing to do (and no need to copy the object).
+	if _, ok := metadataValue(fi.info, attr); !ok && xattrSidecarID(fi.info.UserMetadata) == "" {
+		return nil
+	}
+
+	err = fsys.updateMetadata(fsys.ctx, key, func(userMetadata map[string]string) {
+		delete(userMetadata, attr)
+	})
+	if err != nil {
+		return pathError("removexattr", name, err)
+	}
+
+	return nil
+}
+
+func (fsys *s3FS) ListXAttrs(name string) ([]string, error) {
+	fsys.logger.Debug("Listing extended attributes", "name", name)
+
+	xattrs, err := fsys.loadXAttrs(name)
+	if err != nil {
+		return nil, pathError("listxattr", name, err)
+	}
+
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// loadXAttrs returns the extended attributes of the named file (following
+// symbolic links).
+func (fsys *s3FS) loadXAttrs(name string) (map[string]string, error) {
+	resolvedPath, fi, err := fsys.followSymlinks(fsys.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := fsys.decodeMetadata(fsys.ctx, toKey(resolvedPath, fi.IsDir()), fi.info.UserMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metadata used internally by s3fs isn't exposed as an xattr.
+	for name := range metadata {
+		if isReservedMetadataKey(name) {
+			delete(metadata, name)
+		}
+	}
+
+	return metadata, nil
+}
+
 type attrChange struct {
 	name   string
 	value  string
 	remove bool
 }
 
+// s3Attrs is the extended attributes of an open file. The attributes, and
+// any pending changes, are shared by every handle of the file: changes made
+// through one handle are immediately visible through the others, and are
+// committed by calling Sync() on any of them. When the last handle of the
+// file is closed, uncommitted changes are uploaded along with any staged
+// contents, otherwise they are discarded.
 type s3Attrs struct {
 	fsys   *s3FS
 	handle *fileHandle
-	// A cache of the extended attributes.
-	cache map[string]string
-	// Pending changes to the extended attributes.
-	changesMu sync.Mutex
-	changes   map[string]attrChange
 }
 
 func newS3Attrs(fsys *s3FS, handle *fileHandle) (*s3Attrs, error) {
-	a := &s3Attrs{
-		fsys:    fsys,
-		handle:  handle,
-		cache:   make(map[string]string),
-		changes: make(map[string]attrChange),
-	}
-
-	if err := a.Sync(); err != nil {
+	if err := handle.file.loadXAttrs(fsys.ctx); err != nil {
 		return nil, err
 	}
 
-	return a, nil
+	return &s3Attrs{
+		fsys:   fsys,
+		handle: handle,
+	}, nil
 }
 
 func (a *s3Attrs) Get(name string) ([]byte, error) {
@@ -53,16 +178,20 @@ func (a *s3Attrs) Get(name string) ([]byte, error) {
 
 	a.fsys.logger.Debug("Getting extended attribute", "key", a.handle.file.key, "name", name)
 
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	// check the pending changes first.
-	if change, ok := a.changes[name]; ok {
+	if change, ok := f.xattrChanges[name]; ok {
 		if change.remove {
 			return nil, writablefs.ErrNoSuchAttr
 		}
 		return []byte(change.value), nil
 	}
 
-	// check the cache.
-	if value, ok := a.cache[name]; ok {
+	if value, ok := f.xattrs[name]; ok {
 		return []byte(value), nil
 	}
 
@@ -78,12 +207,14 @@ func (a *s3Attrs) Set(name string, data []byte) error {
 		return writablefs.ErrPermission
 	}
 
-	a.changesMu.Lock()
-	a.changes[name] = attrChange{
+	if isReservedMetadataKey(name) {
+		return fmt.Errorf("extended attribute %q is reserved: %w", name, writablefs.ErrInvalid)
+	}
+
+	a.handle.file.changeXAttr(attrChange{
 		name:  name,
 		value: string(data),
-	}
-	a.changesMu.Unlock()
+	})
 
 	return nil
 }
@@ -97,12 +228,14 @@ func (a *s3Attrs) Remove(name string) error {
 		return writablefs.ErrPermission
 	}
 
-	a.changesMu.Lock()
-	a.changes[name] = attrChange{
+	if isReservedMetadataKey(name) {
+		return fmt.Errorf("extended attribute %q is reserved: %w", name, writablefs.ErrInvalid)
+	}
+
+	a.handle.file.changeXAttr(attrChange{
 		name:   name,
 		remove: true,
-	}
-	a.changesMu.Unlock()
+	})
 
 	return nil
 }
@@ -110,12 +243,17 @@ func (a *s3Attrs) Remove(name string) error {
 func (a *s3Attrs) List() ([]string, error) {
 	a.fsys.logger.Debug("Listing extended attributes", "key", a.handle.file.key)
 
+	f := a.handle.file
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	keys := make(map[string]struct{})
-	for key := range a.cache {
+	for key := range f.xattrs {
 		keys[key] = struct{}{}
 	}
 
-	for _, change := range a.changes {
+	for _, change := range f.xattrChanges {
 		if change.remove {
 			delete(keys, change.name)
 		} else {
@@ -128,60 +266,160 @@ func (a *s3Attrs) List() ([]string, error) {
 		names = append(names, key)
 	}
 
+	sort.Strings(names)
+
 	return names, nil
 }
 
 func (a *s3Attrs) Sync() error {
 	a.fsys.logger.Debug("Syncing extended attributes", "key", a.handle.file.key)
 
-	// Populate the cache with the current metadata.
-	info, err := a.fsys.client.StatObject(a.fsys.ctx, a.fsys.bucketName, a.handle.file.key, minio.StatObjectOptions{})
+	return a.handle.file.syncXAttrs(a.fsys.ctx)
+}
+
+// changeXAttr records a pending change to the extended attributes.
+func (f *file) changeXAttr(change attrChange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.xattrChanges == nil {
+		f.xattrChanges = make(map[string]attrChange)
+	}
+
+	f.xattrChanges[change.name] = change
+}
+
+// loadXAttrs refreshes the extended attributes of the file (keeping any
+// pending changes).
+func (f *file) loadXAttrs(ctx context.Context) error {
+	f.mu.Lock()
+	dirty, userMetadata, gen := f.dirty, f.userMetadata, f.xattrsGen
+	f.mu.Unlock()
+
+	// Otherwise the attributes of the version the staged changes are based on.
+	if !dirty {
+		info, err := f.fsys.client.StatObject(ctx, f.fsys.bucketName, f.key, minio.StatObjectOptions{})
+		if err != nil {
+			return err
+		}
+
+		userMetadata = info.UserMetadata
+	}
+
+	metadata, err := f.fsys.decodeMetadata(ctx, f.key, userMetadata)
 	if err != nil {
 		return err
 	}
 
-	a.cache = make(map[string]string)
-	for key, value := range info.UserMetadata {
-		a.cache[strings.ToLower(key)] = value
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.xattrsGen == gen {
+		f.setXAttrs(metadata)
 	}
 
-	a.changesMu.Lock()
-	defer a.changesMu.Unlock()
+	return nil
+}
 
-	// No changes to commit.
-	if len(a.changes) == 0 {
-		a.fsys.logger.Debug("No changes to commit", "key", a.handle.file.key)
+// syncXAttrs commits any pending changes. If the file has staged changes, the
+// attributes are uploaded along with its contents (in a single request),
+// otherwise the metadata of the object is replaced with a server-side copy.
+// Changes made while the metadata is being replaced are left pending.
+func (f *file) syncXAttrs(ctx context.Context) error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
 
-		return nil
-	}
+	f.mu.Lock()
 
-	for name, change := range a.changes {
-		if change.remove {
-			delete(a.cache, name)
-		} else {
-			a.cache[name] = change.value
+	if f.dirty {
+		defer f.mu.Unlock()
+
+		// Don't upload the contents if there is nothing to commit.
+		if len(f.xattrChanges) == 0 {
+			return nil
 		}
-	}
 
-	copySrc := minio.CopySrcOptions{
-		Bucket: a.fsys.bucketName,
-		Object: a.handle.file.key,
+		f.fsys.logger.Debug("Uploading extended attributes with contents", "key", f.key)
+
+		return f.sync(ctx)
 	}
 
-	copyDst := minio.CopyDestOptions{
-		Bucket:          a.fsys.bucketName,
-		Object:          a.handle.file.key,
-		UserMetadata:    a.cache,
-		ReplaceMetadata: true,
+	changes := maps.Clone(f.xattrChanges)
+	gen := f.xattrsGen
+
+	f.mu.Unlock()
+
+	// Populate the cache with the current metadata.
+	info, err := f.fsys.client.StatObject(ctx, f.fsys.bucketName, f.key, minio.StatObjectOptions{})
+	if err != nil {
+		return err
 	}
 
-	_, err = a.fsys.client.CopyObject(a.fsys.ctx, copyDst, copySrc)
+	metadata, err := f.fsys.decodeMetadata(ctx, f.key, info.UserMetadata)
 	if err != nil {
 		return err
 	}
 
-	// Clear the pending changes.
-	a.changes = make(map[string]attrChange)
+	// No changes to commit.
+	if len(changes) == 0 {
+		f.fsys.logger.Debug("No changes to commit", "key", f.key)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.xattrsGen == gen {
+			f.setXAttrs(metadata)
+		}
+
+		return nil
+	}
+
+	applyXAttrChanges(metadata, changes)
+
+	// This also updates the cached attributes (of every open file).
+	if _, err := f.fsys.replaceMetadata(ctx, f.key, info, metadata); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.clearXAttrChanges(changes)
 
 	return nil
 }
+
+// setXAttrs replaces the cached attributes with those in the (decoded)
+// metadata. f.mu must be held.
+func (f *file) setXAttrs(metadata map[string]string) {
+	f.xattrs = make(map[string]string)
+	for name, value := range metadata {
+		// Metadata used internally by s3fs isn't exposed as an xattr.
+		if !isReservedMetadataKey(name) {
+			f.xattrs[name] = value
+		}
+	}
+
+	f.xattrsGen++
+}
+
+// clearXAttrChanges removes the committed changes from the pending changes,
+// unless they have been changed again since. f.mu must be held.
+func (f *file) clearXAttrChanges(changes map[string]attrChange) {
+	for name, change := range changes {
+		if f.xattrChanges[name] == change {
+			delete(f.xattrChanges, name)
+		}
+	}
+}
+
+// applyXAttrChanges applies the changes to the (decoded) metadata.
+func applyXAttrChanges(metadata map[string]string, changes map[string]attrChange) {
+	for name, change := range changes {
+		if change.remove {
+			delete(metadata, name)
+		} else {
+			metadata[name] = change.value
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// ReadDirStream opens an incremental listing of the named directory, resuming
+// after token (if not empty). The returned stream must be closed. If the file
+// system does not implement ReadDirStreamFS, the directory is read in full
+// with ReadDirContext, the token is the name of the last returned entry, and
+// the context is only checked before each call to Next.
+func ReadDirStream(ctx context.Context, fsys FS, path, token string) (DirStream, error) {
+	fsys, path = resolve(fsys, path)
+
+	if streamFsys, ok := fsys.(ReadDirStreamFS); ok {
+		return streamFsys.ReadDirStream(ctx, path, token)
+	}
+
+	entries, err := ReadDirContext(ctx, fsys, path)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	// Skip any entries that have already been returned.
+	if token != "" {
+		i := sort.Search(len(entries), func(i int) bool {
+			return entries[i].Name() > token
+		})
+		entries = entries[i:]
+	}
+
+	return &sliceDirStream{ctx: ctx, path: path, entries: entries, token: token}, nil
+}
+
+// sliceDirStream is a DirStream over a directory that has already been read.
+type sliceDirStream struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	path    string
+	entries []DirEntry
+	token   string
+	closed  bool
+}
+
+func (s *sliceDirStream) Next(n int) ([]DirEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: s.path, Err: ErrClosed}
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if n > 0 && len(s.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n <= 0 || n > len(s.entries) {
+		n = len(s.entries)
+	}
+
+	entries := s.entries[:n]
+	s.entries = s.entries[n:]
+
+	if len(entries) > 0 {
+		s.token = entries[len(entries)-1].Name()
+	}
+
+	return entries, nil
+}
+
+func (s *sliceDirStream) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+func (s *sliceDirStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.entries = nil
+
+	return nil
+}
@@ -11,7 +11,9 @@ package writablefs
 
 import (
 	"io/fs"
+	gopath "path"
 	"path/filepath"
+	"strings"
 )
 
 type subFS struct {
@@ -31,7 +33,11 @@ func (fsys *subFS) Close() error {
 }
 
 func (fsys *subFS) Open(path string) (FileReadOnly, error) {
-	return fsys.parentFsys.OpenFile(path, FlagReadOnly)
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: ErrInvalid}
+	}
+
+	return fsys.OpenFile(path, FlagReadOnly)
 }
 
 func (fsys *subFS) OpenFile(path string, flag FileOpenFlag) (File, error) {
@@ -57,3 +63,56 @@ func (fsys *subFS) Rename(oldPath string, newPath string) error {
 func (fsys *subFS) Stat(path string) (fs.FileInfo, error) {
 	return fsys.parentFsys.Stat(filepath.Join(fsys.prefix, filepath.Clean(path)))
 }
+
+func (fsys *subFS) Mkdir(path string) error {
+	return Mkdir(fsys.parentFsys, filepath.Join(fsys.prefix, filepath.Clean(path)))
+}
+
+func (fsys *subFS) Remove(path string) error {
+	return Remove(fsys.parentFsys, filepath.Join(fsys.prefix, filepath.Clean(path)))
+}
+
+func (fsys *subFS) ReadFile(path string) ([]byte, error) {
+	if !fs.ValidPath(path) {
+		return nil, &fs.PathError{Op: "readfile", Path: path, Err: ErrInvalid}
+	}
+
+	return ReadFile(fsys.parentFsys, filepath.Join(fsys.prefix, filepath.Clean(path)))
+}
+
+func (fsys *subFS) WriteFile(path string, data []byte) error {
+	return WriteFile(fsys.parentFsys, filepath.Join(fsys.prefix, filepath.Clean(path)), data)
+}
+
+func (fsys *subFS) Glob(pattern string) ([]string, error) {
+	// Check the pattern is well-formed.
+	if _, err := gopath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.ToSlash(filepath.Clean(fsys.prefix))
+	if prefix == "." {
+		return fs.Glob(fsys.parentFsys, pattern)
+	}
+
+	matches, err := fs.Glob(fsys.parentFsys, escapeGlob(prefix)+"/"+pattern)
+	for i, match := range matches {
+		matches[i] = strings.TrimPrefix(match, prefix+"/")
+	}
+
+	return matches, err
+}
+
+// escapeGlob escapes any characters that have a special meaning in patterns.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			sb.WriteRune('\\')
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
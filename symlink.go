@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"io/fs"
+)
+
+// Symlink creates newName as a symbolic link to oldName. If the file system
+// does not implement SymlinkFS, ErrUnsupported is returned.
+func Symlink(fsys FS, oldName, newName string) error {
+	fsys, newName = resolve(fsys, newName)
+
+	symlinkFsys, ok := fsys.(SymlinkFS)
+	if !ok {
+		return &fs.PathError{Op: "symlink", Path: newName, Err: ErrUnsupported}
+	}
+
+	return symlinkFsys.Symlink(oldName, newName)
+}
+
+// ReadLink returns the destination of the named symbolic link. If the file
+// system does not implement SymlinkFS, ErrUnsupported is returned.
+func ReadLink(fsys FS, name string) (string, error) {
+	fsys, name = resolve(fsys, name)
+
+	symlinkFsys, ok := fsys.(SymlinkFS)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: ErrUnsupported}
+	}
+
+	return symlinkFsys.ReadLink(name)
+}
+
+// Lstat returns a FileInfo describing the named file, without following
+// symbolic links. If the file system does not implement SymlinkFS, Lstat
+// falls back to Stat.
+func Lstat(fsys FS, name string) (FileInfo, error) {
+	fsys, name = resolve(fsys, name)
+
+	symlinkFsys, ok := fsys.(SymlinkFS)
+	if !ok {
+		return fsys.Stat(name)
+	}
+
+	return symlinkFsys.Lstat(name)
+}
@@ -0,0 +1,171 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// The number of names to try before giving up.
+const maxTempAttempts = 10000
+
+// CreateTemp creates a new file in the directory dir, opened for reading and
+// writing, and returns it along with its path. The name is generated by
+// replacing the last "*" in pattern with a random string (or appending one if
+// there is no "*"). Files are created with FlagExclusive, so two callers can
+// never be given the same file (on backends where exclusive creates are
+// atomic), otherwise the random part of the name makes collisions unlikely.
+func CreateTemp(fsys FS, dir, pattern string) (File, string, error) {
+	prefix, suffix, err := splitTempPattern(pattern)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "createtemp", Path: pattern, Err: err}
+	}
+
+	for i := 0; i < maxTempAttempts; i++ {
+		name := path.Join(dir, prefix+randomName()+suffix)
+
+		f, err := fsys.OpenFile(name, FlagCreate|FlagExclusive|FlagReadWrite)
+		if errors.Is(err, ErrExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		return f, name, nil
+	}
+
+	return nil, "", &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: ErrExist}
+}
+
+// MkdirTemp creates a new directory in the directory dir and returns its path.
+// The name is generated in the same way as CreateTemp. The directory is created
+// with Mkdir, so is guaranteed to be unique if the file system implements
+// MkdirFS, otherwise the random part of the name makes collisions unlikely.
+func MkdirTemp(fsys FS, dir, pattern string) (string, error) {
+	prefix, suffix, err := splitTempPattern(pattern)
+	if err != nil {
+		return "", &fs.PathError{Op: "mkdirtemp", Path: pattern, Err: err}
+	}
+
+	for i := 0; i < maxTempAttempts; i++ {
+		name := path.Join(dir, prefix+randomName()+suffix)
+
+		err := Mkdir(fsys, name)
+		if errors.Is(err, ErrExist) {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return name, nil
+	}
+
+	return "", &fs.PathError{Op: "mkdirtemp", Path: path.Join(dir, pattern), Err: ErrExist}
+}
+
+// TempFiles creates temporary files and directories, and keeps track of them
+// so that they can all be removed when it is closed (eg. for scratch space).
+type TempFiles struct {
+	fsys  FS
+	mu    sync.Mutex
+	paths []string
+}
+
+// NewTempFiles returns a TempFiles that creates temporary files in fsys.
+func NewTempFiles(fsys FS) *TempFiles {
+	return &TempFiles{fsys: fsys}
+}
+
+// CreateTemp is like CreateTemp, but the file is removed when t is closed.
+func (t *TempFiles) CreateTemp(dir, pattern string) (File, string, error) {
+	f, name, err := CreateTemp(t.fsys, dir, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t.track(name)
+
+	return f, name, nil
+}
+
+// MkdirTemp is like MkdirTemp, but the directory (and anything inside it) is
+// removed when t is closed.
+func (t *TempFiles) MkdirTemp(dir, pattern string) (string, error) {
+	name, err := MkdirTemp(t.fsys, dir, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	t.track(name)
+
+	return name, nil
+}
+
+// Close removes all of the temporary files and directories that have been
+// created. Any files that are still open should be closed first.
+func (t *TempFiles) Close() error {
+	t.mu.Lock()
+	paths := t.paths
+	t.paths = nil
+	t.mu.Unlock()
+
+	var errs []error
+
+	// Remove in reverse order, so nested temporary files go first.
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := t.fsys.RemoveAll(paths[i]); err != nil && !errors.Is(err, ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t *TempFiles) track(name string) {
+	t.mu.Lock()
+	t.paths = append(t.paths, name)
+	t.mu.Unlock()
+}
+
+// splitTempPattern splits the pattern into the parts before and after the
+// random string.
+func splitTempPattern(pattern string) (prefix, suffix string, err error) {
+	if strings.ContainsAny(pattern, `/\`) {
+		return "", "", fmt.Errorf("pattern contains path separator: %w", ErrInvalid)
+	}
+
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		return pattern[:i], pattern[i+1:], nil
+	}
+
+	return pattern, "", nil
+}
+
+// randomName returns a random string that is unlikely to collide with any
+// other (even across processes and machines).
+func randomName() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b[:])
+}
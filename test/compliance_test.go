@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/writablefstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateCompliance = flag.Bool("update-compliance", false, "update the POSIX compliance reports in testdata/posix")
+
+// testPOSIXCompliance checks the POSIX compliance of a backend hasn't changed
+// from its documented report (in testdata/posix/<name>.txt).
+func testPOSIXCompliance(t *testing.T, name string, newFS func() writablefs.FS) {
+	t.Run("POSIX Compliance", func(t *testing.T) {
+		report := writablefstest.TestPOSIX(t, newFS)
+
+		reportPath := filepath.Join("testdata", "posix", name+".txt")
+
+		// The results of privileged cases depend on who runs them, the
+		// reports record the results when running as root.
+		privileged := os.Geteuid() == 0
+
+		if *updateCompliance {
+			require.True(t, privileged, "compliance reports must be updated as root")
+			require.NoError(t, os.MkdirAll(filepath.Dir(reportPath), 0o755))
+			require.NoError(t, os.WriteFile(reportPath, []byte(report.String()), 0o644))
+			return
+		}
+
+		expectedData, err := os.ReadFile(reportPath)
+		require.NoError(t, err)
+
+		expected, actual := string(expectedData), report.String()
+		if !privileged {
+			expected, actual = withoutPrivilegedCases(report, expected), withoutPrivilegedCases(report, actual)
+		}
+
+		assert.Equal(t, expected, actual, "compliance has changed, run with -update-compliance if this is expected")
+	})
+}
+
+// withoutPrivilegedCases removes the lines of the privileged cases from the
+// text of a compliance report, along with the summary (which counts them).
+func withoutPrivilegedCases(report *writablefstest.Report, text string) string {
+	privileged := make(map[string]bool)
+	for _, c := range report.Cases {
+		if c.Privileged {
+			privileged[c.Name] = true
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "#" || privileged[fields[0]] {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConflicts checks that concurrent modifications made through otherFsys
+// (eg. another machine) are detected by fsys.
+func testConflicts(t *testing.T, fsys, otherFsys writablefs.FS) {
+	t.Run("Conflicts", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+		otherFsys := writablefs.Sub(otherFsys, testDir)
+
+		t.Run("Concurrent Modification", func(t *testing.T) {
+			writeFile(t, fsys, "modified.txt", "original")
+
+			f, err := fsys.OpenFile("modified.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			writeFile(t, otherFsys, "modified.txt", "theirs")
+
+			_, err = f.Write([]byte("mine"))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, f.Sync(), writablefs.ErrConflict)
+			assert.ErrorIs(t, f.Close(), writablefs.ErrConflict)
+
+			// Their changes should have been preserved.
+			assert.Equal(t, "theirs", readFile(t, fsys, "modified.txt"))
+		})
+
+		t.Run("Concurrent Create", func(t *testing.T) {
+			f, err := fsys.OpenFile("created.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			writeFile(t, otherFsys, "created.txt", "theirs")
+
+			_, err = f.Write([]byte("mine"))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, f.Close(), writablefs.ErrConflict)
+
+			assert.Equal(t, "theirs", readFile(t, fsys, "created.txt"))
+		})
+
+		t.Run("Sequential Syncs", func(t *testing.T) {
+			f, err := fsys.OpenFile("synced.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			// Our own uploads must not be mistaken for concurrent modifications.
+			for _, data := range []string{"one", "two", "three"} {
+				_, err = f.WriteAt([]byte(data), 0)
+				require.NoError(t, err)
+
+				require.NoError(t, f.Sync())
+			}
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "three", readFile(t, fsys, "synced.txt"))
+		})
+	})
+}
+
+// testRefreshOnSync checks that fsys picks up modifications made through
+// otherFsys when syncing a file with no local changes.
+func testRefreshOnSync(t *testing.T, fsys, otherFsys writablefs.FS) {
+	t.Run("Refresh on Sync", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+		otherFsys := writablefs.Sub(otherFsys, testDir)
+
+		writeFile(t, fsys, "refreshed.txt", "original")
+
+		f, err := fsys.OpenFile("refreshed.txt", writablefs.FlagReadWrite)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, f.Close())
+		})
+
+		writeFile(t, otherFsys, "refreshed.txt", "theirs")
+
+		require.NoError(t, f.Sync())
+
+		data, err := io.ReadAll(io.NewSectionReader(f, 0, 1024))
+		require.NoError(t, err)
+
+		assert.Equal(t, "theirs", string(data))
+
+		// And we should be able to build on top of their changes.
+		_, err = f.WriteAt([]byte("THEIRS"), 0)
+		require.NoError(t, err)
+
+		require.NoError(t, f.Sync())
+
+		assert.Equal(t, "THEIRS", readFile(t, otherFsys, "refreshed.txt"))
+	})
+}
@@ -0,0 +1,150 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testContext(t *testing.T, fsys writablefs.FS) {
+	t.Run("Context", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		ctx := context.Background()
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		t.Run("Read and Write", func(t *testing.T) {
+			f, err := writablefs.OpenFileContext(ctx, fsys, "file.txt", writablefs.FlagReadWrite|writablefs.FlagCreate)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("hello world"))
+			require.NoError(t, err)
+
+			require.NoError(t, writablefs.SyncContext(ctx, f))
+
+			_, err = f.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+
+			buf := make([]byte, 5)
+			n, err := writablefs.ReadContext(ctx, f, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(buf[:n]))
+
+			n, err = writablefs.ReadAtContext(ctx, f, buf, 6)
+			require.NoError(t, err)
+			assert.Equal(t, "world", string(buf[:n]))
+
+			require.NoError(t, writablefs.CloseContext(ctx, f))
+
+			fi, err := writablefs.StatContext(ctx, fsys, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(11), fi.Size())
+
+			entries, err := writablefs.ReadDirContext(ctx, fsys, ".")
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "file.txt", entries[0].Name())
+		})
+
+		t.Run("Read - Remote", func(t *testing.T) {
+			f, err := writablefs.OpenFileContext(ctx, fsys, "file.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+			defer f.Close()
+
+			var data []byte
+			buf := make([]byte, 4)
+			for {
+				n, err := writablefs.ReadContext(ctx, f, buf)
+				data = append(data, buf[:n]...)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, "hello world", string(data))
+		})
+
+		t.Run("Read - Cancelled", func(t *testing.T) {
+			f, err := writablefs.OpenFileContext(ctx, fsys, "file.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+			defer f.Close()
+
+			buf := make([]byte, 6)
+			n, err := writablefs.ReadContext(ctx, f, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "hello ", string(buf[:n]))
+
+			_, err = writablefs.ReadContext(cancelledCtx, f, buf)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			// The next read carries on where the last one left off.
+			n, err = io.ReadFull(readerFunc(func(p []byte) (int, error) {
+				return writablefs.ReadContext(ctx, f, p)
+			}), buf[:5])
+			require.NoError(t, err)
+			assert.Equal(t, "world", string(buf[:n]))
+		})
+
+		t.Run("Cancelled", func(t *testing.T) {
+			_, err := writablefs.OpenFileContext(cancelledCtx, fsys, "new.txt", writablefs.FlagReadWrite|writablefs.FlagCreate)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = writablefs.StatContext(cancelledCtx, fsys, "file.txt")
+			assert.ErrorIs(t, err, context.Canceled)
+
+			_, err = writablefs.ReadDirContext(cancelledCtx, fsys, ".")
+			assert.ErrorIs(t, err, context.Canceled)
+
+			assert.ErrorIs(t, writablefs.MkdirAllContext(cancelledCtx, fsys, "dir"), context.Canceled)
+			assert.ErrorIs(t, writablefs.RenameContext(cancelledCtx, fsys, "file.txt", "renamed.txt"), context.Canceled)
+			assert.ErrorIs(t, writablefs.RemoveAllContext(cancelledCtx, fsys, "file.txt"), context.Canceled)
+
+			// Nothing should have changed.
+			entries, err := fsys.ReadDir(".")
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "file.txt", entries[0].Name())
+		})
+
+		t.Run("Sync - Cancelled", func(t *testing.T) {
+			f, err := fsys.OpenFile("file.txt", writablefs.FlagReadWrite|writablefs.FlagTruncate)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("goodbye"))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, writablefs.SyncContext(cancelledCtx, f), context.Canceled)
+
+			// The pending changes should still be written on close.
+			require.NoError(t, writablefs.CloseContext(ctx, f))
+
+			assert.Equal(t, "goodbye", readFile(t, fsys, "file.txt"))
+		})
+	})
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
@@ -0,0 +1,141 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/dirfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCopy(t *testing.T, fsys writablefs.FS) {
+	t.Run("Copy", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		require.NoError(t, fsys.MkdirAll("src/nested"))
+
+		writeFile(t, fsys, "src/a.txt", "a")
+		writeFile(t, fsys, "src/nested/b.txt", "b")
+
+		f, err := fsys.OpenFile("src/a.txt", writablefs.FlagReadWrite)
+		require.NoError(t, err)
+
+		xattrs, err := f.XAttrs()
+		require.NoError(t, err)
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+		require.NoError(t, xattrs.Sync())
+		require.NoError(t, f.Close())
+
+		t.Run("File", func(t *testing.T) {
+			require.NoError(t, writablefs.Copy(fsys, "src/a.txt", fsys, "copy.txt"))
+
+			assert.Equal(t, "a", readFile(t, fsys, "copy.txt"))
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "copy.txt", "test-attr"))
+
+			// The source should be untouched.
+			assert.Equal(t, "a", readFile(t, fsys, "src/a.txt"))
+		})
+
+		t.Run("File - Replace Existing", func(t *testing.T) {
+			writeFile(t, fsys, "existing.txt", "a much longer file")
+
+			require.NoError(t, writablefs.Copy(fsys, "src/nested/b.txt", fsys, "existing.txt"))
+
+			assert.Equal(t, "b", readFile(t, fsys, "existing.txt"))
+		})
+
+		t.Run("File - Non-Existent", func(t *testing.T) {
+			err := writablefs.Copy(fsys, "missing.txt", fsys, "other.txt")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Directory", func(t *testing.T) {
+			require.NoError(t, writablefs.CopyAll(fsys, "src", fsys, "dst"))
+
+			assert.Equal(t, "a", readFile(t, fsys, "dst/a.txt"))
+			assert.Equal(t, "b", readFile(t, fsys, "dst/nested/b.txt"))
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "dst/a.txt", "test-attr"))
+
+			assert.Error(t, writablefs.CopyAll(fsys, "src", fsys, "src/nested/dst"))
+		})
+
+		t.Run("Between File Systems", func(t *testing.T) {
+			otherFsys, err := dirfs.New(t.TempDir())
+			require.NoError(t, err)
+
+			require.NoError(t, writablefs.CopyAll(fsys, "src", otherFsys, "dst"))
+
+			assert.Equal(t, "a", readFile(t, otherFsys, "dst/a.txt"))
+			assert.Equal(t, "b", readFile(t, otherFsys, "dst/nested/b.txt"))
+			assert.Equal(t, "test-value", readXAttr(t, otherFsys, "dst/a.txt", "test-attr"))
+
+			require.NoError(t, writablefs.Copy(otherFsys, "dst/a.txt", fsys, "back.txt"))
+
+			assert.Equal(t, "a", readFile(t, fsys, "back.txt"))
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "back.txt", "test-attr"))
+
+			// Closing can upload the file, so the copy is only closed once.
+			countingFsys := &closeCountingFS{FS: otherFsys}
+			require.NoError(t, writablefs.Copy(fsys, "src/a.txt", countingFsys, "counted.txt"))
+
+			assert.Equal(t, int32(1), countingFsys.closes.Load())
+			assert.Equal(t, "a", readFile(t, otherFsys, "counted.txt"))
+		})
+	})
+}
+
+// closeCountingFS counts how many times the files it opens are closed.
+type closeCountingFS struct {
+	writablefs.FS
+	closes atomic.Int32
+}
+
+func (fsys *closeCountingFS) OpenFile(name string, flag writablefs.FileOpenFlag) (writablefs.File, error) {
+	f, err := fsys.FS.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &closeCountingFile{File: f, fsys: fsys}, nil
+}
+
+type closeCountingFile struct {
+	writablefs.File
+	fsys *closeCountingFS
+}
+
+func (f *closeCountingFile) Close() error {
+	f.fsys.closes.Add(1)
+	return f.File.Close()
+}
+
+func readXAttr(t *testing.T, fsys writablefs.FS, path, name string) string {
+	f, err := fsys.OpenFile(path, writablefs.FlagReadOnly)
+	require.NoError(t, err)
+
+	xattrs, err := f.XAttrs()
+	require.NoError(t, err)
+
+	value, err := xattrs.Get(name)
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+
+	return string(value)
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOpenFlags(t *testing.T, fsys writablefs.FS) {
+	t.Run("Open Flags", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Read Only", func(t *testing.T) {
+			assert.True(t, writablefs.FlagReadOnly.IsReadOnly())
+			assert.True(t, (writablefs.FlagReadOnly | writablefs.FlagCreate).IsReadOnly())
+			assert.False(t, writablefs.FlagWriteOnly.IsReadOnly())
+			assert.False(t, (writablefs.FlagReadWrite | writablefs.FlagAppend).IsReadOnly())
+
+			// IsSet only checks bits, and FlagReadOnly has none.
+			assert.False(t, writablefs.FlagReadOnly.IsSet(writablefs.FlagReadOnly))
+		})
+
+		t.Run("Read Only - Non-Existent", func(t *testing.T) {
+			_, err := fsys.OpenFile("missing.txt", writablefs.FlagReadOnly)
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Exclusive", func(t *testing.T) {
+			f, err := fsys.OpenFile("exclusive.txt", writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("first"))
+			require.NoError(t, err)
+
+			require.NoError(t, f.Close())
+
+			_, err = fsys.OpenFile("exclusive.txt", writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+			assert.ErrorIs(t, err, writablefs.ErrExist)
+
+			assert.Equal(t, "first", readFile(t, fsys, "exclusive.txt"))
+		})
+
+		t.Run("Exclusive - Concurrent", func(t *testing.T) {
+			const numWriters = 8
+
+			var wg sync.WaitGroup
+			var created atomic.Int32
+			errs := make(chan error, numWriters)
+
+			for i := 0; i < numWriters; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					f, err := fsys.OpenFile("claim.txt", writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					created.Add(1)
+					errs <- f.Close()
+				}()
+			}
+
+			wg.Wait()
+			close(errs)
+
+			assert.Equal(t, int32(1), created.Load())
+
+			for err := range errs {
+				if err != nil {
+					assert.ErrorIs(t, err, writablefs.ErrExist)
+				}
+			}
+		})
+
+		t.Run("Truncate", func(t *testing.T) {
+			writeFile(t, fsys, "truncate.txt", "hello world")
+
+			f, err := fsys.OpenFile("truncate.txt", writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("bye"))
+			require.NoError(t, err)
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "bye", readFile(t, fsys, "truncate.txt"))
+		})
+
+		t.Run("Truncate - Non-Existent", func(t *testing.T) {
+			_, err := fsys.OpenFile("missing.txt", writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Append", func(t *testing.T) {
+			writeFile(t, fsys, "append.txt", "hello")
+
+			f, err := fsys.OpenFile("append.txt", writablefs.FlagAppend|writablefs.FlagWriteOnly)
+			require.NoError(t, err)
+
+			// Writes should go to the end of the file regardless of the offset.
+			_, err = f.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte(" world"))
+			require.NoError(t, err)
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "hello world", readFile(t, fsys, "append.txt"))
+		})
+	})
+}
+
+func writeFile(t *testing.T, fsys writablefs.FS, path, contents string) {
+	f, err := fsys.OpenFile(path, writablefs.FlagCreate|writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte(contents))
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+}
+
+func readFile(t *testing.T, fsys writablefs.FS, path string) string {
+	f, err := fsys.OpenFile(path, writablefs.FlagReadOnly)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+
+	return string(data)
+}
@@ -0,0 +1,40 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/require"
+)
+
+// testFSTest checks the file system satisfies the io/fs contracts.
+func testFSTest(t *testing.T, fsys writablefs.FS) {
+	t.Run("io/fs Compliance", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		require.NoError(t, fsys.MkdirAll("dir/nested"))
+		require.NoError(t, fsys.MkdirAll("empty"))
+
+		writeFile(t, fsys, "a.txt", "a")
+		writeFile(t, fsys, "a-b.txt", "a-b")
+		writeFile(t, fsys, "dir/b.txt", "bb")
+		writeFile(t, fsys, "dir/nested/c.txt", "ccc")
+		writeFile(t, fsys, "dir.txt", "")
+
+		require.NoError(t, fstest.TestFS(fsys, "a.txt", "a-b.txt", "dir/b.txt", "dir/nested/c.txt", "dir.txt", "empty"))
+	})
+}
@@ -13,15 +13,18 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/bucket-sailor/writablefs"
 	"github.com/bucket-sailor/writablefs/dirfs"
+	"github.com/bucket-sailor/writablefs/internal/s3mem"
+	"github.com/bucket-sailor/writablefs/memfs"
 	"github.com/bucket-sailor/writablefs/s3fs"
+	"github.com/bucket-sailor/writablefs/writablefstest"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/neilotoole/slogt"
 	"github.com/stretchr/testify/require"
-	tc "github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/wait"
 )
 
 func TestFilesystems(t *testing.T) {
@@ -29,13 +32,6 @@ func TestFilesystems(t *testing.T) {
 
 	ctx := context.Background()
 
-	s3Container, s3EndpointURL, err := startS3Server(ctx, logger)
-	require.NoError(t, err)
-
-	t.Cleanup(func() {
-		require.NoError(t, s3Container.Terminate(ctx))
-	})
-
 	t.Run("Directory", func(t *testing.T) {
 		storageDir := t.TempDir()
 
@@ -48,18 +44,79 @@ func TestFilesystems(t *testing.T) {
 
 		// Test the filesystem
 		testBasicOperations(t, fsys)
+		testOpenFlags(t, fsys)
+		testMkdirRemove(t, fsys)
+		testRename(t, fsys)
+		testCopy(t, fsys)
+		testSymlinks(t, fsys)
+		testPOSIXAttributes(t, fsys)
+		testContext(t, fsys)
 		testXAttrs(t, fsys)
+		testPathXAttrs(t, fsys)
+		testConcurrentXAttrs(t, fsys)
 		testArchive(t, fsys)
+		testFSTest(t, fsys)
+		testReadDirStream(t, fsys)
+		testWalk(t, fsys)
+		testParallelWalk(t, fsys)
+		testReadWriteFile(t, fsys)
+		testWriteFileExisting(t, fsys)
+		testGlob(t, fsys)
+		testTemp(t, fsys)
+		testDirXAttrOptions(t)
+		testDirCreationModes(t)
+		testDirNativeSymlinks(t)
+
+		writablefstest.TestFS(t, func() writablefs.FS {
+			fsys, err := dirfs.New(t.TempDir())
+			require.NoError(t, err)
+
+			return fsys
+		}, writablefstest.Capabilities{XAttrs: true, Archive: true, RenameDirs: true})
+
+		testPOSIXCompliance(t, "dirfs", func() writablefs.FS {
+			fsys, err := dirfs.New(t.TempDir())
+			require.NoError(t, err)
+
+			return fsys
+		})
 	})
 
-	t.Run("S3 - SeaweedFS", func(t *testing.T) {
-		opts := s3fs.Options{
-			EndpointURL: s3EndpointURL,
-			Credentials: credentials.NewStaticV4("admin", "admin", ""),
-			BucketName:  "test",
-		}
+	t.Run("Directory - Sidecar Extended Attributes", func(t *testing.T) {
+		storageDir := t.TempDir()
+
+		fsys, err := dirfs.NewWithOptions(storageDir, dirfs.Options{XAttrStorage: dirfs.XAttrStorageSidecar})
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, fsys.Close())
+		})
+
+		// Test the filesystem
+		testBasicOperations(t, fsys)
+		testMkdirRemove(t, fsys)
+		testRename(t, fsys)
+		testCopy(t, fsys)
+		testSymlinks(t, fsys)
+		testXAttrs(t, fsys)
+		testPathXAttrs(t, fsys)
+		testConcurrentXAttrs(t, fsys)
+		testFSTest(t, fsys)
+		testReadDirStream(t, fsys)
+		testWalk(t, fsys)
+		testTemp(t, fsys)
+		testDirXAttrSidecars(t, fsys, storageDir)
+
+		writablefstest.TestFS(t, func() writablefs.FS {
+			fsys, err := dirfs.NewWithOptions(t.TempDir(), dirfs.Options{XAttrStorage: dirfs.XAttrStorageSidecar})
+			require.NoError(t, err)
 
-		fsys, err := s3fs.New(ctx, logger, opts)
+			return fsys
+		}, writablefstest.Capabilities{XAttrs: true, Archive: true, RenameDirs: true})
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		fsys, err := memfs.New(memfs.Options{})
 		require.NoError(t, err)
 
 		t.Cleanup(func() {
@@ -68,58 +125,165 @@ func TestFilesystems(t *testing.T) {
 
 		// Test the filesystem
 		testBasicOperations(t, fsys)
+		testOpenFlags(t, fsys)
+		testMkdirRemove(t, fsys)
+		testRename(t, fsys)
+		testCopy(t, fsys)
+		testContext(t, fsys)
 		testXAttrs(t, fsys)
+		testPathXAttrs(t, fsys)
+		testConcurrentXAttrs(t, fsys)
 		testArchive(t, fsys)
+		testFSTest(t, fsys)
+		testReadDirStream(t, fsys)
+		testWalk(t, fsys)
+		testParallelWalk(t, fsys)
+		testReadWriteFile(t, fsys)
+		testWriteFileExisting(t, fsys)
+		testGlob(t, fsys)
+		testTemp(t, fsys)
+		testMemFS(t)
+
+		writablefstest.TestFS(t, func() writablefs.FS {
+			fsys, err := memfs.New(memfs.Options{})
+			require.NoError(t, err)
+
+			return fsys
+		}, writablefstest.Capabilities{XAttrs: true, Archive: true, RenameDirs: true})
+
+		testPOSIXCompliance(t, "memfs", func() writablefs.FS {
+			fsys, err := memfs.New(memfs.Options{})
+			require.NoError(t, err)
+
+			return fsys
+		})
+	})
+
+	t.Run("S3 - In Memory", func(t *testing.T) {
+		s3Server := httptest.NewServer(s3mem.New("test"))
+		t.Cleanup(s3Server.Close)
+
+		testS3(t, ctx, logger, s3Server.URL)
+		testS3XAttrUploads(t, ctx, logger)
 	})
 }
 
-func startS3Server(ctx context.Context, logger *slog.Logger) (tc.Container, string, error) {
-	req := tc.ContainerRequest{
-		Image:        "chrislusf/seaweedfs",
-		ExposedPorts: []string{"8333/tcp"},
-		Cmd:          []string{"server", "-s3", "-dir=/data"},
-		Mounts: tc.ContainerMounts{
-			{
-				Source: tc.GenericVolumeMountSource{
-					Name: "seaweedfs-data",
-				},
-				Target: "/data",
-			},
-		},
-		WaitingFor: wait.ForListeningPort("8333/tcp"),
+// testS3 runs the s3fs tests against the S3 server at endpointURL, which must
+// have a "test" bucket writable with the credentials admin/admin.
+func testS3(t *testing.T, ctx context.Context, logger *slog.Logger, endpointURL string) {
+	opts := s3fs.Options{
+		EndpointURL: endpointURL,
+		Credentials: credentials.NewStaticV4("admin", "admin", ""),
+		BucketName:  "test",
 	}
 
-	ctr, err := tc.GenericContainer(ctx, tc.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
+	fsys, err := s3fs.New(ctx, logger, opts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, fsys.Close())
 	})
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to start S3 server: %w", err)
-	}
 
-	logger.Info("Configuring S3 server")
+	// Another client of the same bucket (eg. on a different machine).
+	otherFsys, err := s3fs.New(ctx, logger, opts)
+	require.NoError(t, err)
 
-	weedCommands := `
-s3.bucket.create -name test
-s3.configure -access_key=admin -secret_key=admin -buckets=test -user=admin -actions=Read,Write,List,Tagging,Admin -apply
-`
+	t.Cleanup(func() {
+		require.NoError(t, otherFsys.Close())
+	})
 
-	ret, _, err := ctr.Exec(ctx, []string{"/bin/sh", "-c", "echo '" + weedCommands + "' | weed shell"})
-	if err != nil || ret != 0 {
-		return ctr, "", fmt.Errorf("failed to configure S3 server: %w", err)
-	}
+	// Lists a few keys at a time, so listings span many pages.
+	pagedOpts := opts
+	pagedOpts.ListPageSize = 4
 
-	dockerHost, err := ctr.Host(ctx)
-	if err != nil {
-		return ctr, "", fmt.Errorf("failed to get S3 server host: %w", err)
-	}
+	pagedFsys, err := s3fs.New(ctx, logger, pagedOpts)
+	require.NoError(t, err)
 
-	s3Port, err := ctr.MappedPort(ctx, "8333")
-	if err != nil {
-		return ctr, "", fmt.Errorf("failed to get S3 server port: %w", err)
-	}
+	t.Cleanup(func() {
+		require.NoError(t, pagedFsys.Close())
+	})
+
+	refreshOpts := opts
+	refreshOpts.RefreshOnSync = true
+
+	refreshingFsys, err := s3fs.New(ctx, logger, refreshOpts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, refreshingFsys.Close())
+	})
 
-	endpointURL := fmt.Sprintf("http://%s:%s", dockerHost, s3Port.Port())
+	symlinksAsFilesOpts := opts
+	symlinksAsFilesOpts.ListSymlinksAsFiles = true
 
-	return ctr, endpointURL, nil
+	symlinksAsFilesFsys, err := s3fs.New(ctx, logger, symlinksAsFilesOpts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, symlinksAsFilesFsys.Close())
+	})
+
+	statOnWriteOpts := opts
+	statOnWriteOpts.StatOnWriteFile = true
+
+	statOnWriteFsys, err := s3fs.New(ctx, logger, statOnWriteOpts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, statOnWriteFsys.Close())
+	})
+
+	// Test the filesystem
+	testBasicOperations(t, fsys)
+	testOpenFlags(t, fsys)
+	testMkdirRemove(t, fsys)
+	testRename(t, fsys)
+	testCopy(t, fsys)
+	testSymlinks(t, fsys)
+	testPOSIXAttributes(t, fsys)
+	testContext(t, fsys)
+	testConflicts(t, fsys, otherFsys)
+	testRefreshOnSync(t, refreshingFsys, otherFsys)
+	testXAttrs(t, fsys)
+	testPathXAttrs(t, fsys)
+	testConcurrentXAttrs(t, fsys)
+	testArchive(t, fsys)
+	testFSTest(t, fsys)
+	testReadDirStream(t, fsys)
+	testWalk(t, fsys)
+	testParallelWalk(t, fsys)
+	testReadWriteFile(t, fsys)
+	testWriteFileExisting(t, statOnWriteFsys)
+	testGlob(t, fsys)
+	testTemp(t, fsys)
+	testS3Walk(t, fsys)
+	testS3Rename(t, fsys)
+	testS3ListSymlinksAsFiles(t, symlinksAsFilesFsys)
+	t.Run("Small Listing Pages", func(t *testing.T) {
+		testReadDirStream(t, pagedFsys)
+		testWalk(t, pagedFsys)
+		testS3Walk(t, pagedFsys)
+	})
+	testS3PathXAttrs(t, fsys)
+	testS3XAttrStorage(t, fsys, endpointURL)
+
+	// Each group of checks gets its own (empty) directory of the bucket.
+	var suiteCount int
+	writablefstest.TestFS(t, func() writablefs.FS {
+		suiteCount++
+		testDir := fmt.Sprintf("%s/%d", t.Name(), suiteCount)
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		return writablefs.Sub(fsys, testDir)
+	}, writablefstest.Capabilities{XAttrs: true, RenameDirs: true}) // Sub doesn't implement ArchiveFS.
+
+	testPOSIXCompliance(t, "s3fs", func() writablefs.FS {
+		suiteCount++
+		testDir := fmt.Sprintf("%s/%d", t.Name(), suiteCount)
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		return writablefs.Sub(fsys, testDir)
+	})
 }
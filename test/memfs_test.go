@@ -0,0 +1,136 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/memfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMemFS tests the options and behavior specific to memfs.
+func testMemFS(t *testing.T) {
+	t.Run("In-Memory Options", func(t *testing.T) {
+		t.Run("Max Size", func(t *testing.T) {
+			fsys, err := memfs.New(memfs.Options{MaxSize: 10})
+			require.NoError(t, err)
+
+			writeFile(t, fsys, "a.txt", "hello")
+
+			f, err := fsys.OpenFile("b.txt", writablefs.FlagCreate|writablefs.FlagWriteOnly)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("world!"))
+			assert.ErrorIs(t, err, memfs.ErrNoSpace)
+
+			assert.ErrorIs(t, f.Truncate(6), memfs.ErrNoSpace)
+
+			// Removing a file should free up its space.
+			require.NoError(t, fsys.RemoveAll("a.txt"))
+
+			_, err = f.Write([]byte("world!"))
+			require.NoError(t, err)
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "world!", readFile(t, fsys, "b.txt"))
+		})
+
+		t.Run("Max File Size", func(t *testing.T) {
+			fsys, err := memfs.New(memfs.Options{MaxFileSize: 5})
+			require.NoError(t, err)
+
+			writeFile(t, fsys, "a.txt", "hello")
+
+			f, err := fsys.OpenFile("a.txt", writablefs.FlagWriteOnly|writablefs.FlagAppend)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("!"))
+			assert.ErrorIs(t, err, memfs.ErrNoSpace)
+
+			// Shrinking is always allowed.
+			require.NoError(t, f.Truncate(4))
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "hell", readFile(t, fsys, "a.txt"))
+		})
+
+		t.Run("Clock", func(t *testing.T) {
+			now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+			fsys, err := memfs.New(memfs.Options{
+				Clock: func() time.Time { return now },
+			})
+			require.NoError(t, err)
+
+			writeFile(t, fsys, "a.txt", "hello")
+
+			fi, err := fsys.Stat("a.txt")
+			require.NoError(t, err)
+			assert.True(t, now.Equal(fi.ModTime()))
+
+			now = now.Add(time.Hour)
+
+			writeFile(t, fsys, "a.txt", "world")
+
+			fi, err = fsys.Stat("a.txt")
+			require.NoError(t, err)
+			assert.True(t, now.Equal(fi.ModTime()))
+
+			fi, err = fsys.Stat(".")
+			require.NoError(t, err)
+			assert.True(t, now.Add(-time.Hour).Equal(fi.ModTime()))
+		})
+
+		t.Run("Shared Handles", func(t *testing.T) {
+			fsys, err := memfs.New(memfs.Options{})
+			require.NoError(t, err)
+
+			writer, err := fsys.OpenFile("a.txt", writablefs.FlagCreate|writablefs.FlagWriteOnly)
+			require.NoError(t, err)
+
+			reader, err := fsys.OpenFile("a.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+
+			// Writes are visible to other handles straight away.
+			_, err = writer.Write([]byte("hello"))
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+
+			// Open handles can still be used after the file is removed.
+			require.NoError(t, fsys.RemoveAll("a.txt"))
+
+			_, err = writer.Write([]byte(" world"))
+			require.NoError(t, err)
+
+			data, err = io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, " world", string(data))
+
+			require.NoError(t, writer.Close())
+			require.NoError(t, reader.Close())
+
+			_, err = fsys.Stat("a.txt")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			_, err = reader.Read(make([]byte, 1))
+			assert.ErrorIs(t, err, writablefs.ErrClosed)
+		})
+	})
+}
@@ -0,0 +1,105 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMkdirRemove(t *testing.T, fsys writablefs.FS) {
+	t.Run("Mkdir and Remove", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		// Sub should forward the non-recursive operations.
+		mkdirFsys, ok := fsys.(writablefs.MkdirFS)
+		require.True(t, ok)
+
+		removeFsys, ok := fsys.(writablefs.RemoveFS)
+		require.True(t, ok)
+
+		t.Run("Mkdir", func(t *testing.T) {
+			require.NoError(t, mkdirFsys.Mkdir("dir"))
+
+			fi, err := fsys.Stat("dir")
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+
+			assert.ErrorIs(t, mkdirFsys.Mkdir("dir"), writablefs.ErrExist)
+		})
+
+		t.Run("Mkdir - Missing Parent", func(t *testing.T) {
+			assert.ErrorIs(t, mkdirFsys.Mkdir("missing/dir"), writablefs.ErrNotExist)
+
+			_, err := fsys.Stat("missing")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Mkdir - Existing File", func(t *testing.T) {
+			writeFile(t, fsys, "file.txt", "hello")
+
+			assert.ErrorIs(t, mkdirFsys.Mkdir("file.txt"), writablefs.ErrExist)
+		})
+
+		t.Run("Mkdir - Concurrent", func(t *testing.T) {
+			const numCallers = 8
+
+			var wg sync.WaitGroup
+			var created atomic.Int32
+
+			for i := 0; i < numCallers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					err := mkdirFsys.Mkdir("lock")
+					if err == nil {
+						created.Add(1)
+					} else {
+						assert.ErrorIs(t, err, writablefs.ErrExist)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			assert.Equal(t, int32(1), created.Load())
+		})
+
+		t.Run("Remove - Non-Empty", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("full"))
+			writeFile(t, fsys, "full/file.txt", "hello")
+
+			assert.ErrorIs(t, removeFsys.Remove("full"), writablefs.ErrNotEmpty)
+			assert.Equal(t, "hello", readFile(t, fsys, "full/file.txt"))
+		})
+
+		t.Run("Remove", func(t *testing.T) {
+			require.NoError(t, removeFsys.Remove("full/file.txt"))
+			require.NoError(t, removeFsys.Remove("full"))
+
+			_, err := fsys.Stat("full")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Remove - Non-Existent", func(t *testing.T) {
+			assert.ErrorIs(t, removeFsys.Remove("missing"), writablefs.ErrNotExist)
+		})
+	})
+}
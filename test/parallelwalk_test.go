@@ -0,0 +1,254 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	gopath "path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testParallelWalk(t *testing.T, fsys writablefs.FS) {
+	t.Run("Parallel Walk", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		for i := 0; i < 4; i++ {
+			for j := 0; j < 3; j++ {
+				dir := fmt.Sprintf("dir%d/sub%d", i, j)
+				require.NoError(t, fsys.MkdirAll(dir))
+
+				writeFile(t, fsys, dir+"/a.txt", "a")
+				writeFile(t, fsys, dir+"/b.txt", "b")
+			}
+		}
+
+		var expected []string
+		require.NoError(t, fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			expected = append(expected, path)
+			return err
+		}))
+
+		ctx := context.Background()
+
+		t.Run("Visits Everything", func(t *testing.T) {
+			var mu sync.Mutex
+			visited := make(map[string]bool)
+			var walkErrs []error
+
+			err := writablefs.ParallelWalk(fsys, ".", 4, func(path string, d fs.DirEntry, err error) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				// fn is called from the workers, so errors are checked afterwards.
+				if err != nil {
+					walkErrs = append(walkErrs, err)
+				}
+
+				assert.False(t, visited[path], "visited twice: %s", path)
+				visited[path] = true
+
+				// Directories are always visited before their contents.
+				if path != "." {
+					assert.True(t, visited[gopath.Dir(path)], "visited before parent: %s", path)
+				}
+
+				return nil
+			})
+			require.NoError(t, err)
+			require.NoError(t, errors.Join(walkErrs...))
+
+			assert.Equal(t, expected, sortedKeys(visited))
+		})
+
+		t.Run("Bounded Concurrency", func(t *testing.T) {
+			countingFsys := &concurrentReadDirFS{FS: fsys}
+
+			err := writablefs.ParallelWalk(countingFsys, ".", 2, func(path string, d fs.DirEntry, err error) error {
+				return err
+			})
+			require.NoError(t, err)
+
+			// At most (and with this many directories, exactly) workers at once.
+			assert.Equal(t, int32(2), countingFsys.maxActive.Load())
+		})
+
+		t.Run("Skip Directory", func(t *testing.T) {
+			paths := parallelWalkPaths(t, fsys, func(path string) error {
+				if path == "dir1" || path == "dir2/sub0/a.txt" {
+					return fs.SkipDir
+				}
+
+				return nil
+			})
+
+			assert.Contains(t, paths, "dir1")
+			assert.NotContains(t, paths, "dir1/sub0")
+			assert.Contains(t, paths, "dir2/sub0/a.txt")
+			assert.NotContains(t, paths, "dir2/sub0/b.txt")
+			assert.Contains(t, paths, "dir2/sub1/b.txt")
+		})
+
+		t.Run("Skip All", func(t *testing.T) {
+			paths := parallelWalkPaths(t, fsys, func(path string) error {
+				if path == "dir0" {
+					return fs.SkipAll
+				}
+
+				return nil
+			})
+
+			assert.NotContains(t, paths, "dir0/sub0")
+		})
+
+		t.Run("Stop On First Error", func(t *testing.T) {
+			errBoom := errors.New("boom")
+
+			err := writablefs.ParallelWalk(fsys, ".", 4, func(path string, d fs.DirEntry, err error) error {
+				if path == "dir2" {
+					return errBoom
+				}
+
+				return err
+			})
+			assert.ErrorIs(t, err, errBoom)
+		})
+
+		t.Run("Collect All Errors", func(t *testing.T) {
+			errA := errors.New("a")
+			errB := errors.New("b")
+
+			var mu sync.Mutex
+			var paths []string
+
+			err := writablefs.ParallelWalkContext(ctx, fsys, ".", 4, writablefs.CollectAllErrors, func(path string, d fs.DirEntry, err error) error {
+				mu.Lock()
+				paths = append(paths, path)
+				mu.Unlock()
+
+				switch path {
+				case "dir0/sub1/a.txt":
+					return errA
+				case "dir3":
+					return errB
+				}
+
+				return err
+			})
+			assert.ErrorIs(t, err, errA)
+			assert.ErrorIs(t, err, errB)
+
+			// The rest of the tree is still walked (except for the failed directory).
+			assert.Contains(t, paths, "dir0/sub1/b.txt")
+			assert.Contains(t, paths, "dir2/sub2/b.txt")
+			assert.NotContains(t, paths, "dir3/sub0")
+		})
+
+		t.Run("Cancellation", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+
+			var once sync.Once
+			err := writablefs.ParallelWalkContext(ctx, fsys, ".", 2, writablefs.CollectAllErrors, func(path string, d fs.DirEntry, err error) error {
+				if path == "dir0" {
+					once.Do(cancel)
+				}
+
+				return err
+			})
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+
+		t.Run("Not Exist", func(t *testing.T) {
+			err := writablefs.ParallelWalk(fsys, "missing", 4, func(path string, d fs.DirEntry, err error) error {
+				assert.Equal(t, "missing", path)
+				assert.Nil(t, d)
+
+				return err
+			})
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+	})
+}
+
+// parallelWalkPaths walks the file system in parallel and returns the visited
+// paths (sorted), fn decides whether to skip part of the tree.
+func parallelWalkPaths(t *testing.T, fsys writablefs.FS, fn func(path string) error) []string {
+	var mu sync.Mutex
+	visited := make(map[string]bool)
+	var walkErrs []error
+
+	err := writablefs.ParallelWalk(fsys, ".", 4, func(path string, d fs.DirEntry, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// fn is called from the workers, so errors are checked afterwards.
+		if err != nil {
+			walkErrs = append(walkErrs, err)
+			return nil
+		}
+
+		visited[path] = true
+
+		return fn(path)
+	})
+	require.NoError(t, err)
+	require.NoError(t, errors.Join(walkErrs...))
+
+	return sortedKeys(visited)
+}
+
+// concurrentReadDirFS records the most ReadDir calls in progress at once.
+type concurrentReadDirFS struct {
+	writablefs.FS
+	active    atomic.Int32
+	maxActive atomic.Int32
+}
+
+func (fsys *concurrentReadDirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	active := fsys.active.Add(1)
+	defer fsys.active.Add(-1)
+
+	for {
+		maxActive := fsys.maxActive.Load()
+		if active <= maxActive || fsys.maxActive.CompareAndSwap(maxActive, active) {
+			break
+		}
+	}
+
+	// Give other calls a chance to overlap with this one.
+	time.Sleep(10 * time.Millisecond)
+
+	return fsys.FS.ReadDir(name)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,184 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/dirfs"
+	"github.com/bucket-sailor/writablefs/s3fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPOSIXAttributes(t *testing.T, fsys writablefs.FS) {
+	t.Run("POSIX Attributes", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		parentFsys := fsys
+		fsys := writablefs.Sub(fsys, testDir)
+
+		require.NoError(t, fsys.MkdirAll("dir"))
+		writeFile(t, fsys, "file.txt", "hello")
+
+		t.Run("Chmod", func(t *testing.T) {
+			require.NoError(t, writablefs.Chmod(fsys, "file.txt", 0o600))
+			require.NoError(t, writablefs.Chmod(fsys, "dir", 0o700))
+
+			fi, err := fsys.Stat("file.txt")
+			require.NoError(t, err)
+
+			assert.True(t, fi.Mode().IsRegular())
+			assert.Equal(t, writablefs.FileMode(0o600), fi.Mode().Perm())
+
+			fi, err = fsys.Stat("dir")
+			require.NoError(t, err)
+
+			assert.True(t, fi.IsDir())
+			assert.Equal(t, writablefs.FileMode(0o700), fi.Mode().Perm())
+
+			// The mode should be preserved when the contents change.
+			writeFile(t, fsys, "file.txt", "world")
+
+			fi, err = fsys.Stat("file.txt")
+			require.NoError(t, err)
+
+			assert.Equal(t, writablefs.FileMode(0o600), fi.Mode().Perm())
+			assert.Equal(t, "world", readFile(t, fsys, "file.txt"))
+		})
+
+		t.Run("Chown", func(t *testing.T) {
+			uid, gid := os.Getuid(), os.Getgid()
+
+			require.NoError(t, writablefs.Chown(fsys, "file.txt", uid, gid))
+			require.NoError(t, writablefs.Chown(fsys, "dir", uid, gid))
+
+			fi, err := fsys.Stat("file.txt")
+			require.NoError(t, err)
+
+			fileUID, fileGID := owner(t, fi)
+			assert.Equal(t, uid, fileUID)
+			assert.Equal(t, gid, fileGID)
+		})
+
+		t.Run("Chtimes", func(t *testing.T) {
+			mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+			require.NoError(t, writablefs.Chtimes(fsys, "file.txt", time.Time{}, mtime))
+
+			fi, err := fsys.Stat("file.txt")
+			require.NoError(t, err)
+
+			assert.True(t, mtime.Equal(fi.ModTime()))
+		})
+
+		t.Run("Non-Existent", func(t *testing.T) {
+			assert.ErrorIs(t, writablefs.Chmod(fsys, "missing", 0o600), writablefs.ErrNotExist)
+		})
+
+		t.Run("Archive", func(t *testing.T) {
+			archiveFS, ok := parentFsys.(writablefs.ArchiveFS)
+			if !ok {
+				t.Skip("archive not supported by filesystem")
+			}
+
+			r, err := archiveFS.Archive(testDir)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, r.Close())
+			})
+
+			var found, foundDir bool
+
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				if hdr.Name == "file.txt" {
+					found = true
+
+					assert.Equal(t, int64(0o600), hdr.Mode)
+					assert.Equal(t, os.Getuid(), hdr.Uid)
+					assert.True(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Equal(hdr.ModTime))
+				}
+
+				if strings.TrimSuffix(hdr.Name, "/") == "dir" {
+					foundDir = true
+
+					assert.Equal(t, byte(tar.TypeDir), hdr.Typeflag)
+					assert.Equal(t, int64(0o700), hdr.Mode)
+					assert.Equal(t, os.Getuid(), hdr.Uid)
+				}
+			}
+
+			assert.True(t, found)
+			assert.True(t, foundDir)
+		})
+	})
+}
+
+// testDirCreationModes checks the permission bits of the files and
+// directories dirfs creates can be chosen.
+func testDirCreationModes(t *testing.T) {
+	t.Run("Creation Modes", func(t *testing.T) {
+		fsys, err := dirfs.NewWithOptions(t.TempDir(), dirfs.Options{FileMode: 0o600, DirMode: 0o700})
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, fsys.Close())
+		})
+
+		require.NoError(t, fsys.MkdirAll("dir/nested"))
+		require.NoError(t, writablefs.Mkdir(fsys, "single"))
+		writeFile(t, fsys, "dir/opened.txt", "opened")
+		require.NoError(t, writablefs.WriteFile(fsys, "written.txt", []byte("written")))
+
+		// The umask is usually 022, so doesn't affect these modes.
+		for name, mode := range map[string]writablefs.FileMode{
+			"dir":            0o700,
+			"dir/nested":     0o700,
+			"single":         0o700,
+			"dir/opened.txt": 0o600,
+			"written.txt":    0o600,
+		} {
+			fi, err := fsys.Stat(name)
+			require.NoError(t, err)
+			assert.Equal(t, mode, fi.Mode().Perm(), name)
+		}
+
+		_, err = dirfs.NewWithOptions(t.TempDir(), dirfs.Options{FileMode: writablefs.ModeDir | 0o755})
+		assert.ErrorIs(t, err, writablefs.ErrInvalid)
+	})
+}
+
+func owner(t *testing.T, fi writablefs.FileInfo) (int, int) {
+	switch stat := fi.Sys().(type) {
+	case *syscall.Stat_t:
+		return int(stat.Uid), int(stat.Gid)
+	case *s3fs.Stat:
+		return stat.UID, stat.GID
+	default:
+		t.Fatalf("unexpected file info type %T", stat)
+		return -1, -1
+	}
+}
@@ -0,0 +1,175 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReadWriteFile(t *testing.T, fsys writablefs.FS) {
+	t.Run("ReadFile and WriteFile", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Round Trip", func(t *testing.T) {
+			require.NoError(t, writablefs.WriteFile(fsys, "a.txt", []byte("hello")))
+
+			data, err := writablefs.ReadFile(fsys, "a.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+
+			// Existing contents are replaced.
+			require.NoError(t, writablefs.WriteFile(fsys, "a.txt", []byte("bye")))
+
+			data, err = writablefs.ReadFile(fsys, "a.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "bye", string(data))
+
+			assert.Equal(t, "bye", readFile(t, fsys, "a.txt"))
+
+			require.NoError(t, writablefs.WriteFile(fsys, "empty.txt", nil))
+
+			data, err = writablefs.ReadFile(fsys, "empty.txt")
+			require.NoError(t, err)
+			assert.Empty(t, data)
+		})
+
+		t.Run("Open Files", func(t *testing.T) {
+			writeFile(t, fsys, "open.txt", "hello")
+
+			f, err := fsys.OpenFile("open.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("HELLO"))
+			require.NoError(t, err)
+
+			// Pending writes are visible.
+			data, err := writablefs.ReadFile(fsys, "open.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "HELLO", string(data))
+
+			require.NoError(t, writablefs.WriteFile(fsys, "open.txt", []byte("world")))
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "world", readFile(t, fsys, "open.txt"))
+		})
+
+		t.Run("Errors", func(t *testing.T) {
+			_, err := writablefs.ReadFile(fsys, "missing.txt")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			require.NoError(t, fsys.MkdirAll("dir"))
+
+			_, err = writablefs.ReadFile(fsys, "dir")
+			assert.Error(t, err)
+
+			_, err = writablefs.ReadFile(fsys, "../escape.txt")
+			assert.Error(t, err)
+		})
+	})
+}
+
+// testWriteFileExisting checks that WriteFile treats existing files like
+// os.WriteFile does (s3fs only does so with Options.StatOnWriteFile).
+func testWriteFileExisting(t *testing.T, fsys writablefs.FS) {
+	t.Run("WriteFile Existing Files", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Attributes and Symbolic Links", func(t *testing.T) {
+			writeFile(t, fsys, "attrs.txt", "hello")
+			require.NoError(t, writablefs.SetXAttr(fsys, "attrs.txt", "test-attr", []byte("test-value")))
+
+			// The attributes of the file are kept.
+			require.NoError(t, writablefs.WriteFile(fsys, "attrs.txt", []byte("bye")))
+
+			assert.Equal(t, "bye", readFile(t, fsys, "attrs.txt"))
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "attrs.txt", "test-attr"))
+
+			if _, ok := fsys.(writablefs.SymlinkFS); !ok {
+				return
+			}
+
+			// Symbolic links are followed.
+			require.NoError(t, writablefs.Symlink(fsys, "attrs.txt", "link.txt"))
+			require.NoError(t, writablefs.WriteFile(fsys, "link.txt", []byte("linked")))
+
+			assert.Equal(t, "linked", readFile(t, fsys, "attrs.txt"))
+
+			fi, err := writablefs.Lstat(fsys, "link.txt")
+			require.NoError(t, err)
+			assert.Equal(t, writablefs.ModeSymlink, fi.Mode().Type())
+		})
+
+		t.Run("Directories", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("parent/dir"))
+
+			// Directories aren't replaced.
+			assert.ErrorIs(t, writablefs.WriteFile(fsys, "parent/dir", []byte("file")), syscall.EISDIR)
+
+			entries, err := fsys.ReadDir("parent")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"dir"}, fileNames(entries))
+		})
+	})
+}
+
+func testGlob(t *testing.T, fsys writablefs.FS) {
+	t.Run("Glob", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		require.NoError(t, fsys.MkdirAll("data/2024"))
+		require.NoError(t, fsys.MkdirAll("data/2025"))
+		require.NoError(t, fsys.MkdirAll("logs"))
+
+		for _, name := range []string{
+			"data-1.csv", "data-2.csv", "data.txt", "readme.md",
+			"data/2024/a.csv", "data/2024/b.json", "data/2025/c.csv", "logs/app.log",
+		} {
+			require.NoError(t, writablefs.WriteFile(fsys, name, []byte(path.Base(name))))
+		}
+
+		for pattern, expected := range map[string][]string{
+			"data-*.csv":    {"data-1.csv", "data-2.csv"},
+			"data*":         {"data", "data-1.csv", "data-2.csv", "data.txt"},
+			"*.md":          {"readme.md"},
+			"data/*/*.csv":  {"data/2024/a.csv", "data/2025/c.csv"},
+			"data/202[4]/*": {"data/2024/a.csv", "data/2024/b.json"},
+			"*/app.log":     {"logs/app.log"},
+			"readme.md":     {"readme.md"},
+			"missing*":      nil,
+			"missing/*":     nil,
+		} {
+			matches, err := writablefs.Glob(fsys, pattern)
+			require.NoError(t, err, pattern)
+			assert.Equal(t, expected, matches, pattern)
+		}
+
+		_, err := writablefs.Glob(fsys, "data[")
+		assert.ErrorIs(t, err, path.ErrBadPattern)
+	})
+}
@@ -0,0 +1,163 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRename(t *testing.T, fsys writablefs.FS) {
+	t.Run("Rename", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("File", func(t *testing.T) {
+			writeFile(t, fsys, "old.txt", "hello")
+
+			require.NoError(t, fsys.Rename("old.txt", "new.txt"))
+
+			_, err := fsys.Stat("old.txt")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			assert.Equal(t, "hello", readFile(t, fsys, "new.txt"))
+		})
+
+		t.Run("Directory", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("src/nested/empty"))
+
+			files := map[string]string{
+				"src/a.txt":            "a",
+				"src/b.txt":            "b",
+				"src/nested/c.txt":     "c",
+				"src/nested/deep/d.md": "d",
+			}
+
+			for i := 0; i < 50; i++ {
+				files[fmt.Sprintf("src/many/%d.txt", i)] = fmt.Sprintf("%d", i)
+			}
+
+			for name, contents := range files {
+				require.NoError(t, fsys.MkdirAll(path.Dir(name)))
+				writeFile(t, fsys, name, contents)
+			}
+
+			// Extended attributes should be carried along.
+			f, err := fsys.OpenFile("src/a.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+			require.NoError(t, xattrs.Sync())
+			require.NoError(t, f.Close())
+
+			require.NoError(t, fsys.Rename("src", "dst"))
+
+			_, err = fsys.Stat("src")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			for name, contents := range files {
+				assert.Equal(t, contents, readFile(t, fsys, "dst"+strings.TrimPrefix(name, "src")))
+			}
+
+			fi, err := fsys.Stat("dst/nested/empty")
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+
+			f, err = fsys.OpenFile("dst/a.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+
+			xattrs, err = f.XAttrs()
+			require.NoError(t, err)
+
+			value, err := xattrs.Get("test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+
+			require.NoError(t, f.Close())
+		})
+
+		t.Run("Directory - Non-Empty Destination", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("from"))
+			writeFile(t, fsys, "from/a.txt", "a")
+
+			require.NoError(t, fsys.MkdirAll("to"))
+			writeFile(t, fsys, "to/b.txt", "b")
+
+			assert.Error(t, fsys.Rename("from", "to"))
+
+			assert.Equal(t, "a", readFile(t, fsys, "from/a.txt"))
+			assert.Equal(t, "b", readFile(t, fsys, "to/b.txt"))
+		})
+
+		t.Run("Non-Existent", func(t *testing.T) {
+			assert.ErrorIs(t, fsys.Rename("missing", "other"), writablefs.ErrNotExist)
+		})
+	})
+}
+
+// testS3Rename tests renames that would replace an entry of the other type, or
+// that rename an entry to itself, which S3 doesn't prevent by itself.
+func testS3Rename(t *testing.T, fsys writablefs.FS) {
+	t.Run("Rename Existing Destination", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Same Path", func(t *testing.T) {
+			writeFile(t, fsys, "same.txt", "same")
+			require.NoError(t, fsys.Rename("same.txt", "same.txt"))
+			assert.Equal(t, "same", readFile(t, fsys, "same.txt"))
+
+			require.NoError(t, fsys.MkdirAll("samedir"))
+			writeFile(t, fsys, "samedir/a.txt", "a")
+			require.NoError(t, fsys.Rename("samedir", "samedir"))
+			assert.Equal(t, "a", readFile(t, fsys, "samedir/a.txt"))
+		})
+
+		t.Run("Directory onto File", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("dironto"))
+			writeFile(t, fsys, "dironto/a.txt", "a")
+			writeFile(t, fsys, "ontofile.txt", "file")
+
+			assert.ErrorIs(t, fsys.Rename("dironto", "ontofile.txt"), syscall.ENOTDIR)
+
+			assert.Equal(t, "a", readFile(t, fsys, "dironto/a.txt"))
+			assert.Equal(t, "file", readFile(t, fsys, "ontofile.txt"))
+		})
+
+		t.Run("File onto Directory", func(t *testing.T) {
+			writeFile(t, fsys, "fileonto.txt", "file")
+			require.NoError(t, fsys.MkdirAll("ontodir"))
+
+			assert.ErrorIs(t, fsys.Rename("fileonto.txt", "ontodir"), syscall.EISDIR)
+
+			assert.Equal(t, "file", readFile(t, fsys, "fileonto.txt"))
+
+			fi, err := fsys.Stat("ontodir")
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+		})
+	})
+}
@@ -0,0 +1,94 @@
+//go:build seaweedfs
+
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+	tc "github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// TestSeaweedFS runs the s3fs tests against a real S3 implementation, it
+// requires Docker so is only built with the seaweedfs tag.
+func TestSeaweedFS(t *testing.T) {
+	logger := slogt.New(t)
+
+	ctx := context.Background()
+
+	s3Container, s3EndpointURL, err := startS3Server(ctx, logger)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, s3Container.Terminate(ctx))
+	})
+
+	t.Run("S3 - SeaweedFS", func(t *testing.T) {
+		testS3(t, ctx, logger, s3EndpointURL)
+	})
+}
+
+func startS3Server(ctx context.Context, logger *slog.Logger) (tc.Container, string, error) {
+	req := tc.ContainerRequest{
+		Image:        "chrislusf/seaweedfs",
+		ExposedPorts: []string{"8333/tcp"},
+		Cmd:          []string{"server", "-s3", "-dir=/data"},
+		Mounts: tc.ContainerMounts{
+			{
+				Source: tc.GenericVolumeMountSource{
+					Name: "seaweedfs-data",
+				},
+				Target: "/data",
+			},
+		},
+		WaitingFor: wait.ForListeningPort("8333/tcp"),
+	}
+
+	ctr, err := tc.GenericContainer(ctx, tc.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start S3 server: %w", err)
+	}
+
+	logger.Info("Configuring S3 server")
+
+	weedCommands := `
+s3.bucket.create -name test
+s3.configure -access_key=admin -secret_key=admin -buckets=test -user=admin -actions=Read,Write,List,Tagging,Admin -apply
+`
+
+	ret, _, err := ctr.Exec(ctx, []string{"/bin/sh", "-c", "echo '" + weedCommands + "' | weed shell"})
+	if err != nil || ret != 0 {
+		return ctr, "", fmt.Errorf("failed to configure S3 server: %w", err)
+	}
+
+	dockerHost, err := ctr.Host(ctx)
+	if err != nil {
+		return ctr, "", fmt.Errorf("failed to get S3 server host: %w", err)
+	}
+
+	s3Port, err := ctr.MappedPort(ctx, "8333")
+	if err != nil {
+		return ctr, "", fmt.Errorf("failed to get S3 server port: %w", err)
+	}
+
+	endpointURL := fmt.Sprintf("http://%s:%s", dockerHost, s3Port.Port())
+
+	return ctr, endpointURL, nil
+}
@@ -0,0 +1,210 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/dirfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDirXAttrSidecars checks how extended attributes are stored in sidecar
+// files, by inspecting the directory the file system is rooted at.
+func testDirXAttrSidecars(t *testing.T, fsys writablefs.FS, storageDir string) {
+	t.Run("Extended Attribute Sidecars", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		// The sidecar of a file in the test directory.
+		sidecarPath := func(name string) string {
+			path := []string{storageDir, ".writablefs", "xattrs"}
+			for _, elem := range strings.Split(testDir, "/") {
+				path = append(path, "d."+elem)
+			}
+
+			return filepath.Join(append(path, "f."+name)...)
+		}
+
+		parentFsys := fsys
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Stored in Sidecar", func(t *testing.T) {
+			writeFile(t, fsys, "file.txt", "just a test")
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "test-attr", []byte("test-value")))
+
+			assert.FileExists(t, sidecarPath("file.txt"))
+
+			// Removing the last attribute removes the sidecar.
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "file.txt", "test-attr"))
+			assert.NoFileExists(t, sidecarPath("file.txt"))
+		})
+
+		t.Run("Hidden", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("hidden"))
+			writeFile(t, fsys, "hidden/a.txt", "a")
+			writeFile(t, fsys, "hidden/b.txt", "b")
+
+			require.NoError(t, writablefs.SetXAttr(fsys, "hidden", "test-attr", []byte("test-value")))
+			require.NoError(t, writablefs.SetXAttr(fsys, "hidden/a.txt", "test-attr", []byte("test-value")))
+			require.NoError(t, writablefs.SetXAttr(fsys, "hidden/b.txt", "test-attr", []byte("test-value")))
+
+			entries, err := fsys.ReadDir("hidden")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a.txt", "b.txt"}, fileNames(entries))
+
+			stream, err := writablefs.ReadDirStream(context.Background(), fsys, "hidden", "")
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, stream.Close())
+			})
+
+			var names []string
+			for {
+				entries, err := stream.Next(1)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				names = append(names, fileNames(entries)...)
+			}
+			assert.ElementsMatch(t, []string{"a.txt", "b.txt"}, names)
+		})
+
+		t.Run("Rename", func(t *testing.T) {
+			writeFile(t, fsys, "old.txt", "old")
+			require.NoError(t, writablefs.SetXAttr(fsys, "old.txt", "test-attr", []byte("test-value")))
+
+			require.NoError(t, fsys.Rename("old.txt", "new.txt"))
+
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "new.txt", "test-attr"))
+			assert.NoFileExists(t, sidecarPath("old.txt"))
+
+			// Replacing a file replaces its attributes.
+			writeFile(t, fsys, "plain.txt", "plain")
+			require.NoError(t, fsys.Rename("plain.txt", "new.txt"))
+
+			names, err := writablefs.ListXAttrs(fsys, "new.txt")
+			require.NoError(t, err)
+			assert.Empty(t, names)
+
+			// Directories take the attributes of their contents with them.
+			require.NoError(t, fsys.MkdirAll("olddir"))
+			writeFile(t, fsys, "olddir/a.txt", "a")
+			require.NoError(t, writablefs.SetXAttr(fsys, "olddir", "dir-attr", []byte("dir-value")))
+			require.NoError(t, writablefs.SetXAttr(fsys, "olddir/a.txt", "test-attr", []byte("test-value")))
+
+			require.NoError(t, fsys.Rename("olddir", "newdir"))
+
+			value, err := writablefs.GetXAttr(fsys, "newdir", "dir-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("dir-value"), value)
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "newdir/a.txt", "test-attr"))
+		})
+
+		t.Run("Copy", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("src"))
+			writeFile(t, fsys, "src/a.txt", "a")
+			require.NoError(t, writablefs.SetXAttr(fsys, "src", "dir-attr", []byte("dir-value")))
+			require.NoError(t, writablefs.SetXAttr(fsys, "src/a.txt", "test-attr", []byte("test-value")))
+
+			require.NoError(t, writablefs.CopyAll(fsys, "src", fsys, "dst"))
+
+			value, err := writablefs.GetXAttr(fsys, "dst", "dir-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("dir-value"), value)
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "dst/a.txt", "test-attr"))
+
+			entries, err := fsys.ReadDir("dst")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a.txt"}, fileNames(entries))
+		})
+
+		t.Run("Remove", func(t *testing.T) {
+			writeFile(t, fsys, "removed.txt", "removed")
+			require.NoError(t, writablefs.SetXAttr(fsys, "removed.txt", "test-attr", []byte("test-value")))
+
+			require.NoError(t, writablefs.Remove(fsys, "removed.txt"))
+			assert.NoFileExists(t, sidecarPath("removed.txt"))
+
+			// A new file with the same name has no attributes.
+			writeFile(t, fsys, "removed.txt", "removed")
+
+			names, err := writablefs.ListXAttrs(fsys, "removed.txt")
+			require.NoError(t, err)
+			assert.Empty(t, names)
+
+			require.NoError(t, fsys.MkdirAll("removeddir"))
+			require.NoError(t, writablefs.SetXAttr(fsys, "removeddir", "dir-attr", []byte("dir-value")))
+
+			require.NoError(t, fsys.RemoveAll("removeddir"))
+			assert.NoFileExists(t, sidecarPath("removeddir"))
+		})
+
+		t.Run("Reserved", func(t *testing.T) {
+			// The data used internally by dirfs can't be accessed.
+			_, err := parentFsys.Stat(".writablefs")
+			require.ErrorIs(t, err, writablefs.ErrPermission)
+
+			err = writablefs.WriteFile(parentFsys, ".writablefs/xattrs/f.forged.txt", []byte(`{"user.test-attr":"Zm9yZ2Vk"}`))
+			require.ErrorIs(t, err, writablefs.ErrPermission)
+
+			require.NoError(t, writablefs.Symlink(parentFsys, "/.writablefs", "sneaky"))
+			t.Cleanup(func() {
+				require.NoError(t, parentFsys.RemoveAll("sneaky"))
+			})
+
+			_, err = parentFsys.ReadDir("sneaky")
+			require.ErrorIs(t, err, writablefs.ErrPermission)
+
+			entries, err := parentFsys.ReadDir(".")
+			require.NoError(t, err)
+			assert.NotContains(t, fileNames(entries), ".writablefs")
+		})
+
+		t.Run("Ordinary Files", func(t *testing.T) {
+			require.NoError(t, fsys.MkdirAll("notes"))
+			writeFile(t, fsys, "notes/a.txt", "a")
+			require.NoError(t, writablefs.SetXAttr(fsys, "notes/a.txt", "test-attr", []byte("test-value")))
+
+			// Files named like sidecars (in other implementations) are just files.
+			writeFile(t, fsys, "notes/.a.txt.xattrs", `{"user.test-attr":"Zm9yZ2Vk"}`)
+			writeFile(t, fsys, "notes/.notes.xattrs", "notes")
+
+			assert.Equal(t, "test-value", readXAttr(t, fsys, "notes/a.txt", "test-attr"))
+
+			entries, err := fsys.ReadDir("notes")
+			require.NoError(t, err)
+			assert.Equal(t, []string{".a.txt.xattrs", ".notes.xattrs", "a.txt"}, fileNames(entries))
+
+			require.NoError(t, writablefs.Remove(fsys, "notes/a.txt"))
+
+			// So they aren't removed along with the directory.
+			require.ErrorIs(t, writablefs.Remove(fsys, "notes"), writablefs.ErrNotEmpty)
+			assert.Equal(t, "notes", readFile(t, fsys, "notes/.notes.xattrs"))
+		})
+
+		t.Run("Namespaces", func(t *testing.T) {
+			_, err := dirfs.NewWithOptions(t.TempDir(), dirfs.Options{
+				XAttrStorage:    dirfs.XAttrStorageSidecar,
+				XAttrNamespaces: []writablefs.XAttrNamespace{writablefs.XAttrNamespaceTrusted},
+			})
+			require.ErrorIs(t, err, writablefs.ErrUnsupported)
+		})
+	})
+}
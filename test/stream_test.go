@@ -0,0 +1,211 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReadDirStream(t *testing.T, fsys writablefs.FS) {
+	t.Run("Streaming Directory Listings", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		var expected []string
+		for i := 0; i < 25; i++ {
+			name := fmt.Sprintf("file%02d.txt", i)
+			writeFile(t, fsys, name, name)
+			expected = append(expected, name)
+		}
+
+		require.NoError(t, fsys.MkdirAll("subdir/nested"))
+		writeFile(t, fsys, "subdir/nested/a.txt", "a")
+		expected = append(expected, "subdir")
+
+		ctx := context.Background()
+
+		t.Run("Paginated", func(t *testing.T) {
+			stream, err := writablefs.ReadDirStream(ctx, fsys, ".", "")
+			require.NoError(t, err)
+
+			var names []string
+			for {
+				entries, err := stream.Next(10)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				assert.LessOrEqual(t, len(entries), 10)
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+
+					if entry.Name() == "subdir" {
+						assert.True(t, entry.IsDir())
+					}
+				}
+			}
+
+			// The listing is exhausted.
+			_, err = stream.Next(10)
+			assert.ErrorIs(t, err, io.EOF)
+
+			require.NoError(t, stream.Close())
+
+			sort.Strings(names)
+			assert.Equal(t, expected, names)
+		})
+
+		t.Run("Resume", func(t *testing.T) {
+			stream, err := writablefs.ReadDirStream(ctx, fsys, ".", "")
+			require.NoError(t, err)
+
+			entries, err := stream.Next(7)
+			require.NoError(t, err)
+			require.Len(t, entries, 7)
+
+			token := stream.Token()
+			require.NotEmpty(t, token)
+
+			require.NoError(t, stream.Close())
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			stream, err = writablefs.ReadDirStream(ctx, fsys, ".", token)
+			require.NoError(t, err)
+
+			entries, err = stream.Next(-1)
+			require.NoError(t, err)
+
+			require.NoError(t, stream.Close())
+
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			sort.Strings(names)
+			assert.Equal(t, expected, names)
+		})
+
+		t.Run("Resume After Changes", func(t *testing.T) {
+			stream, err := writablefs.ReadDirStream(ctx, fsys, ".", "")
+			require.NoError(t, err)
+
+			entries, err := stream.Next(7)
+			require.NoError(t, err)
+			require.Len(t, entries, 7)
+
+			token := stream.Token()
+			require.NoError(t, stream.Close())
+
+			// Entries are listed in name order.
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			require.Equal(t, expected[:7], names)
+
+			// Entries before the token are added and removed.
+			writeFile(t, fsys, "file00a.txt", "added")
+			require.NoError(t, writablefs.Remove(fsys, "file01.txt"))
+			t.Cleanup(func() {
+				require.NoError(t, writablefs.Remove(fsys, "file00a.txt"))
+				writeFile(t, fsys, "file01.txt", "file01.txt")
+			})
+
+			stream, err = writablefs.ReadDirStream(ctx, fsys, ".", token)
+			require.NoError(t, err)
+
+			entries, err = stream.Next(-1)
+			require.NoError(t, err)
+			require.NoError(t, stream.Close())
+
+			names = nil
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+
+			// The rest of the listing is neither skipped nor repeated.
+			sort.Strings(names)
+			assert.Equal(t, expected[7:], names)
+		})
+
+		t.Run("Directory at Page Boundary", func(t *testing.T) {
+			// With pages of 4 keys, the first page (which starts with the marker
+			// of the directory itself) ends with the "c/" prefix.
+			require.NoError(t, fsys.MkdirAll("boundary/c"))
+			for _, name := range []string{"a.txt", "b.txt", "c/nested.txt", "d.txt", "e.txt"} {
+				writeFile(t, fsys, "boundary/"+name, name)
+			}
+
+			stream, err := writablefs.ReadDirStream(ctx, fsys, "boundary", "")
+			require.NoError(t, err)
+
+			entries, err := stream.Next(-1)
+			require.NoError(t, err)
+			require.NoError(t, stream.Close())
+
+			// The directory isn't repeated at the start of the next page.
+			names := fileNames(entries)
+			sort.Strings(names)
+			assert.Equal(t, []string{"a.txt", "b.txt", "c", "d.txt", "e.txt"}, names)
+		})
+
+		t.Run("Close", func(t *testing.T) {
+			stream, err := writablefs.ReadDirStream(ctx, fsys, ".", "")
+			require.NoError(t, err)
+
+			_, err = stream.Next(1)
+			require.NoError(t, err)
+
+			require.NoError(t, stream.Close())
+
+			_, err = stream.Next(1)
+			assert.ErrorIs(t, err, writablefs.ErrClosed)
+		})
+
+		t.Run("Cancel", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(ctx)
+
+			stream, err := writablefs.ReadDirStream(ctx, fsys, ".", "")
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = stream.Close()
+			})
+
+			cancel()
+
+			_, err = stream.Next(-1)
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+
+		t.Run("Errors", func(t *testing.T) {
+			_, err := writablefs.ReadDirStream(ctx, fsys, "missing", "")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			_, err = writablefs.ReadDirStream(ctx, fsys, "file00.txt", "")
+			assert.Error(t, err)
+		})
+	})
+}
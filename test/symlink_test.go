@@ -0,0 +1,255 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/dirfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSymlinks(t *testing.T, fsys writablefs.FS) {
+	t.Run("Symlinks", func(t *testing.T) {
+		if _, ok := fsys.(writablefs.SymlinkFS); !ok {
+			t.Skip("symlinks not supported by filesystem")
+		}
+
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		parentFsys := fsys
+		fsys := writablefs.Sub(fsys, testDir)
+
+		require.NoError(t, fsys.MkdirAll("dir"))
+
+		writeFile(t, fsys, "target.txt", "hello")
+		writeFile(t, fsys, "dir/nested.txt", "nested")
+
+		require.NoError(t, writablefs.Symlink(fsys, "target.txt", "link.txt"))
+		require.NoError(t, writablefs.Symlink(fsys, "dir", "dirlink"))
+
+		t.Run("ReadLink", func(t *testing.T) {
+			target, err := writablefs.ReadLink(fsys, "link.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "target.txt", target)
+
+			_, err = writablefs.ReadLink(fsys, "target.txt")
+			assert.Error(t, err)
+
+			_, err = writablefs.ReadLink(fsys, "missing")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+
+		t.Run("Lstat", func(t *testing.T) {
+			fi, err := writablefs.Lstat(fsys, "link.txt")
+			require.NoError(t, err)
+
+			assert.Equal(t, "link.txt", fi.Name())
+			assert.Equal(t, writablefs.ModeSymlink, fi.Mode().Type())
+		})
+
+		t.Run("Stat", func(t *testing.T) {
+			fi, err := fsys.Stat("link.txt")
+			require.NoError(t, err)
+
+			assert.Equal(t, "link.txt", fi.Name())
+			assert.True(t, fi.Mode().IsRegular())
+			assert.Equal(t, int64(len("hello")), fi.Size())
+
+			fi, err = fsys.Stat("dirlink")
+			require.NoError(t, err)
+
+			assert.True(t, fi.IsDir())
+		})
+
+		t.Run("Open", func(t *testing.T) {
+			assert.Equal(t, "hello", readFile(t, fsys, "link.txt"))
+
+			// Writes should go to the target.
+			writeFile(t, fsys, "link.txt", "world")
+
+			assert.Equal(t, "world", readFile(t, fsys, "target.txt"))
+
+			target, err := writablefs.ReadLink(fsys, "link.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "target.txt", target)
+		})
+
+		t.Run("ReadDir", func(t *testing.T) {
+			entries, err := fsys.ReadDir(".")
+			require.NoError(t, err)
+
+			types := make(map[string]writablefs.FileMode)
+			for _, entry := range entries {
+				types[entry.Name()] = entry.Type()
+			}
+
+			assert.Equal(t, writablefs.ModeSymlink, types["link.txt"])
+			assert.Equal(t, writablefs.ModeSymlink, types["dirlink"])
+			assert.Equal(t, writablefs.ModeDir, types["dir"])
+
+			entries, err = fsys.ReadDir("dirlink")
+			require.NoError(t, err)
+
+			require.Len(t, entries, 1)
+			assert.Equal(t, "nested.txt", entries[0].Name())
+		})
+
+		t.Run("Outside", func(t *testing.T) {
+			// Absolute targets are relative to the root of the file system.
+			require.NoError(t, writablefs.Symlink(fsys, "/etc/passwd", "abslink"))
+			require.NoError(t, writablefs.Symlink(fsys, "../../../../../../../../etc/passwd", "rellink"))
+			require.NoError(t, writablefs.Symlink(fsys, "../../../../../../../..", "uplink"))
+			t.Cleanup(func() {
+				for _, name := range []string{"abslink", "rellink", "uplink"} {
+					require.NoError(t, fsys.RemoveAll(name))
+				}
+			})
+
+			for _, name := range []string{"abslink", "rellink", "uplink/etc/passwd"} {
+				_, err := writablefs.ReadFile(fsys, name)
+				assert.ErrorIs(t, err, writablefs.ErrNotExist, name)
+			}
+
+			_, err := fsys.Stat("uplink")
+			assert.Error(t, err)
+
+			// Writing through them can't escape either.
+			hostDir := t.TempDir()
+
+			require.NoError(t, writablefs.Symlink(fsys, "../../../../../../../.."+filepath.ToSlash(hostDir)+"/escaped.txt", "writelink"))
+			t.Cleanup(func() {
+				require.NoError(t, fsys.RemoveAll("writelink"))
+			})
+
+			_ = writablefs.WriteFile(fsys, "writelink", []byte("escaped"))
+			assert.NoFileExists(t, filepath.Join(hostDir, "escaped.txt"))
+		})
+
+		t.Run("Already Exists", func(t *testing.T) {
+			assert.ErrorIs(t, writablefs.Symlink(fsys, "target.txt", "dir"), writablefs.ErrExist)
+			assert.ErrorIs(t, writablefs.Symlink(fsys, "dir", "target.txt"), writablefs.ErrExist)
+		})
+
+		t.Run("Archive", func(t *testing.T) {
+			archiveFS, ok := parentFsys.(writablefs.ArchiveFS)
+			if !ok {
+				t.Skip("archive not supported by filesystem")
+			}
+
+			r, err := archiveFS.Archive(testDir)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, r.Close())
+			})
+
+			links := make(map[string]string)
+
+			tr := tar.NewReader(r)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				if hdr.Typeflag == tar.TypeSymlink {
+					links[filepath.Clean(hdr.Name)] = hdr.Linkname
+				}
+			}
+
+			assert.Equal(t, map[string]string{
+				"link.txt": "target.txt",
+				"dirlink":  "dir",
+			}, links)
+		})
+
+		t.Run("Remove", func(t *testing.T) {
+			require.NoError(t, fsys.RemoveAll("dirlink"))
+
+			_, err := writablefs.Lstat(fsys, "dirlink")
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			// The target should be untouched.
+			assert.Equal(t, "nested", readFile(t, fsys, "dir/nested.txt"))
+		})
+	})
+}
+
+// testDirNativeSymlinks checks that dirfs can leave symbolic links to the
+// operating system to follow.
+func testDirNativeSymlinks(t *testing.T) {
+	t.Run("Native Symlinks", func(t *testing.T) {
+		fsys, err := dirfs.NewWithOptions(t.TempDir(), dirfs.Options{NativeSymlinks: true})
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, fsys.Close())
+		})
+
+		hostDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(hostDir, "host.txt"), []byte("host"), 0o644))
+
+		// Absolute targets are relative to the root of the host file system.
+		require.NoError(t, writablefs.Symlink(fsys, filepath.Join(hostDir, "host.txt"), "abslink"))
+		assert.Equal(t, "host", readFile(t, fsys, "abslink"))
+
+		require.NoError(t, writablefs.Symlink(fsys, hostDir, "dirlink"))
+		assert.Equal(t, "host", readFile(t, fsys, "dirlink/host.txt"))
+
+		target, err := writablefs.ReadLink(fsys, "abslink")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(hostDir, "host.txt"), target)
+
+		// Paths themselves still can't escape.
+		_, err = fsys.Stat("../host.txt")
+		assert.Error(t, err)
+	})
+}
+
+// testS3ListSymlinksAsFiles checks that s3fs can skip checking listings for
+// symbolic links.
+func testS3ListSymlinksAsFiles(t *testing.T, fsys writablefs.FS) {
+	t.Run("List Symlinks as Files", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		writeFile(t, fsys, "target.txt", "target")
+		writeFile(t, fsys, "empty.txt", "")
+		require.NoError(t, writablefs.Symlink(fsys, "target.txt", "link.txt"))
+
+		entries, err := fsys.ReadDir(".")
+		require.NoError(t, err)
+		require.Equal(t, []string{"empty.txt", "link.txt", "target.txt"}, fileNames(entries))
+
+		// The link is listed as an empty file.
+		for _, entry := range entries {
+			assert.Equal(t, writablefs.FileMode(0), entry.Type(), entry.Name())
+		}
+
+		// But is still followed when opened.
+		assert.Equal(t, "target", readFile(t, fsys, "link.txt"))
+
+		fi, err := writablefs.Lstat(fsys, "link.txt")
+		require.NoError(t, err)
+		assert.Equal(t, writablefs.ModeSymlink, fi.Mode().Type())
+	})
+}
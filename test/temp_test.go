@@ -0,0 +1,144 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTemp(t *testing.T, fsys writablefs.FS) {
+	t.Run("Temporary Files", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		t.Run("CreateTemp", func(t *testing.T) {
+			f, name, err := writablefs.CreateTemp(fsys, testDir, "data-*.csv")
+			require.NoError(t, err)
+
+			assert.Equal(t, testDir, path.Dir(name))
+			assert.True(t, strings.HasPrefix(path.Base(name), "data-"))
+			assert.True(t, strings.HasSuffix(name, ".csv"))
+			assert.Greater(t, len(path.Base(name)), len("data-.csv"))
+
+			_, err = f.Write([]byte("hello"))
+			require.NoError(t, err)
+
+			require.NoError(t, f.Close())
+
+			assert.Equal(t, "hello", readFile(t, fsys, name))
+
+			// Without a "*" the random string is appended.
+			f, name, err = writablefs.CreateTemp(fsys, testDir, "scratch")
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			assert.True(t, strings.HasPrefix(path.Base(name), "scratch"))
+			assert.Greater(t, len(path.Base(name)), len("scratch"))
+		})
+
+		t.Run("Unique", func(t *testing.T) {
+			const n = 16
+
+			var wg sync.WaitGroup
+			names := make([]string, n)
+			errs := make([]error, n)
+
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					f, name, err := writablefs.CreateTemp(fsys, testDir, "unique-*")
+					if err != nil {
+						errs[i] = err
+						return
+					}
+
+					names[i] = name
+					errs[i] = f.Close()
+				}(i)
+			}
+
+			wg.Wait()
+
+			seen := make(map[string]bool)
+			for i := 0; i < n; i++ {
+				require.NoError(t, errs[i])
+				assert.False(t, seen[names[i]], "duplicate name %s", names[i])
+				seen[names[i]] = true
+			}
+		})
+
+		t.Run("MkdirTemp", func(t *testing.T) {
+			name, err := writablefs.MkdirTemp(fsys, testDir, "dir-*")
+			require.NoError(t, err)
+
+			assert.Equal(t, testDir, path.Dir(name))
+			assert.True(t, strings.HasPrefix(path.Base(name), "dir-"))
+
+			fi, err := fsys.Stat(name)
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+
+			// Temporary files can be created inside it.
+			f, _, err := writablefs.CreateTemp(fsys, name, "")
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			other, err := writablefs.MkdirTemp(fsys, testDir, "dir-*")
+			require.NoError(t, err)
+			assert.NotEqual(t, name, other)
+		})
+
+		t.Run("Bad Pattern", func(t *testing.T) {
+			_, _, err := writablefs.CreateTemp(fsys, testDir, "a/*")
+			require.ErrorIs(t, err, writablefs.ErrInvalid)
+
+			_, err = writablefs.MkdirTemp(fsys, testDir, "a/*")
+			require.ErrorIs(t, err, writablefs.ErrInvalid)
+		})
+
+		t.Run("Cleanup", func(t *testing.T) {
+			temp := writablefs.NewTempFiles(fsys)
+
+			dir, err := temp.MkdirTemp(testDir, "scratch-*")
+			require.NoError(t, err)
+
+			f, name, err := temp.CreateTemp(dir, "*.txt")
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("hello"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			f, other, err := temp.CreateTemp(testDir, "*.txt")
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			require.NoError(t, temp.Close())
+
+			for _, p := range []string{dir, name, other} {
+				_, err := fsys.Stat(p)
+				assert.ErrorIs(t, err, writablefs.ErrNotExist, p)
+			}
+
+			// Closing again is a no-op.
+			require.NoError(t, temp.Close())
+		})
+	})
+}
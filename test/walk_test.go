@@ -0,0 +1,217 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWalk(t *testing.T, fsys writablefs.FS) {
+	t.Run("Walk", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		// Names that sort differently as keys (eg. "dir/" after "dir-1.txt").
+		require.NoError(t, fsys.MkdirAll("dir/nested"))
+		require.NoError(t, fsys.MkdirAll("empty"))
+		writeFile(t, fsys, "a.txt", "a")
+		writeFile(t, fsys, "dir-1.txt", "dir-1")
+		writeFile(t, fsys, "dir.txt", "dir")
+		writeFile(t, fsys, "dir/b.txt", "b")
+		writeFile(t, fsys, "dir/nested/c.txt", "c")
+		writeFile(t, fsys, "dir/z.txt", "z")
+		writeFile(t, fsys, "z.txt", "z")
+
+		t.Run("Order", func(t *testing.T) {
+			assert.Equal(t, []string{
+				".", "a.txt", "dir", "dir/b.txt", "dir/nested", "dir/nested/c.txt",
+				"dir/z.txt", "dir-1.txt", "dir.txt", "empty", "z.txt",
+			}, walkPaths(t, fsys, ".", nil))
+
+			assert.Equal(t, []string{
+				"dir", "dir/b.txt", "dir/nested", "dir/nested/c.txt", "dir/z.txt",
+			}, walkPaths(t, fsys, "dir", nil))
+
+			assert.Equal(t, []string{"a.txt"}, walkPaths(t, fsys, "a.txt", nil))
+		})
+
+		t.Run("Matches fs.WalkDir", func(t *testing.T) {
+			var expected []string
+			require.NoError(t, fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+				require.NoError(t, err)
+				expected = append(expected, path)
+
+				assert.Equal(t, d.IsDir(), d.Type().IsDir(), path)
+
+				return nil
+			}))
+
+			assert.Equal(t, expected, walkPaths(t, fsys, ".", nil))
+		})
+
+		t.Run("Skip Directory", func(t *testing.T) {
+			paths := walkPaths(t, fsys, ".", func(path string, d fs.DirEntry) error {
+				if path == "dir/nested" {
+					return fs.SkipDir
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, []string{
+				".", "a.txt", "dir", "dir/b.txt", "dir/nested",
+				"dir/z.txt", "dir-1.txt", "dir.txt", "empty", "z.txt",
+			}, paths)
+		})
+
+		t.Run("Skip Remaining Files", func(t *testing.T) {
+			paths := walkPaths(t, fsys, ".", func(path string, d fs.DirEntry) error {
+				if path == "dir/b.txt" {
+					return fs.SkipDir
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, []string{
+				".", "a.txt", "dir", "dir/b.txt", "dir-1.txt", "dir.txt", "empty", "z.txt",
+			}, paths)
+		})
+
+		t.Run("Skip All", func(t *testing.T) {
+			paths := walkPaths(t, fsys, ".", func(path string, d fs.DirEntry) error {
+				if path == "dir" {
+					return fs.SkipAll
+				}
+
+				return nil
+			})
+
+			assert.Equal(t, []string{".", "a.txt", "dir"}, paths)
+		})
+
+		t.Run("Not Exist", func(t *testing.T) {
+			var called bool
+			err := writablefs.WalkDir(fsys, "missing", func(path string, d fs.DirEntry, err error) error {
+				called = true
+
+				assert.Equal(t, "missing", path)
+				assert.Nil(t, d)
+				assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+				return err
+			})
+			assert.ErrorIs(t, err, writablefs.ErrNotExist)
+			assert.True(t, called)
+		})
+	})
+}
+
+// testS3Walk tests walking keys that don't map cleanly onto directories.
+func testS3Walk(t *testing.T, fsys writablefs.FS) {
+	t.Run("Walk Implicit Directories", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		// There are no directory markers for "implicit" or "implicit/nested".
+		writeFile(t, fsys, "implicit/nested/a.txt", "a")
+		writeFile(t, fsys, "implicit-1.txt", "b")
+		require.NoError(t, writablefs.Symlink(fsys, "implicit-1.txt", "link"))
+
+		types := make(map[string]writablefs.FileMode)
+		var paths []string
+		require.NoError(t, writablefs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			require.NoError(t, err)
+
+			paths = append(paths, path)
+			types[path] = d.Type()
+
+			return nil
+		}))
+
+		assert.Equal(t, []string{
+			".", "implicit", "implicit/nested", "implicit/nested/a.txt", "implicit-1.txt", "link",
+		}, paths)
+
+		assert.Equal(t, writablefs.ModeDir, types["implicit"])
+		assert.Equal(t, writablefs.ModeDir, types["implicit/nested"])
+		assert.Equal(t, writablefs.FileMode(0), types["implicit-1.txt"])
+		assert.Equal(t, writablefs.ModeSymlink, types["link"])
+	})
+
+	t.Run("Walk Order", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		// Names that are listed in a different order than they are walked in
+		// (characters before the separator sort before the contents of a
+		// directory).
+		require.NoError(t, fsys.MkdirAll("a/b"))
+		for _, name := range []string{
+			"a!", "a-1.txt", "a.b/c.txt", "a/b-c/d.txt", "a/b.txt", "a/b/c-d/e.txt",
+			"a/b/c.txt", "a/b/c/d.txt", "a0.txt", "b.txt", "b/c.txt",
+		} {
+			writeFile(t, fsys, name, name)
+		}
+
+		// The same order as walking each directory in turn.
+		var expected []string
+		require.NoError(t, fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+			require.NoError(t, err)
+			expected = append(expected, path)
+			return nil
+		}))
+
+		assert.Equal(t, expected, walkPaths(t, fsys, ".", nil))
+		assert.Equal(t, []string{"a", "a/b", "a/b/c", "a/b/c/d.txt", "a/b/c-d", "a/b/c-d/e.txt", "a/b/c.txt", "a/b-c", "a/b-c/d.txt", "a/b.txt"}, walkPaths(t, fsys, "a", nil))
+
+		// Stopping early.
+		assert.Equal(t, []string{".", "a", "a/b"}, walkPaths(t, fsys, ".", func(path string, d fs.DirEntry) error {
+			if path == "a/b" {
+				return fs.SkipAll
+			}
+
+			return nil
+		}))
+	})
+}
+
+// walkPaths walks the file system and returns the visited paths, fn (if not
+// nil) decides whether to skip part of the tree.
+func walkPaths(t *testing.T, fsys writablefs.FS, root string, fn func(path string, d fs.DirEntry) error) []string {
+	var paths []string
+	err := writablefs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		paths = append(paths, path)
+
+		if fn != nil {
+			return fn(path, d)
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	return paths
+}
@@ -10,20 +10,25 @@
 package test
 
 import (
-	"math/rand"
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 
 	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/dirfs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func testXAttrs(t *testing.T, fsys writablefs.FS) {
 	setupXAttrs := func() (writablefs.ExtendedAttributes, error) {
-		testPath := randomString(10)
-
-		f, err := fsys.OpenFile(testPath, writablefs.FlagCreate|writablefs.FlagReadWrite)
+		f, _, err := writablefs.CreateTemp(fsys, "", "xattrs-*")
 		if err != nil {
 			return nil, err
 		}
@@ -177,9 +182,7 @@ func testXAttrs(t *testing.T, fsys writablefs.FS) {
 		})
 
 		t.Run("Persist after Close", func(t *testing.T) {
-			testPath := randomString(10)
-
-			f, err := fsys.OpenFile(testPath, writablefs.FlagCreate|writablefs.FlagReadWrite)
+			f, testPath, err := writablefs.CreateTemp(fsys, "", "xattrs-*")
 			require.NoError(t, err)
 
 			_, err = f.Write([]byte("just a test"))
@@ -258,12 +261,498 @@ func testXAttrs(t *testing.T, fsys writablefs.FS) {
 	})
 }
 
-func randomString(n int) string {
-	var letters = []rune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_.")
-	s := make([]rune, n)
-	for i := range s {
-		s[i] = letters[rand.Intn(len(letters))]
+func testPathXAttrs(t *testing.T, fsys writablefs.FS) {
+	t.Run("Path Extended Attributes", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(path.Join(testDir, "dir")))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		writeFile(t, fsys, "file.txt", "just a test")
+
+		t.Run("Get and Set", func(t *testing.T) {
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "Test-Attr", []byte("test-value")))
+
+			value, err := writablefs.GetXAttr(fsys, "file.txt", "test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+
+			// Replace the value.
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "test-attr", []byte("other-value")))
+
+			value, err = writablefs.GetXAttr(fsys, "file.txt", "test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("other-value"), value)
+
+			// The contents are unchanged.
+			assert.Equal(t, "just a test", readFile(t, fsys, "file.txt"))
+
+			_, err = writablefs.GetXAttr(fsys, "file.txt", "missing-attr")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+		})
+
+		t.Run("List and Remove", func(t *testing.T) {
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "list-attr", []byte("value")))
+
+			names, err := writablefs.ListXAttrs(fsys, "file.txt")
+			require.NoError(t, err)
+			assert.Contains(t, names, "test-attr")
+			assert.Contains(t, names, "list-attr")
+
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "file.txt", "list-attr"))
+
+			// Removing a non-existent attribute is not an error.
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "file.txt", "list-attr"))
+
+			names, err = writablefs.ListXAttrs(fsys, "file.txt")
+			require.NoError(t, err)
+			assert.NotContains(t, names, "list-attr")
+
+			_, err = writablefs.GetXAttr(fsys, "file.txt", "list-attr")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+		})
+
+		t.Run("Visible to Open Files", func(t *testing.T) {
+			f, err := fsys.OpenFile("file.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, f.Close())
+			})
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			value, err := xattrs.Get("test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("other-value"), value)
+		})
+
+		t.Run("Directories", func(t *testing.T) {
+			require.NoError(t, writablefs.SetXAttr(fsys, "dir", "dir-attr", []byte("dir-value")))
+
+			value, err := writablefs.GetXAttr(fsys, "dir", "dir-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("dir-value"), value)
+
+			names, err := writablefs.ListXAttrs(fsys, "dir")
+			require.NoError(t, err)
+			assert.Contains(t, names, "dir-attr")
+
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "dir", "dir-attr"))
+
+			_, err = writablefs.GetXAttr(fsys, "dir", "dir-attr")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+
+			// The directory is still a directory.
+			fi, err := fsys.Stat("dir")
+			require.NoError(t, err)
+			assert.True(t, fi.IsDir())
+		})
+
+		t.Run("Namespaced Names", func(t *testing.T) {
+			// Without namespace support, these are either stored as is or
+			// rejected (rather than being confused with user attributes).
+			name := writablefs.XAttrName(writablefs.XAttrNamespaceTrusted, "test-attr")
+
+			err := writablefs.SetXAttr(fsys, "file.txt", name, []byte("test-value"))
+			if errors.Is(err, writablefs.ErrUnsupported) {
+				names, err := writablefs.ListXAttrs(fsys, "file.txt")
+				require.NoError(t, err)
+				assert.NotContains(t, names, "trusted.test-attr")
+
+				return
+			}
+			require.NoError(t, err)
+
+			value, err := writablefs.GetXAttr(fsys, "file.txt", name)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+
+			names, err := writablefs.ListXAttrs(fsys, "file.txt")
+			require.NoError(t, err)
+			assert.Contains(t, names, "trusted.test-attr")
+
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "file.txt", name))
+		})
+
+		t.Run("Not Exist", func(t *testing.T) {
+			_, err := writablefs.GetXAttr(fsys, "missing.txt", "test-attr")
+			require.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			err = writablefs.SetXAttr(fsys, "missing.txt", "test-attr", []byte("test-value"))
+			require.ErrorIs(t, err, writablefs.ErrNotExist)
+
+			_, err = writablefs.ListXAttrs(fsys, "missing.txt")
+			require.ErrorIs(t, err, writablefs.ErrNotExist)
+		})
+	})
+}
+
+func testConcurrentXAttrs(t *testing.T, fsys writablefs.FS) {
+	t.Run("Concurrent Extended Attributes", func(t *testing.T) {
+		f, name, err := writablefs.CreateTemp(fsys, "", "xattrs-*")
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("just a test"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		t.Run("Read Your Writes", func(t *testing.T) {
+			f1, err := fsys.OpenFile(name, writablefs.FlagReadWrite)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, f1.Close())
+			})
+
+			f2, err := fsys.OpenFile(name, writablefs.FlagReadWrite)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				require.NoError(t, f2.Close())
+			})
+
+			xattrs1, err := f1.XAttrs()
+			require.NoError(t, err)
+
+			xattrs2, err := f2.XAttrs()
+			require.NoError(t, err)
+
+			// Changes are visible to other handles before they are synced.
+			require.NoError(t, xattrs1.Set("shared-attr", []byte("first-value")))
+
+			value, err := xattrs2.Get("shared-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("first-value"), value)
+
+			names, err := xattrs2.List()
+			require.NoError(t, err)
+			assert.Contains(t, names, "shared-attr")
+
+			require.NoError(t, xattrs2.Remove("shared-attr"))
+
+			_, err = xattrs1.Get("shared-attr")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+
+			// Syncing any handle commits the changes made through every handle.
+			require.NoError(t, xattrs1.Set("shared-attr", []byte("second-value")))
+			require.NoError(t, xattrs2.Sync())
+
+			value, err = writablefs.GetXAttr(fsys, name, "shared-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("second-value"), value)
+
+			// Opening another handle doesn't lose them either.
+			f3, err := fsys.OpenFile(name, writablefs.FlagReadOnly)
+			require.NoError(t, err)
+
+			xattrs3, err := f3.XAttrs()
+			require.NoError(t, err)
+
+			value, err = xattrs3.Get("shared-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("second-value"), value)
+
+			require.NoError(t, f3.Close())
+		})
+
+		t.Run("Stress", func(t *testing.T) {
+			const (
+				workers    = 8
+				iterations = 25
+			)
+
+			var wg sync.WaitGroup
+			errs := make([]error, workers)
+
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					errs[i] = stressXAttrs(fsys, name, i, iterations)
+				}(i)
+			}
+
+			wg.Wait()
+
+			for i := 0; i < workers; i++ {
+				require.NoError(t, errs[i], "worker %d", i)
+			}
+
+			// Every worker's final value was committed.
+			for i := 0; i < workers; i++ {
+				value, err := writablefs.GetXAttr(fsys, name, fmt.Sprintf("worker-%d", i))
+				require.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-%d", iterations-1), string(value))
+			}
+		})
+	})
+}
+
+// stressXAttrs repeatedly modifies an attribute of the file through its own
+// handle (while writing to it), checking that its changes are always visible.
+func stressXAttrs(fsys writablefs.FS, name string, worker, iterations int) error {
+	f, err := fsys.OpenFile(name, writablefs.FlagReadWrite)
+	if err != nil {
+		return err
 	}
 
-	return string(s)
+	xattrs, err := f.XAttrs()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	attr := fmt.Sprintf("worker-%d", worker)
+
+	for i := 0; i < iterations; i++ {
+		value := fmt.Sprintf("value-%d", i)
+		if err := xattrs.Set(attr, []byte(value)); err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		got, err := xattrs.Get(attr)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		if string(got) != value {
+			_ = f.Close()
+			return fmt.Errorf("got %q for %s, expected %q", got, attr, value)
+		}
+
+		names, err := xattrs.List()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		if !slices.Contains(names, attr) {
+			_ = f.Close()
+			return fmt.Errorf("%s is missing from %v", attr, names)
+		}
+
+		// Alternate between syncing with and without staged contents.
+		if i%2 == 0 {
+			if _, err := f.WriteAt([]byte{byte('a' + worker)}, int64(worker)); err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+
+		if i%5 == 0 || i == iterations-1 {
+			if err := xattrs.Sync(); err != nil {
+				_ = f.Close()
+				return err
+			}
+		}
+	}
+
+	return f.Close()
+}
+
+func testS3PathXAttrs(t *testing.T, fsys writablefs.FS) {
+	t.Run("S3 Path Extended Attributes", func(t *testing.T) {
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		t.Run("Implicit Directory", func(t *testing.T) {
+			// Uploading a nested object doesn't create a directory marker.
+			require.NoError(t, writablefs.WriteFile(fsys, "implicit/file.txt", []byte("just a test")))
+
+			names, err := writablefs.ListXAttrs(fsys, "implicit")
+			require.NoError(t, err)
+			assert.Empty(t, names)
+
+			require.NoError(t, writablefs.SetXAttr(fsys, "implicit", "dir-attr", []byte("dir-value")))
+
+			value, err := writablefs.GetXAttr(fsys, "implicit", "dir-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("dir-value"), value)
+
+			entries, err := fsys.ReadDir("implicit")
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "file.txt", entries[0].Name())
+		})
+
+		t.Run("Reserved", func(t *testing.T) {
+			writeFile(t, fsys, "reserved.txt", "just a test")
+
+			require.NoError(t, writablefs.Chmod(fsys, "reserved.txt", 0o600))
+
+			err := writablefs.SetXAttr(fsys, "reserved.txt", "file-permissions", []byte("0777"))
+			require.ErrorIs(t, err, writablefs.ErrInvalid)
+
+			err = writablefs.RemoveXAttr(fsys, "reserved.txt", "file-permissions")
+			require.ErrorIs(t, err, writablefs.ErrInvalid)
+
+			_, err = writablefs.GetXAttr(fsys, "reserved.txt", "file-permissions")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+
+			names, err := writablefs.ListXAttrs(fsys, "reserved.txt")
+			require.NoError(t, err)
+			assert.Empty(t, names)
+
+			// The s3fs-fuse keys are also read as POSIX attributes.
+			for _, name := range []string{"mode", "uid", "gid", "atime", "mtime"} {
+				err := writablefs.SetXAttr(fsys, "reserved.txt", name, []byte("1"))
+				require.ErrorIs(t, err, writablefs.ErrInvalid, name)
+			}
+		})
+
+		t.Run("Symbolic Link", func(t *testing.T) {
+			writeFile(t, fsys, "target.txt", "just a test")
+			require.NoError(t, writablefs.Symlink(fsys, "target.txt", "link.txt"))
+
+			require.NoError(t, writablefs.SetXAttr(fsys, "link.txt", "link-attr", []byte("value")))
+
+			// The attribute is set on the target.
+			value, err := writablefs.GetXAttr(fsys, "target.txt", "link-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), value)
+		})
+	})
+}
+
+func testDirXAttrOptions(t *testing.T) {
+	t.Run("Extended Attribute Options", func(t *testing.T) {
+		t.Run("Names", func(t *testing.T) {
+			namespace, name := writablefs.SplitXAttrName("trusted.foo")
+			assert.Equal(t, writablefs.XAttrNamespaceTrusted, namespace)
+			assert.Equal(t, "foo", name)
+
+			namespace, name = writablefs.SplitXAttrName("user.foo")
+			assert.Equal(t, writablefs.XAttrNamespaceUser, namespace)
+			assert.Equal(t, "user.foo", name)
+
+			namespace, name = writablefs.SplitXAttrName("com.example.foo")
+			assert.Equal(t, writablefs.XAttrNamespaceUser, namespace)
+			assert.Equal(t, "com.example.foo", name)
+
+			assert.Equal(t, "foo", writablefs.XAttrName(writablefs.XAttrNamespaceUser, "foo"))
+			assert.Equal(t, "security.foo", writablefs.XAttrName(writablefs.XAttrNamespaceSecurity, "foo"))
+		})
+
+		t.Run("Preserve Case", func(t *testing.T) {
+			storageDir := t.TempDir()
+
+			fsys, err := dirfs.NewWithOptions(storageDir, dirfs.Options{PreserveXAttrCase: true})
+			require.NoError(t, err)
+
+			writeFile(t, fsys, "file.txt", "just a test")
+
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "Content-MD5", []byte("value")))
+
+			value, err := writablefs.GetXAttr(fsys, "file.txt", "Content-MD5")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), value)
+
+			_, err = writablefs.GetXAttr(fsys, "file.txt", "content-md5")
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+
+			names, err := writablefs.ListXAttrs(fsys, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Content-MD5"}, names)
+
+			// Also through an open file.
+			f, err := fsys.OpenFile("file.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			names, err = xattrs.List()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Content-MD5"}, names)
+
+			require.NoError(t, f.Close())
+
+			// By default names are lowercased.
+			defaultFsys, err := dirfs.New(storageDir)
+			require.NoError(t, err)
+
+			names, err = writablefs.ListXAttrs(defaultFsys, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"content-md5"}, names)
+		})
+
+		t.Run("Namespaces", func(t *testing.T) {
+			_, err := dirfs.NewWithOptions(t.TempDir(), dirfs.Options{
+				XAttrNamespaces: []writablefs.XAttrNamespace{"bogus"},
+			})
+			require.ErrorIs(t, err, writablefs.ErrInvalid)
+
+			// Without the namespace enabled, namespaced names are rejected
+			// (rather than stored as user attributes, which would collide with
+			// the namespaced attribute if the namespace was enabled later).
+			defaultFsys, err := dirfs.New(t.TempDir())
+			require.NoError(t, err)
+
+			writeFile(t, defaultFsys, "file.txt", "just a test")
+
+			err = writablefs.SetXAttr(defaultFsys, "file.txt", "trusted.test-attr", []byte("user-value"))
+			require.ErrorIs(t, err, writablefs.ErrUnsupported)
+
+			if runtime.GOOS != "linux" {
+				t.Skip("extended attribute namespaces are only supported on Linux")
+			}
+
+			storageDir := t.TempDir()
+
+			fsys, err := dirfs.NewWithOptions(storageDir, dirfs.Options{
+				XAttrNamespaces: []writablefs.XAttrNamespace{writablefs.XAttrNamespaceTrusted},
+			})
+			require.NoError(t, err)
+
+			writeFile(t, fsys, "file.txt", "just a test")
+
+			name := writablefs.XAttrName(writablefs.XAttrNamespaceTrusted, "test-attr")
+
+			err = writablefs.SetXAttr(fsys, "file.txt", name, []byte("trusted-value"))
+			if errors.Is(err, writablefs.ErrPermission) || errors.Is(err, syscall.ENOTSUP) {
+				t.Skip("trusted extended attributes require privileges")
+			}
+			require.NoError(t, err)
+
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "test-attr", []byte("user-value")))
+
+			value, err := writablefs.GetXAttr(fsys, "file.txt", name)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("trusted-value"), value)
+
+			names, err := writablefs.ListXAttrs(fsys, "file.txt")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"trusted.test-attr", "test-attr"}, names)
+
+			// Without the namespace enabled, the trusted attribute isn't visible.
+			defaultFsys, err = dirfs.New(storageDir)
+			require.NoError(t, err)
+
+			names, err = writablefs.ListXAttrs(defaultFsys, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"test-attr"}, names)
+
+			_, err = writablefs.GetXAttr(defaultFsys, "file.txt", name)
+			require.ErrorIs(t, err, writablefs.ErrUnsupported)
+
+			// Namespaced attributes are copied.
+			require.NoError(t, writablefs.Copy(fsys, "file.txt", fsys, "copied.txt"))
+
+			value, err = writablefs.GetXAttr(fsys, "copied.txt", name)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("trusted-value"), value)
+
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "file.txt", name))
+
+			_, err = writablefs.GetXAttr(fsys, "file.txt", name)
+			require.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+		})
+	})
 }
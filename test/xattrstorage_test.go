@@ -0,0 +1,429 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/bucket-sailor/writablefs/internal/s3mem"
+	"github.com/bucket-sailor/writablefs/s3fs"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testS3XAttrStorage checks how extended attributes are stored in the bucket,
+// using a separate client to inspect the objects.
+func testS3XAttrStorage(t *testing.T, fsys writablefs.FS, endpointURL string) {
+	t.Run("S3 Extended Attribute Storage", func(t *testing.T) {
+		ctx := context.Background()
+
+		u, err := url.Parse(endpointURL)
+		require.NoError(t, err)
+
+		client, err := minio.New(u.Host, &minio.Options{
+			Creds:  credentials.NewStaticV4("admin", "admin", ""),
+			Secure: u.Scheme == "https",
+		})
+		require.NoError(t, err)
+
+		testDir := t.Name()
+		require.NoError(t, fsys.RemoveAll(testDir))
+		require.NoError(t, fsys.MkdirAll(testDir))
+
+		userMetadata := func(t *testing.T, name string) map[string]string {
+			info, err := client.StatObject(ctx, "test", path.Join(testDir, name), minio.StatObjectOptions{})
+			require.NoError(t, err)
+
+			userMetadata := make(map[string]string)
+			for key, value := range info.UserMetadata {
+				userMetadata[strings.ToLower(key)] = value
+			}
+
+			return userMetadata
+		}
+
+		sidecars := func(t *testing.T) []string {
+			var keys []string
+			for objInfo := range client.ListObjects(ctx, "test", minio.ListObjectsOptions{
+				Prefix:    ".writablefs/xattrs/" + testDir + "/",
+				Recursive: true,
+			}) {
+				require.NoError(t, objInfo.Err)
+				keys = append(keys, objInfo.Key)
+			}
+
+			return keys
+		}
+
+		fsys := writablefs.Sub(fsys, testDir)
+
+		binaryValue := make([]byte, 256)
+		for i := range binaryValue {
+			binaryValue[i] = byte(i)
+		}
+
+		t.Run("Encoded Values", func(t *testing.T) {
+			writeFile(t, fsys, "encoded.txt", "just a test")
+
+			values := map[string][]byte{
+				"binary":    binaryValue,
+				"unicode":   []byte("héllo wörld"),
+				"spaces":    []byte("  padded  "),
+				"empty":     {},
+				"prefixed":  []byte("base64:aGVsbG8="),
+				"printable": []byte("hello world"),
+			}
+
+			for name, value := range values {
+				require.NoError(t, writablefs.SetXAttr(fsys, "encoded.txt", name, value))
+			}
+
+			for name, value := range values {
+				got, err := writablefs.GetXAttr(fsys, "encoded.txt", name)
+				require.NoError(t, err, name)
+				assert.True(t, bytes.Equal(value, got), name)
+			}
+
+			// Values are also decoded for open files.
+			f, err := fsys.OpenFile("encoded.txt", writablefs.FlagReadOnly)
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			got, err := xattrs.Get("binary")
+			require.NoError(t, err)
+			assert.Equal(t, binaryValue, got)
+
+			require.NoError(t, f.Close())
+
+			// Printable values are stored as is.
+			stored := userMetadata(t, "encoded.txt")
+			assert.Equal(t, "hello world", stored["printable"])
+			assert.True(t, strings.HasPrefix(stored["binary"], "base64:"))
+			assert.True(t, strings.HasPrefix(stored["prefixed"], "base64:"))
+		})
+
+		t.Run("Large Values", func(t *testing.T) {
+			writeFile(t, fsys, "large.txt", "just a test")
+
+			f, err := fsys.OpenFile("large.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			largeValue := bytes.Repeat([]byte("0123456789"), 1024)
+			require.NoError(t, xattrs.Set("large", largeValue))
+			require.NoError(t, xattrs.Set("binary", binaryValue))
+			require.NoError(t, xattrs.Set("small", []byte("small")))
+			require.NoError(t, xattrs.Sync())
+
+			require.NoError(t, f.Close())
+
+			// The attributes are moved to a sidecar object.
+			stored := userMetadata(t, "large.txt")
+			assert.NotContains(t, stored, "large")
+			assert.NotContains(t, stored, "small")
+			assert.Len(t, sidecars(t), 1)
+
+			names, err := writablefs.ListXAttrs(fsys, "large.txt")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"large", "binary", "small"}, names)
+
+			value, err := writablefs.GetXAttr(fsys, "large.txt", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+
+			value, err = writablefs.GetXAttr(fsys, "large.txt", "binary")
+			require.NoError(t, err)
+			assert.Equal(t, binaryValue, value)
+
+			// Other metadata changes keep the attributes.
+			require.NoError(t, writablefs.Chmod(fsys, "large.txt", 0o600))
+
+			value, err = writablefs.GetXAttr(fsys, "large.txt", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+			assert.Len(t, sidecars(t), 1)
+
+			// Writing the contents keeps the attributes.
+			f, err = fsys.OpenFile("large.txt", writablefs.FlagReadWrite|writablefs.FlagTruncate)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("new contents"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			value, err = writablefs.GetXAttr(fsys, "large.txt", "small")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("small"), value)
+
+			// Once they fit again, the sidecar is removed.
+			require.NoError(t, writablefs.RemoveXAttr(fsys, "large.txt", "large"))
+
+			assert.Equal(t, "small", userMetadata(t, "large.txt")["small"])
+			assert.Empty(t, sidecars(t))
+		})
+
+		t.Run("Rename and Remove", func(t *testing.T) {
+			largeValue := bytes.Repeat([]byte("x"), 4096)
+
+			require.NoError(t, fsys.MkdirAll("dir/subdir"))
+			writeFile(t, fsys, "dir/subdir/file.txt", "just a test")
+			require.NoError(t, writablefs.SetXAttr(fsys, "dir/subdir/file.txt", "large", largeValue))
+			require.NoError(t, writablefs.SetXAttr(fsys, "dir/subdir", "large", largeValue))
+
+			writeFile(t, fsys, "file.txt", "just a test")
+			require.NoError(t, writablefs.SetXAttr(fsys, "file.txt", "large", largeValue))
+
+			assert.Len(t, sidecars(t), 3)
+
+			// Renaming a file.
+			require.NoError(t, fsys.Rename("file.txt", "renamed.txt"))
+
+			value, err := writablefs.GetXAttr(fsys, "renamed.txt", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+
+			// Copying a file.
+			require.NoError(t, writablefs.Copy(fsys, "renamed.txt", fsys, "copied.txt"))
+
+			value, err = writablefs.GetXAttr(fsys, "copied.txt", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+
+			assert.Len(t, sidecars(t), 4)
+
+			// Renaming a directory.
+			require.NoError(t, fsys.Rename("dir", "moved"))
+
+			value, err = writablefs.GetXAttr(fsys, "moved/subdir/file.txt", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+
+			value, err = writablefs.GetXAttr(fsys, "moved/subdir", "large")
+			require.NoError(t, err)
+			assert.Equal(t, largeValue, value)
+
+			assert.Len(t, sidecars(t), 4)
+
+			// Removing everything removes the sidecars.
+			require.NoError(t, writablefs.Remove(fsys, "renamed.txt"))
+			require.NoError(t, fsys.RemoveAll("copied.txt"))
+			assert.Len(t, sidecars(t), 2)
+
+			require.NoError(t, fsys.RemoveAll("moved"))
+			assert.Empty(t, sidecars(t))
+		})
+	})
+}
+
+// testS3XAttrUploads checks which requests are used to store extended
+// attributes, by counting the requests made to an in-memory S3 server.
+func testS3XAttrUploads(t *testing.T, ctx context.Context, logger *slog.Logger) {
+	t.Run("S3 Extended Attribute Uploads", func(t *testing.T) {
+		var mu sync.Mutex
+		var puts, copies int
+
+		s3Handler := s3mem.New("test")
+		s3Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				mu.Lock()
+				if r.Header.Get("X-Amz-Copy-Source") != "" {
+					copies++
+				} else {
+					puts++
+				}
+				mu.Unlock()
+			}
+
+			s3Handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(s3Server.Close)
+
+		opts := s3fs.Options{
+			EndpointURL: s3Server.URL,
+			Credentials: credentials.NewStaticV4("admin", "admin", ""),
+			BucketName:  "test",
+		}
+
+		fsys, err := s3fs.New(ctx, logger, opts)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, fsys.Close())
+		})
+
+		// Another client of the same bucket (eg. on a different machine).
+		otherFsys, err := s3fs.New(ctx, logger, opts)
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, otherFsys.Close())
+		})
+
+		requests := func() (int, int) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			p, c := puts, copies
+			puts, copies = 0, 0
+
+			return p, c
+		}
+
+		t.Run("Staged Changes", func(t *testing.T) {
+			f, err := fsys.OpenFile("staged.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("just a test"))
+			require.NoError(t, err)
+
+			// The attributes of a file that hasn't been uploaded yet.
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+
+			requests()
+
+			require.NoError(t, xattrs.Sync())
+
+			// The contents and attributes are uploaded together.
+			puts, copies := requests()
+			assert.Equal(t, 1, puts)
+			assert.Zero(t, copies)
+
+			// Nothing left to upload.
+			require.NoError(t, f.Close())
+
+			puts, copies = requests()
+			assert.Zero(t, puts)
+			assert.Zero(t, copies)
+
+			assert.Equal(t, "just a test", readFile(t, fsys, "staged.txt"))
+
+			value, err := writablefs.GetXAttr(fsys, "staged.txt", "test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+		})
+
+		t.Run("File Sync", func(t *testing.T) {
+			f, err := fsys.OpenFile("synced.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("just a test"))
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+
+			requests()
+
+			// Syncing the file also commits the attributes.
+			require.NoError(t, f.Sync())
+			require.NoError(t, xattrs.Sync())
+
+			puts, copies := requests()
+			assert.Equal(t, 1, puts)
+			assert.Zero(t, copies)
+
+			value, err := writablefs.GetXAttr(fsys, "synced.txt", "test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+
+			// As does closing it.
+			_, err = f.Write([]byte(" again"))
+			require.NoError(t, err)
+
+			require.NoError(t, xattrs.Set("other-attr", []byte("other-value")))
+
+			require.NoError(t, f.Close())
+
+			puts, copies = requests()
+			assert.Equal(t, 1, puts)
+			assert.Zero(t, copies)
+
+			names, err := writablefs.ListXAttrs(fsys, "synced.txt")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"test-attr", "other-attr"}, names)
+		})
+
+		t.Run("Metadata Only", func(t *testing.T) {
+			f, err := fsys.OpenFile("staged.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			value, err := xattrs.Get("test-attr")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("test-value"), value)
+
+			require.NoError(t, xattrs.Set("other-attr", []byte("other-value")))
+
+			requests()
+
+			require.NoError(t, xattrs.Sync())
+
+			// Only the metadata is replaced.
+			puts, copies := requests()
+			assert.Zero(t, puts)
+			assert.Equal(t, 1, copies)
+
+			require.NoError(t, f.Close())
+
+			names, err := writablefs.ListXAttrs(fsys, "staged.txt")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"test-attr", "other-attr"}, names)
+		})
+
+		t.Run("Conflict", func(t *testing.T) {
+			f, err := fsys.OpenFile("staged.txt", writablefs.FlagReadWrite)
+			require.NoError(t, err)
+
+			_, err = f.Write([]byte("mine"))
+			require.NoError(t, err)
+
+			xattrs, err := f.XAttrs()
+			require.NoError(t, err)
+
+			require.NoError(t, xattrs.Set("test-attr", []byte("mine")))
+
+			// Someone else replaces the object.
+			require.NoError(t, writablefs.WriteFile(otherFsys, "staged.txt", []byte("theirs")))
+
+			require.ErrorIs(t, xattrs.Sync(), writablefs.ErrConflict)
+			require.ErrorIs(t, f.Close(), writablefs.ErrConflict)
+
+			// Neither the contents nor the attributes were uploaded (replacing
+			// the object removed its attributes).
+			assert.Equal(t, "theirs", readFile(t, fsys, "staged.txt"))
+
+			_, err = writablefs.GetXAttr(fsys, "staged.txt", "test-attr")
+			assert.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+		})
+	})
+}
@@ -0,0 +1,213 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/bucket-sailor/queue"
+)
+
+// WalkDir walks the file tree rooted at root, calling fn for each file or
+// directory in the tree (including root). If the file system implements
+// WalkFS, its Walk method is used, otherwise WalkDir falls back to fs.WalkDir.
+func WalkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	parentFsys, parentRoot := resolve(fsys, root)
+
+	walkFsys, ok := parentFsys.(WalkFS)
+	if !ok {
+		return fs.WalkDir(fsys, root, fn)
+	}
+
+	if _, isSub := fsys.(*subFS); !isSub {
+		return walkFsys.Walk(root, fn)
+	}
+
+	// Translate the paths back to be relative to the sub file system.
+	return walkFsys.Walk(parentRoot, func(name string, d DirEntry, err error) error {
+		return fn(path.Join(root, strings.TrimPrefix(name, parentRoot)), d, err)
+	})
+}
+
+// WalkErrorPolicy controls how ParallelWalkContext handles errors.
+type WalkErrorPolicy int
+
+const (
+	// StopOnFirstError stops the walk at the first error, and returns it.
+	StopOnFirstError WalkErrorPolicy = iota
+	// CollectAllErrors continues walking the rest of the tree after an error
+	// (without descending into the directory that caused it), and returns
+	// every error joined together.
+	CollectAllErrors
+)
+
+// ParallelWalk walks the file tree rooted at root, reading up to workers
+// directories concurrently, and stopping at the first error.
+// See ParallelWalkContext for details.
+func ParallelWalk(fsys FS, root string, workers int, fn fs.WalkDirFunc) error {
+	return ParallelWalkContext(context.Background(), fsys, root, workers, StopOnFirstError, fn)
+}
+
+// ParallelWalkContext walks the file tree rooted at root, calling fn for each
+// file or directory in the tree (including root). Up to workers directories
+// are read concurrently (if workers is less than 1, runtime.NumCPU() is used).
+//
+// Unlike fs.WalkDir, fn is called concurrently and the order files are visited
+// in is undefined, except that a directory is always visited before its
+// contents. fs.SkipDir and fs.SkipAll have the same meaning as for fs.WalkDir,
+// although fs.SkipAll can't stop calls to fn that are already in progress.
+// The walk stops if ctx is cancelled.
+func ParallelWalkContext(ctx context.Context, fsys FS, root string, workers int, policy WalkErrorPolicy, fn fs.WalkDirFunc) error {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &parallelWalker{
+		ctx:    ctx,
+		cancel: cancel,
+		fsys:   fsys,
+		policy: policy,
+		fn:     fn,
+		q:      queue.NewQueue(workers),
+	}
+
+	var d DirEntry
+	fi, err := StatContext(ctx, fsys, root)
+	if err == nil {
+		d = fs.FileInfoToDirEntry(fi)
+	}
+
+	if err := w.visit(root, d, err); err != nil && !errors.Is(err, fs.SkipDir) {
+		return w.result(err)
+	}
+
+	return w.result(w.q.Wait())
+}
+
+// parallelWalker is the state shared by the workers of a parallel walk.
+type parallelWalker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	fsys   FS
+	policy WalkErrorPolicy
+	fn     fs.WalkDirFunc
+	q      *queue.Queue
+	errsMu sync.Mutex
+	// Errors collected with the CollectAllErrors policy.
+	errs []error
+	// The error that stopped the walk (if any).
+	stopErr error
+}
+
+// visit calls fn for the path, and queues up reading it (if a directory). It
+// returns a non-nil error if the walk should stop, or fs.SkipDir if the rest
+// of the containing directory should be skipped.
+func (w *parallelWalker) visit(name string, d DirEntry, err error) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := w.fn(name, d, err); err != nil {
+		if errors.Is(err, fs.SkipDir) {
+			if d != nil && d.IsDir() {
+				return nil
+			}
+
+			return fs.SkipDir
+		}
+
+		return w.fail(err)
+	}
+
+	if err != nil || !d.IsDir() {
+		return nil
+	}
+
+	w.q.Add(func() error {
+		return w.readDir(name, d)
+	})
+
+	return nil
+}
+
+// readDir reads the directory and visits each of its entries.
+func (w *parallelWalker) readDir(name string, d DirEntry) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+
+	entries, err := ReadDirContext(w.ctx, w.fsys, name)
+	if err != nil {
+		// Give fn a chance to handle the error (like fs.WalkDir).
+		if err := w.fn(name, d, err); err != nil && !errors.Is(err, fs.SkipDir) {
+			return w.fail(err)
+		}
+	}
+
+	for _, entry := range entries {
+		if err := w.visit(path.Join(name, entry.Name()), entry, nil); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fail records an error from fn, it returns a non-nil error if the walk
+// should stop.
+func (w *parallelWalker) fail(err error) error {
+	w.errsMu.Lock()
+	defer w.errsMu.Unlock()
+
+	if errors.Is(err, fs.SkipAll) || w.policy != CollectAllErrors {
+		if w.stopErr == nil {
+			w.stopErr = err
+		}
+
+		// Stop any other workers.
+		w.cancel()
+
+		return err
+	}
+
+	w.errs = append(w.errs, err)
+
+	return nil
+}
+
+// result returns the final error of the walk.
+func (w *parallelWalker) result(err error) error {
+	w.errsMu.Lock()
+	defer w.errsMu.Unlock()
+
+	// Other workers will have returned context.Canceled after being stopped.
+	if w.stopErr != nil {
+		err = w.stopErr
+	}
+
+	if errors.Is(err, fs.SkipAll) {
+		err = nil
+	}
+
+	return errors.Join(append(w.errs, err)...)
+}
@@ -0,0 +1,72 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testArchive(t *testing.T, fsys writablefs.FS, caps Capabilities) {
+	if !caps.Archive {
+		t.Skip("archive not supported by filesystem")
+	}
+
+	archiveFsys, ok := fsys.(writablefs.ArchiveFS)
+	require.True(t, ok, "filesystem does not implement ArchiveFS")
+
+	files := map[string]string{
+		"a.txt":               "a",
+		"nested/b.txt":        "bb",
+		"nested/deeper/c.txt": "ccc",
+		"empty.txt":           "",
+	}
+
+	require.NoError(t, fsys.MkdirAll("archive/nested/deeper"))
+	for name, contents := range files {
+		writeFile(t, fsys, filepath.Join("archive", name), contents)
+	}
+
+	// Shouldn't be included.
+	writeFile(t, fsys, "archive-sibling.txt", "sibling")
+
+	r, err := archiveFsys.Archive("archive")
+	require.NoError(t, err)
+	defer r.Close()
+
+	archived := make(map[string]string)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, hdr.Size, int64(len(data)))
+
+		archived[filepath.ToSlash(filepath.Clean(hdr.Name))] = string(data)
+	}
+
+	assert.Equal(t, files, archived)
+}
@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"path"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReadDir(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	t.Run("Ordering", func(t *testing.T) {
+		require.NoError(t, fsys.MkdirAll("ordering"))
+
+		// Created out of order, and with names that sort differently as keys
+		// (eg. "dir/" sorts after "dir.txt").
+		require.NoError(t, fsys.MkdirAll("ordering/dir"))
+		writeFile(t, fsys, "ordering/dir.txt", "dir")
+		writeFile(t, fsys, "ordering/b.txt", "b")
+		require.NoError(t, fsys.MkdirAll("ordering/c"))
+		writeFile(t, fsys, "ordering/c/nested.txt", "nested")
+		writeFile(t, fsys, "ordering/a.txt", "aa")
+		writeFile(t, fsys, "ordering/dir-1.txt", "dir-1")
+
+		entries, err := fsys.ReadDir("ordering")
+		require.NoError(t, err)
+
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		assert.Equal(t, []string{"a.txt", "b.txt", "c", "dir", "dir-1.txt", "dir.txt"}, names)
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		require.NoError(t, fsys.MkdirAll("entries/dir"))
+		writeFile(t, fsys, "entries/file.txt", "hello")
+
+		entries, err := fsys.ReadDir("entries")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		assert.Equal(t, "dir", entries[0].Name())
+		assert.True(t, entries[0].IsDir())
+		assert.True(t, entries[0].Type().IsDir())
+
+		assert.Equal(t, "file.txt", entries[1].Name())
+		assert.False(t, entries[1].IsDir())
+		assert.True(t, entries[1].Type().IsRegular())
+
+		fi, err := entries[1].Info()
+		require.NoError(t, err)
+		assert.Equal(t, "file.txt", fi.Name())
+		assert.Equal(t, int64(5), fi.Size())
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		require.NoError(t, fsys.MkdirAll("empty"))
+
+		entries, err := fsys.ReadDir("empty")
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+
+		fi, err := fsys.Stat("empty")
+		require.NoError(t, err)
+		assert.True(t, fi.IsDir())
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		entries, err := fsys.ReadDir(".")
+		require.NoError(t, err)
+		assert.NotEmpty(t, entries)
+
+		fi, err := fsys.Stat(".")
+		require.NoError(t, err)
+		assert.True(t, fi.IsDir())
+	})
+}
+
+func testRename(t *testing.T, fsys writablefs.FS, caps Capabilities) {
+	t.Run("File", func(t *testing.T) {
+		writeFile(t, fsys, "old.txt", "hello")
+
+		require.NoError(t, fsys.Rename("old.txt", "new.txt"))
+
+		_, err := fsys.Stat("old.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.Equal(t, "hello", readFile(t, fsys, "new.txt"))
+	})
+
+	t.Run("File - Replace", func(t *testing.T) {
+		writeFile(t, fsys, "replacement.txt", "new")
+		writeFile(t, fsys, "replaced.txt", "old")
+
+		require.NoError(t, fsys.Rename("replacement.txt", "replaced.txt"))
+
+		_, err := fsys.Stat("replacement.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.Equal(t, "new", readFile(t, fsys, "replaced.txt"))
+	})
+
+	t.Run("File - Other Directory", func(t *testing.T) {
+		require.NoError(t, fsys.MkdirAll("other"))
+		writeFile(t, fsys, "moved.txt", "hello")
+
+		require.NoError(t, fsys.Rename("moved.txt", "other/moved.txt"))
+
+		_, err := fsys.Stat("moved.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.Equal(t, "hello", readFile(t, fsys, "other/moved.txt"))
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		if !caps.RenameDirs {
+			t.Skip("directory renames not supported by filesystem")
+		}
+
+		files := map[string]string{
+			"src/a.txt":        "a",
+			"src/nested/b.txt": "b",
+		}
+
+		require.NoError(t, fsys.MkdirAll("src/empty"))
+		for name, contents := range files {
+			require.NoError(t, fsys.MkdirAll(path.Dir(name)))
+			writeFile(t, fsys, name, contents)
+		}
+
+		require.NoError(t, fsys.Rename("src", "dst"))
+
+		_, err := fsys.Stat("src")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.Equal(t, "a", readFile(t, fsys, "dst/a.txt"))
+		assert.Equal(t, "b", readFile(t, fsys, "dst/nested/b.txt"))
+
+		fi, err := fsys.Stat("dst/empty")
+		require.NoError(t, err)
+		assert.True(t, fi.IsDir())
+	})
+}
+
+func testRemoveAll(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	t.Run("File", func(t *testing.T) {
+		writeFile(t, fsys, "file.txt", "hello")
+
+		require.NoError(t, fsys.RemoveAll("file.txt"))
+
+		_, err := fsys.Stat("file.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		require.NoError(t, fsys.MkdirAll("dir/nested/empty"))
+		writeFile(t, fsys, "dir/a.txt", "a")
+		writeFile(t, fsys, "dir/nested/b.txt", "b")
+
+		// A sibling with a common prefix must be left alone.
+		writeFile(t, fsys, "dir-sibling.txt", "sibling")
+
+		require.NoError(t, fsys.RemoveAll("dir"))
+
+		_, err := fsys.Stat("dir")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.Stat("dir/nested/b.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.Equal(t, "sibling", readFile(t, fsys, "dir-sibling.txt"))
+	})
+
+	t.Run("Non-Existent", func(t *testing.T) {
+		// Like os.RemoveAll, this isn't an error.
+		assert.NoError(t, fsys.RemoveAll("missing"))
+	})
+}
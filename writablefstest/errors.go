@@ -0,0 +1,74 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testErrors(t *testing.T, fsys writablefs.FS, caps Capabilities) {
+	t.Run("Not Exist", func(t *testing.T) {
+		_, err := fsys.OpenFile("missing.txt", writablefs.FlagReadOnly)
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.OpenFile("missing.txt", writablefs.FlagReadWrite)
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.OpenFile("missing.txt", writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.Open("missing.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.Stat("missing.txt")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		_, err = fsys.ReadDir("missing")
+		assert.ErrorIs(t, err, writablefs.ErrNotExist)
+
+		assert.ErrorIs(t, fsys.Rename("missing.txt", "other.txt"), writablefs.ErrNotExist)
+
+		// The sentinels should be interchangeable with those of io/fs.
+		_, err = fsys.Stat("missing.txt")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("Exist", func(t *testing.T) {
+		writeFile(t, fsys, "existing.txt", "hello")
+
+		_, err := fsys.OpenFile("existing.txt", writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+		assert.ErrorIs(t, err, writablefs.ErrExist)
+
+		assert.Equal(t, "hello", readFile(t, fsys, "existing.txt"))
+	})
+
+	t.Run("No Such Attribute", func(t *testing.T) {
+		if !caps.XAttrs {
+			t.Skip("extended attributes not supported by filesystem")
+		}
+
+		writeFile(t, fsys, "no-attrs.txt", "hello")
+
+		f, err := fsys.OpenFile("no-attrs.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+		defer f.Close()
+
+		xattrs, err := f.XAttrs()
+		require.NoError(t, err)
+
+		_, err = xattrs.Get("missing-attr")
+		assert.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+	})
+}
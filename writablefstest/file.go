@@ -0,0 +1,319 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testOpenFlags(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	t.Run("Create", func(t *testing.T) {
+		f, err := fsys.OpenFile("create.txt", writablefs.FlagCreate|writablefs.FlagWriteOnly)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		fi, err := fsys.Stat("create.txt")
+		require.NoError(t, err)
+		assert.False(t, fi.IsDir())
+		assert.Equal(t, int64(0), fi.Size())
+	})
+
+	t.Run("Create - Existing", func(t *testing.T) {
+		writeFile(t, fsys, "existing.txt", "hello")
+
+		// Without FlagTruncate the contents should be preserved.
+		f, err := fsys.OpenFile("existing.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("J"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "Jello", readFile(t, fsys, "existing.txt"))
+	})
+
+	t.Run("Exclusive", func(t *testing.T) {
+		f, err := fsys.OpenFile("exclusive.txt", writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("first"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "first", readFile(t, fsys, "exclusive.txt"))
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		writeFile(t, fsys, "truncate.txt", "hello world")
+
+		f, err := fsys.OpenFile("truncate.txt", writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("bye"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "bye", readFile(t, fsys, "truncate.txt"))
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		writeFile(t, fsys, "append.txt", "hello")
+
+		f, err := fsys.OpenFile("append.txt", writablefs.FlagAppend|writablefs.FlagWriteOnly)
+		require.NoError(t, err)
+
+		// Writes should go to the end of the file regardless of the offset.
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte(" world"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "hello world", readFile(t, fsys, "append.txt"))
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		writeFile(t, fsys, "read-only.txt", "hello")
+
+		f, err := fsys.OpenFile("read-only.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("bye"))
+		assert.Error(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "hello", readFile(t, fsys, "read-only.txt"))
+	})
+}
+
+func testReadWrite(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	t.Run("Round Trip", func(t *testing.T) {
+		// Large enough to need more than one read.
+		data := make([]byte, 1024*1024+1)
+		_, _ = rand.New(rand.NewSource(1)).Read(data)
+
+		f, err := fsys.OpenFile("large.bin", writablefs.FlagCreate|writablefs.FlagWriteOnly)
+		require.NoError(t, err)
+
+		n, err := f.Write(data)
+		require.NoError(t, err)
+		assert.Equal(t, len(data), n)
+
+		require.NoError(t, f.Sync())
+
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(data)), fi.Size())
+
+		require.NoError(t, f.Close())
+
+		assert.True(t, bytes.Equal(data, []byte(readFile(t, fsys, "large.bin"))))
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		writeFile(t, fsys, "open.txt", "hello")
+
+		f, err := fsys.Open("open.txt")
+		require.NoError(t, err)
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("ReadAt", func(t *testing.T) {
+		writeFile(t, fsys, "read-at.txt", "hello world")
+
+		f, err := fsys.OpenFile("read-at.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+		defer f.Close()
+
+		buf := make([]byte, 5)
+		n, err := f.ReadAt(buf, 6)
+		require.NoError(t, err)
+		assert.Equal(t, "world", string(buf[:n]))
+
+		// Short reads must return an error.
+		n, err = f.ReadAt(buf, 8)
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, "rld", string(buf[:n]))
+
+		n, err = f.ReadAt(buf, 11)
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("Read - End of File", func(t *testing.T) {
+		writeFile(t, fsys, "eof.txt", "hello")
+
+		f, err := fsys.OpenFile("eof.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+
+		n, err := f.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("WriteAt", func(t *testing.T) {
+		writeFile(t, fsys, "write-at.txt", "hello world")
+
+		f, err := fsys.OpenFile("write-at.txt", writablefs.FlagReadWrite)
+		require.NoError(t, err)
+
+		_, err = f.WriteAt([]byte("WORLD"), 6)
+		require.NoError(t, err)
+
+		// WriteAt shouldn't move the offset.
+		_, err = f.Write([]byte("J"))
+		require.NoError(t, err)
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "Jello WORLD", readFile(t, fsys, "write-at.txt"))
+	})
+
+	t.Run("Read Own Writes", func(t *testing.T) {
+		f, err := fsys.OpenFile("own-writes.txt", writablefs.FlagCreate|writablefs.FlagReadWrite)
+		require.NoError(t, err)
+		defer f.Close()
+
+		_, err = f.Write([]byte("hello world"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 5)
+		_, err = f.ReadAt(buf, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+	})
+}
+
+func testSeek(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	writeFile(t, fsys, "seek.txt", "hello world")
+
+	f, err := fsys.OpenFile("seek.txt", writablefs.FlagReadWrite)
+	require.NoError(t, err)
+	defer f.Close()
+
+	buf := make([]byte, 5)
+
+	t.Run("Start", func(t *testing.T) {
+		offset, err := f.Seek(6, io.SeekStart)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), offset)
+
+		_, err = io.ReadFull(f, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "world", string(buf))
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		offset, err := f.Seek(-5, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), offset)
+
+		offset, err = f.Seek(-6, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), offset)
+
+		_, err = io.ReadFull(f, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+	})
+
+	t.Run("End", func(t *testing.T) {
+		offset, err := f.Seek(-3, io.SeekEnd)
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), offset)
+
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "rld", string(data))
+	})
+
+	t.Run("Write", func(t *testing.T) {
+		_, err := f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("J"))
+		require.NoError(t, err)
+
+		offset, err := f.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), offset)
+
+		require.NoError(t, f.Sync())
+
+		assert.Equal(t, "Jello world", readFile(t, fsys, "seek.txt"))
+	})
+}
+
+func testTruncate(t *testing.T, fsys writablefs.FS, _ Capabilities) {
+	t.Run("Shrink", func(t *testing.T) {
+		writeFile(t, fsys, "shrink.txt", "hello world")
+
+		f, err := fsys.OpenFile("shrink.txt", writablefs.FlagReadWrite)
+		require.NoError(t, err)
+
+		require.NoError(t, f.Truncate(5))
+
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), fi.Size())
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "hello", readFile(t, fsys, "shrink.txt"))
+	})
+
+	t.Run("Extend", func(t *testing.T) {
+		writeFile(t, fsys, "extend.txt", "hello")
+
+		f, err := fsys.OpenFile("extend.txt", writablefs.FlagReadWrite)
+		require.NoError(t, err)
+
+		require.NoError(t, f.Truncate(8))
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "hello\x00\x00\x00", readFile(t, fsys, "extend.txt"))
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		writeFile(t, fsys, "read-only.txt", "hello")
+
+		f, err := fsys.OpenFile("read-only.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+
+		assert.Error(t, f.Truncate(0))
+
+		require.NoError(t, f.Close())
+
+		assert.Equal(t, "hello", readFile(t, fsys, "read-only.txt"))
+	})
+}
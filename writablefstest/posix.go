@@ -0,0 +1,469 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/bucket-sailor/writablefs"
+)
+
+// Result is the outcome of a POSIX compliance case.
+type Result int
+
+const (
+	// ResultPass means the file system behaved as POSIX requires.
+	ResultPass Result = iota
+	// ResultFail means the file system deviated from POSIX.
+	ResultFail
+	// ResultUnsupported means the file system doesn't support an operation
+	// the case depends on (eg. hard links).
+	ResultUnsupported
+)
+
+func (r Result) String() string {
+	switch r {
+	case ResultPass:
+		return "pass"
+	case ResultFail:
+		return "fail"
+	case ResultUnsupported:
+		return "unsupported"
+	default:
+		return fmt.Sprintf("Result(%d)", int(r))
+	}
+}
+
+// CaseResult is the outcome of a single POSIX compliance case.
+type CaseResult struct {
+	// Name of the case, eg. "mkdir/10" (after the original test file).
+	Name        string
+	Description string
+	Result      Result
+	// Detail describes the first step that failed (or was unsupported).
+	Detail string
+	// Privileged cases require privileges on local file systems (eg. to
+	// change the owner of a file to another user), so their result depends on
+	// who runs them. Without privileges they are reported as unsupported.
+	Privileged bool
+}
+
+// Report is the POSIX compliance report of a file system.
+type Report struct {
+	Cases []CaseResult
+}
+
+// Count returns the number of cases with the given result.
+func (r *Report) Count(result Result) int {
+	var n int
+	for _, c := range r.Cases {
+		if c.Result == result {
+			n++
+		}
+	}
+
+	return n
+}
+
+// String returns a summary followed by a line per case. It doesn't include
+// failure details, so that it can be compared against a known baseline.
+func (r *Report) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "# %d cases: %d pass, %d fail, %d unsupported\n",
+		len(r.Cases), r.Count(ResultPass), r.Count(ResultFail), r.Count(ResultUnsupported))
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	for _, c := range r.Cases {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Result, c.Description)
+	}
+	_ = tw.Flush()
+
+	return sb.String()
+}
+
+// TestPOSIX runs a Go port of the zfsonlinux (pjd) Filesystem Test Suite
+// against a file system, through the writablefs interfaces. newFS is called
+// for each case and must return an empty file system, which is closed once
+// the case has finished.
+//
+// Almost no backends will be fully compliant, so failing cases are logged
+// and recorded in the returned report rather than failing the test.
+func TestPOSIX(t *testing.T, newFS func() writablefs.FS) *Report {
+	var report Report
+
+	for _, c := range posixCases {
+		c := c
+
+		t.Run(c.name, func(t *testing.T) {
+			fsys := newFS()
+			defer func() {
+				if err := fsys.Close(); err != nil {
+					t.Errorf("failed to close filesystem: %v", err)
+				}
+			}()
+
+			result, detail := runPOSIXCase(fsys, c)
+
+			report.Cases = append(report.Cases, CaseResult{
+				Name:        c.name,
+				Description: c.description,
+				Result:      result,
+				Detail:      detail,
+				Privileged:  privilegedCases[c.name],
+			})
+
+			switch result {
+			case ResultFail:
+				t.Logf("not compliant: %s", detail)
+			case ResultUnsupported:
+				t.Skipf("unsupported: %s", detail)
+			}
+		})
+	}
+
+	return &report
+}
+
+// posixCase is a case from the original suite, each step is of the form
+// "<expected> <op> <args...>" (like the expect lines of the original). The
+// expected result is "0" for success, one or more errno names separated by
+// "|", or for stat, lstat and readlink the expected output.
+type posixCase struct {
+	name        string
+	description string
+	steps       []string
+}
+
+// errnos maps the errno names used in the cases to the errors that satisfy
+// them.
+var errnos = map[string][]error{
+	"EEXIST":       {writablefs.ErrExist},
+	"EINVAL":       {writablefs.ErrInvalid, syscall.EINVAL},
+	"EISDIR":       {syscall.EISDIR},
+	"ELOOP":        {syscall.ELOOP},
+	"ENAMETOOLONG": {syscall.ENAMETOOLONG},
+	"ENOENT":       {writablefs.ErrNotExist},
+	"ENOTDIR":      {syscall.ENOTDIR},
+	"ENOTEMPTY":    {writablefs.ErrNotEmpty, syscall.ENOTEMPTY},
+	"EPERM":        {writablefs.ErrPermission},
+}
+
+const nameMax = 255
+
+var posixNames = strings.NewReplacer(
+	"${NAME_MAX}", strings.Repeat("x", nameMax),
+	"${NAME_MAX+1}", strings.Repeat("x", nameMax+1),
+)
+
+func runPOSIXCase(fsys writablefs.FS, c posixCase) (Result, string) {
+	for _, step := range c.steps {
+		fields := strings.Fields(posixNames.Replace(step))
+		want, op, args := fields[0], fields[1], fields[2:]
+
+		got, hasOutput, err := runPOSIXOp(fsys, op, args)
+		if errors.Is(err, writablefs.ErrUnsupported) {
+			return ResultUnsupported, fmt.Sprintf("%s: %v", step, err)
+		}
+
+		if privilegedCases[c.name] && errors.Is(err, writablefs.ErrPermission) && os.Geteuid() != 0 {
+			return ResultUnsupported, fmt.Sprintf("%s: requires privileges: %v", step, err)
+		}
+
+		if err := checkPOSIXResult(want, got, hasOutput, err); err != nil {
+			return ResultFail, fmt.Sprintf("%s: %v", step, err)
+		}
+	}
+
+	return ResultPass, ""
+}
+
+func checkPOSIXResult(want, got string, hasOutput bool, err error) error {
+	if isErrno(want) {
+		if err == nil {
+			return fmt.Errorf("expected %s, but succeeded", want)
+		}
+
+		for _, name := range strings.Split(want, "|") {
+			for _, target := range errnos[name] {
+				if errors.Is(err, target) {
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("expected %s, got: %w", want, err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("expected %s, got: %w", want, err)
+	}
+
+	if hasOutput && got != want {
+		return fmt.Errorf("expected %s, got %s", want, got)
+	}
+
+	return nil
+}
+
+func isErrno(s string) bool {
+	for _, name := range strings.Split(s, "|") {
+		if _, ok := errnos[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// runPOSIXOp runs a single operation, returning its output (if it has one).
+func runPOSIXOp(fsys writablefs.FS, op string, args []string) (string, bool, error) {
+	switch op {
+	case "create":
+		// Like the original, create is open(O_CREAT|O_EXCL).
+		return "", false, openClose(fsys, args[0], writablefs.FlagCreate|writablefs.FlagExclusive|writablefs.FlagWriteOnly)
+	case "open":
+		flag, err := parseOpenFlags(args[1])
+		if err != nil {
+			return "", false, err
+		}
+
+		return "", false, openClose(fsys, args[0], flag)
+	case "mkdir":
+		return "", false, writablefs.Mkdir(fsys, args[0])
+	case "rmdir":
+		// writablefs only has Remove, so check the file type like rmdir(2).
+		fi, err := writablefs.Lstat(fsys, args[0])
+		if err != nil {
+			return "", false, err
+		}
+
+		if !fi.IsDir() {
+			return "", false, &fs.PathError{Op: "rmdir", Path: args[0], Err: syscall.ENOTDIR}
+		}
+
+		return "", false, writablefs.Remove(fsys, args[0])
+	case "unlink":
+		fi, err := writablefs.Lstat(fsys, args[0])
+		if err != nil {
+			return "", false, err
+		}
+
+		if fi.IsDir() {
+			return "", false, &fs.PathError{Op: "unlink", Path: args[0], Err: syscall.EISDIR}
+		}
+
+		return "", false, writablefs.Remove(fsys, args[0])
+	case "rename":
+		return "", false, fsys.Rename(args[0], args[1])
+	case "link":
+		// writablefs has no concept of hard links.
+		return "", false, &fs.PathError{Op: "link", Path: args[1], Err: writablefs.ErrUnsupported}
+	case "symlink":
+		return "", false, writablefs.Symlink(fsys, args[0], args[1])
+	case "readlink":
+		target, err := writablefs.ReadLink(fsys, args[0])
+		return target, true, err
+	case "chmod":
+		mode, err := strconv.ParseUint(args[1], 8, 32)
+		if err != nil {
+			return "", false, err
+		}
+
+		return "", false, writablefs.Chmod(fsys, args[0], fromUnixMode(uint32(mode)))
+	case "chown":
+		uid, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", false, err
+		}
+
+		gid, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "", false, err
+		}
+
+		return "", false, writablefs.Chown(fsys, args[0], uid, gid)
+	case "truncate":
+		size, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return "", false, err
+		}
+
+		return "", false, truncate(fsys, args[0], size)
+	case "stat", "lstat":
+		var fi writablefs.FileInfo
+		var err error
+		if op == "stat" {
+			fi, err = fsys.Stat(args[0])
+		} else {
+			fi, err = writablefs.Lstat(fsys, args[0])
+		}
+		if err != nil {
+			return "", true, err
+		}
+
+		output, err := formatFileInfo(fi, args[1])
+		return output, true, err
+	default:
+		return "", false, fmt.Errorf("unknown operation %q", op)
+	}
+}
+
+func openClose(fsys writablefs.FS, path string, flag writablefs.FileOpenFlag) error {
+	f, err := fsys.OpenFile(path, flag)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// truncate is truncate(2), writablefs only supports truncating open files.
+func truncate(fsys writablefs.FS, path string, size int64) error {
+	f, err := fsys.OpenFile(path, writablefs.FlagWriteOnly)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func parseOpenFlags(s string) (writablefs.FileOpenFlag, error) {
+	var flag writablefs.FileOpenFlag
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "O_RDONLY":
+			flag |= writablefs.FlagReadOnly
+		case "O_WRONLY":
+			flag |= writablefs.FlagWriteOnly
+		case "O_RDWR":
+			flag |= writablefs.FlagReadWrite
+		case "O_CREAT":
+			flag |= writablefs.FlagCreate
+		case "O_EXCL":
+			flag |= writablefs.FlagExclusive
+		case "O_TRUNC":
+			flag |= writablefs.FlagTruncate
+		case "O_APPEND":
+			flag |= writablefs.FlagAppend
+		default:
+			return 0, fmt.Errorf("unknown open flag %q", name)
+		}
+	}
+
+	return flag, nil
+}
+
+// formatFileInfo formats the requested (comma separated) fields of a file.
+func formatFileInfo(fi writablefs.FileInfo, fields string) (string, error) {
+	var values []string
+	for _, field := range strings.Split(fields, ",") {
+		switch field {
+		case "type":
+			switch {
+			case fi.Mode().IsDir():
+				values = append(values, "dir")
+			case fi.Mode()&writablefs.ModeSymlink != 0:
+				values = append(values, "symlink")
+			case fi.Mode().IsRegular():
+				values = append(values, "regular")
+			default:
+				values = append(values, "unknown")
+			}
+		case "mode":
+			values = append(values, fmt.Sprintf("0%o", toUnixMode(fi.Mode())))
+		case "size":
+			values = append(values, strconv.FormatInt(fi.Size(), 10))
+		case "uid", "gid":
+			id, ok := ownerOf(fi, field)
+			if !ok {
+				return "", fmt.Errorf("file %s: %w", field, writablefs.ErrUnsupported)
+			}
+
+			values = append(values, strconv.Itoa(id))
+		default:
+			return "", fmt.Errorf("unknown field %q", field)
+		}
+	}
+
+	return strings.Join(values, ","), nil
+}
+
+// ownerOf returns the uid or gid of a file from FileInfo.Sys(). There is no
+// common type for this, so look for a Uid/UID (or Gid/GID) integer field, as
+// used by syscall.Stat_t and s3fs.Stat.
+func ownerOf(fi writablefs.FileInfo, field string) (int, bool) {
+	v := reflect.ValueOf(fi.Sys())
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+
+	for _, name := range []string{strings.ToUpper(field), strings.ToUpper(field[:1]) + field[1:]} {
+		f := v.FieldByName(name)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			return int(f.Int()), true
+		case reflect.Uint32, reflect.Uint64:
+			return int(f.Uint()), true
+		}
+	}
+
+	return 0, false
+}
+
+func toUnixMode(mode writablefs.FileMode) uint32 {
+	unixMode := uint32(mode.Perm())
+
+	if mode&writablefs.ModeSetuid != 0 {
+		unixMode |= 0o4000
+	}
+	if mode&writablefs.ModeSetgid != 0 {
+		unixMode |= 0o2000
+	}
+	if mode&writablefs.ModeSticky != 0 {
+		unixMode |= 0o1000
+	}
+
+	return unixMode
+}
+
+func fromUnixMode(unixMode uint32) writablefs.FileMode {
+	mode := writablefs.FileMode(unixMode & 0o777)
+
+	if unixMode&0o4000 != 0 {
+		mode |= writablefs.ModeSetuid
+	}
+	if unixMode&0o2000 != 0 {
+		mode |= writablefs.ModeSetgid
+	}
+	if unixMode&0o1000 != 0 {
+		mode |= writablefs.ModeSticky
+	}
+
+	return mode
+}
@@ -0,0 +1,483 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+// The cases are ported from https://github.com/zfsonlinux/fstest, and named
+// after the original test files. Cases that require switching users (eg.
+// EACCES) are omitted, as are those for operations writablefs doesn't have
+// (eg. mkfifo).
+// privilegedCases require privileges on local file systems.
+var privilegedCases = map[string]bool{
+	"chown/00/file":    true,
+	"chown/00/dir":     true,
+	"chown/00/symlink": true,
+}
+
+var posixCases = []posixCase{
+	// chmod
+
+	{"chmod/00/file", "changes the permission bits of a file", []string{
+		"0 create n0",
+		"0 chmod n0 0111",
+		"0111 stat n0 mode",
+		"0 chmod n0 0644",
+		"0644 stat n0 mode",
+	}},
+	{"chmod/00/dir", "changes the permission bits of a directory", []string{
+		"0 mkdir n0",
+		"0 chmod n0 0753",
+		"0753 stat n0 mode",
+	}},
+	{"chmod/00/symlink", "follows symbolic links", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"0 chmod n1 0123",
+		"0123 stat n0 mode",
+		"symlink lstat n1 type",
+	}},
+	{"chmod/00/special", "sets the setuid, setgid and sticky bits", []string{
+		"0 create n0",
+		"0 chmod n0 07777",
+		"07777 stat n0 mode",
+	}},
+	{"chmod/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 mkdir n0",
+		"0 create n0/n1",
+		"ENOTDIR chmod n0/n1/test 0644",
+	}},
+	{"chmod/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 create ${NAME_MAX}",
+		"0 chmod ${NAME_MAX} 0620",
+		"0620 stat ${NAME_MAX} mode",
+		"ENAMETOOLONG chmod ${NAME_MAX+1} 0620",
+	}},
+	{"chmod/04", "returns ENOENT if the named file does not exist", []string{
+		"0 mkdir n0",
+		"ENOENT chmod n0/n1/test 0644",
+		"ENOENT chmod n0/n1 0644",
+		"0 symlink n2 n3",
+		"ENOENT chmod n3 0644",
+	}},
+	{"chmod/06", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP chmod n0 0644",
+		"ELOOP chmod n1/test 0644",
+	}},
+
+	// chown
+
+	{"chown/00/file", "changes the owner and group of a file", []string{
+		"0 create n0",
+		"0 chown n0 123 456",
+		"123,456 stat n0 uid,gid",
+		"0 chown n0 -1 789",
+		"123,789 stat n0 uid,gid",
+		"0 chown n0 321 -1",
+		"321,789 stat n0 uid,gid",
+	}},
+	{"chown/00/dir", "changes the owner and group of a directory", []string{
+		"0 mkdir n0",
+		"0 chown n0 123 456",
+		"123,456 stat n0 uid,gid",
+	}},
+	{"chown/00/symlink", "follows symbolic links", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"0 chown n1 123 456",
+		"123,456 stat n0 uid,gid",
+	}},
+	{"chown/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 mkdir n0",
+		"0 create n0/n1",
+		"ENOTDIR chown n0/n1/test 65534 65534",
+	}},
+	{"chown/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 create ${NAME_MAX}",
+		"0 chown ${NAME_MAX} 65534 65534",
+		"65534,65534 stat ${NAME_MAX} uid,gid",
+		"ENAMETOOLONG chown ${NAME_MAX+1} 65533 65533",
+	}},
+	{"chown/04", "returns ENOENT if the named file does not exist", []string{
+		"0 mkdir n0",
+		"ENOENT chown n0/n1/test 65534 65534",
+		"ENOENT chown n0/n1 65534 65534",
+	}},
+	{"chown/06", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP chown n0 65534 65534",
+		"ELOOP chown n1/test 65534 65534",
+	}},
+
+	// link
+
+	{"link/00", "creates hardlinks", []string{
+		"0 create n0",
+		"0 link n0 n1",
+		"regular stat n1 type",
+		"0 unlink n0",
+		"regular stat n1 type",
+	}},
+	{"link/04", "returns ENOENT if the source file does not exist", []string{
+		"ENOENT link n0 n1",
+	}},
+	{"link/10", "returns EEXIST if the destination file does exist", []string{
+		"0 create n0",
+		"0 create n1",
+		"EEXIST link n0 n1",
+	}},
+	{"link/11", "returns EPERM if the source file is a directory", []string{
+		"0 mkdir n0",
+		"EPERM link n0 n1",
+	}},
+
+	// mkdir
+
+	{"mkdir/00", "creates directories", []string{
+		"0 mkdir n0",
+		"dir lstat n0 type",
+		"0 rmdir n0",
+		"ENOENT lstat n0 type",
+		"0 mkdir n0",
+		"0 mkdir n0/n1",
+		"dir stat n0/n1 type",
+	}},
+	{"mkdir/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR mkdir n0/n1",
+	}},
+	{"mkdir/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 mkdir ${NAME_MAX}",
+		"dir stat ${NAME_MAX} type",
+		"ENAMETOOLONG mkdir ${NAME_MAX+1}",
+	}},
+	{"mkdir/04", "returns ENOENT if a component of the path prefix does not exist", []string{
+		"ENOENT mkdir n0/n1",
+	}},
+	{"mkdir/10", "returns EEXIST if the named file exists", []string{
+		"0 mkdir n0",
+		"EEXIST mkdir n0",
+		"0 create n1",
+		"EEXIST mkdir n1",
+		"0 symlink test n2",
+		"EEXIST mkdir n2",
+	}},
+	{"mkdir/12", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP mkdir n0/test",
+	}},
+
+	// open
+
+	{"open/00/create", "creates a regular file with O_CREAT", []string{
+		"0 open n0 O_CREAT,O_WRONLY",
+		"regular,0 stat n0 type,size",
+	}},
+	{"open/00/existing", "opens an existing file with O_CREAT (without O_EXCL)", []string{
+		"0 create n0",
+		"0 truncate n0 5",
+		"0 open n0 O_CREAT,O_RDWR",
+		"5 stat n0 size",
+	}},
+	{"open/00/trunc", "truncates a regular file with O_TRUNC", []string{
+		"0 create n0",
+		"0 truncate n0 123",
+		"123 stat n0 size",
+		"0 open n0 O_WRONLY,O_TRUNC",
+		"0 stat n0 size",
+	}},
+	{"open/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR open n0/n1 O_RDONLY",
+		"ENOTDIR open n0/n1 O_CREAT,O_WRONLY",
+	}},
+	{"open/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 open ${NAME_MAX} O_CREAT,O_WRONLY",
+		"regular stat ${NAME_MAX} type",
+		"ENAMETOOLONG open ${NAME_MAX+1} O_CREAT,O_WRONLY",
+	}},
+	{"open/04", "returns ENOENT if a component of the path name that must exist does not exist", []string{
+		"ENOENT open n0 O_RDONLY",
+		"ENOENT open n0/n1 O_CREAT,O_WRONLY",
+	}},
+	{"open/12", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP open n0 O_RDONLY",
+		"ELOOP open n1/test O_RDONLY",
+	}},
+	{"open/13", "returns EISDIR when opening a directory for writing", []string{
+		"0 mkdir n0",
+		"0 open n0 O_RDONLY",
+		"EISDIR open n0 O_WRONLY",
+		"EISDIR open n0 O_RDWR",
+		"dir stat n0 type",
+	}},
+	{"open/22", "returns EEXIST when O_CREAT and O_EXCL were specified and the file exists", []string{
+		"0 create n0",
+		"EEXIST open n0 O_CREAT,O_EXCL,O_WRONLY",
+		"0 mkdir n1",
+		"EEXIST open n1 O_CREAT,O_EXCL,O_WRONLY",
+		"0 symlink test n2",
+		"EEXIST open n2 O_CREAT,O_EXCL,O_WRONLY",
+	}},
+
+	// rename
+
+	{"rename/00/file", "renames a file", []string{
+		"0 create n0",
+		"0 rename n0 n1",
+		"ENOENT lstat n0 type",
+		"regular lstat n1 type",
+	}},
+	{"rename/00/dir", "renames a directory", []string{
+		"0 mkdir n0",
+		"0 create n0/n2",
+		"0 rename n0 n1",
+		"ENOENT lstat n0 type",
+		"dir lstat n1 type",
+		"regular lstat n1/n2 type",
+	}},
+	{"rename/00/symlink", "renames a symbolic link (not its target)", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"0 rename n1 n2",
+		"regular lstat n0 type",
+		"symlink lstat n2 type",
+		"n0 readlink n2",
+	}},
+	{"rename/00/replace-file", "replaces an existing file", []string{
+		"0 create n0",
+		"0 truncate n0 5",
+		"0 create n1",
+		"0 rename n0 n1",
+		"ENOENT lstat n0 type",
+		"5 stat n1 size",
+	}},
+	{"rename/00/replace-dir", "replaces an existing empty directory", []string{
+		"0 mkdir n0",
+		"0 mkdir n1",
+		"0 rename n0 n1",
+		"ENOENT lstat n0 type",
+		"dir lstat n1 type",
+	}},
+	{"rename/01", "returns ENAMETOOLONG if a component of either pathname exceeded NAME_MAX characters", []string{
+		"0 create n0",
+		"ENAMETOOLONG rename n0 ${NAME_MAX+1}",
+		"regular lstat n0 type",
+	}},
+	{"rename/03", "returns ENOENT if a component of the from path does not exist, or a path prefix of to does not exist", []string{
+		"ENOENT rename n0 n1",
+		"0 create n0",
+		"ENOENT rename n0 n1/n2",
+		"regular lstat n0 type",
+	}},
+	{"rename/12", "returns ENOTDIR if from is a directory, but to is not", []string{
+		"0 mkdir n0",
+		"0 create n1",
+		"ENOTDIR rename n0 n1",
+		"dir lstat n0 type",
+		"regular lstat n1 type",
+	}},
+	{"rename/14", "returns EISDIR if to is a directory, but from is not", []string{
+		"0 create n0",
+		"0 mkdir n1",
+		"EISDIR rename n0 n1",
+		"regular lstat n0 type",
+		"dir lstat n1 type",
+	}},
+	{"rename/20", "returns EEXIST or ENOTEMPTY if to is a directory and is not empty", []string{
+		"0 mkdir n0",
+		"0 mkdir n1",
+		"0 create n1/n2",
+		"ENOTEMPTY|EEXIST rename n0 n1",
+		"dir lstat n0 type",
+		"regular lstat n1/n2 type",
+	}},
+	{"rename/21", "returns EINVAL when an attempt is made to rename a directory into itself", []string{
+		"0 mkdir n0",
+		"EINVAL rename n0 n0/n1",
+		"dir lstat n0 type",
+	}},
+
+	// rmdir
+
+	{"rmdir/00", "removes directories", []string{
+		"0 mkdir n0",
+		"0 rmdir n0",
+		"ENOENT lstat n0 type",
+	}},
+	{"rmdir/01", "returns ENOTDIR if a component of the path is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR rmdir n0",
+		"ENOTDIR rmdir n0/n1",
+		"regular lstat n0 type",
+		"0 mkdir n1",
+		"0 symlink n1 n2",
+		"ENOTDIR rmdir n2",
+		"dir lstat n1 type",
+	}},
+	{"rmdir/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 mkdir ${NAME_MAX}",
+		"0 rmdir ${NAME_MAX}",
+		"ENAMETOOLONG rmdir ${NAME_MAX+1}",
+	}},
+	{"rmdir/04", "returns ENOENT if the named directory does not exist", []string{
+		"ENOENT rmdir n0",
+		"ENOENT rmdir n0/n1",
+	}},
+	{"rmdir/06", "returns EEXIST or ENOTEMPTY if the named directory contains files other than '.' and '..' in it", []string{
+		"0 mkdir n0",
+		"0 create n0/n1",
+		"ENOTEMPTY|EEXIST rmdir n0",
+		"dir lstat n0 type",
+		"regular lstat n0/n1 type",
+	}},
+	{"rmdir/12", "returns EINVAL if the last component of the path is '.'", []string{
+		"0 mkdir n0",
+		"EINVAL rmdir n0/.",
+		"dir lstat n0 type",
+	}},
+
+	// symlink
+
+	{"symlink/00", "creates symbolic links", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"regular stat n1 type",
+		"symlink lstat n1 type",
+		"n0 readlink n1",
+		"0 unlink n0",
+		"ENOENT stat n1 type",
+		"symlink lstat n1 type",
+	}},
+	{"symlink/00/dir", "creates symbolic links to directories", []string{
+		"0 mkdir n0",
+		"0 symlink n0 n1",
+		"dir stat n1 type",
+		"symlink lstat n1 type",
+	}},
+	{"symlink/01", "returns ENOTDIR if a component of the name2 path prefix is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR symlink test n0/n1",
+	}},
+	{"symlink/02", "returns ENAMETOOLONG if a component of the name2 pathname exceeded NAME_MAX characters", []string{
+		"0 symlink test ${NAME_MAX}",
+		"symlink lstat ${NAME_MAX} type",
+		"ENAMETOOLONG symlink test ${NAME_MAX+1}",
+	}},
+	{"symlink/04", "returns ENOENT if a component of the name2 path prefix does not exist", []string{
+		"ENOENT symlink test n0/n1",
+	}},
+	{"symlink/07", "returns ELOOP if too many symbolic links were encountered in translating the name2 path name", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP symlink test n0/n2",
+	}},
+	{"symlink/08", "returns EEXIST if the name2 argument already exists", []string{
+		"0 create n0",
+		"EEXIST symlink test n0",
+		"0 mkdir n1",
+		"EEXIST symlink test n1",
+		"0 symlink test n2",
+		"EEXIST symlink test n2",
+	}},
+
+	// truncate
+
+	{"truncate/00", "truncates a file", []string{
+		"0 create n0",
+		"0 truncate n0 1234567",
+		"1234567 stat n0 size",
+		"0 truncate n0 567",
+		"567 stat n0 size",
+	}},
+	{"truncate/00/symlink", "follows symbolic links", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"0 truncate n1 123",
+		"123 stat n0 size",
+	}},
+	{"truncate/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR truncate n0/n1 123",
+	}},
+	{"truncate/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 create ${NAME_MAX}",
+		"0 truncate ${NAME_MAX} 123",
+		"123 stat ${NAME_MAX} size",
+		"ENAMETOOLONG truncate ${NAME_MAX+1} 123",
+	}},
+	{"truncate/04", "returns ENOENT if the named file does not exist", []string{
+		"ENOENT truncate n0 123",
+		"0 mkdir n0",
+		"ENOENT truncate n0/n1 123",
+	}},
+	{"truncate/11", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP truncate n0 123",
+		"ELOOP truncate n1/test 123",
+	}},
+	{"truncate/13", "returns EISDIR if the named file is a directory", []string{
+		"0 mkdir n0",
+		"EISDIR truncate n0 123",
+		"dir stat n0 type",
+	}},
+	{"truncate/14", "returns EINVAL if the length argument was less than 0", []string{
+		"0 create n0",
+		"EINVAL truncate n0 -1",
+		"0 stat n0 size",
+	}},
+
+	// unlink
+
+	{"unlink/00", "removes regular files", []string{
+		"0 create n0",
+		"0 unlink n0",
+		"ENOENT lstat n0 type",
+	}},
+	{"unlink/00/symlink", "removes symbolic links (not their targets)", []string{
+		"0 create n0",
+		"0 symlink n0 n1",
+		"0 unlink n1",
+		"ENOENT lstat n1 type",
+		"regular lstat n0 type",
+		"0 symlink n2 n3",
+		"0 unlink n3",
+		"ENOENT lstat n3 type",
+	}},
+	{"unlink/01", "returns ENOTDIR if a component of the path prefix is not a directory", []string{
+		"0 create n0",
+		"ENOTDIR unlink n0/n1",
+	}},
+	{"unlink/02", "returns ENAMETOOLONG if a component of a pathname exceeded NAME_MAX characters", []string{
+		"0 create ${NAME_MAX}",
+		"0 unlink ${NAME_MAX}",
+		"ENAMETOOLONG unlink ${NAME_MAX+1}",
+	}},
+	{"unlink/04", "returns ENOENT if the named file does not exist", []string{
+		"ENOENT unlink n0",
+		"0 mkdir n0",
+		"ENOENT unlink n0/n1",
+	}},
+	{"unlink/07", "returns ELOOP if too many symbolic links were encountered in translating the pathname", []string{
+		"0 symlink n0 n1",
+		"0 symlink n1 n0",
+		"ELOOP unlink n0/test",
+	}},
+	{"unlink/11", "returns EISDIR or EPERM if the named file is a directory", []string{
+		"0 mkdir n0",
+		"EISDIR|EPERM unlink n0",
+		"dir lstat n0 type",
+	}},
+}
@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+// Package writablefstest implements support for testing implementations of
+// writable file systems (in the spirit of testing/fstest).
+package writablefstest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Capabilities declares the optional features supported by a file system.
+// Checks that depend on a capability that isn't declared are skipped.
+type Capabilities struct {
+	// XAttrs indicates that files support extended attributes.
+	XAttrs bool
+	// Archive indicates that the file system implements writablefs.ArchiveFS.
+	Archive bool
+	// RenameDirs indicates that directories (not just files) can be renamed.
+	RenameDirs bool
+}
+
+type check func(t *testing.T, fsys writablefs.FS, caps Capabilities)
+
+// TestFS tests a writable file system implementation. newFS is called for
+// each group of checks and must return an empty file system, which is closed
+// once the group has finished.
+func TestFS(t *testing.T, newFS func() writablefs.FS, caps Capabilities) {
+	run := func(name string, check check) {
+		t.Run(name, func(t *testing.T) {
+			fsys := newFS()
+			t.Cleanup(func() {
+				assert.NoError(t, fsys.Close())
+			})
+
+			check(t, fsys, caps)
+		})
+	}
+
+	run("Open Flags", testOpenFlags)
+	run("Read and Write", testReadWrite)
+	run("Seek", testSeek)
+	run("Truncate", testTruncate)
+	run("ReadDir", testReadDir)
+	run("Rename", testRename)
+	run("RemoveAll", testRemoveAll)
+	run("Error Sentinels", testErrors)
+	run("Extended Attributes", testXAttrs)
+	run("Archive", testArchive)
+}
+
+func writeFile(t *testing.T, fsys writablefs.FS, path, contents string) {
+	t.Helper()
+
+	f, err := fsys.OpenFile(path, writablefs.FlagCreate|writablefs.FlagTruncate|writablefs.FlagWriteOnly)
+	require.NoError(t, err)
+
+	_, err = f.Write([]byte(contents))
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+}
+
+func readFile(t *testing.T, fsys writablefs.FS, path string) string {
+	t.Helper()
+
+	f, err := fsys.OpenFile(path, writablefs.FlagReadOnly)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+
+	require.NoError(t, f.Close())
+
+	return string(data)
+}
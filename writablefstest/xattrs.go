@@ -0,0 +1,135 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefstest
+
+import (
+	"testing"
+
+	"github.com/bucket-sailor/writablefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testXAttrs(t *testing.T, fsys writablefs.FS, caps Capabilities) {
+	if !caps.XAttrs {
+		t.Skip("extended attributes not supported by filesystem")
+	}
+
+	openXAttrs := func(t *testing.T, path string) (writablefs.File, writablefs.ExtendedAttributes) {
+		f, err := fsys.OpenFile(path, writablefs.FlagCreate|writablefs.FlagReadWrite)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = f.Close()
+		})
+
+		_, err = f.Write([]byte("just a test"))
+		require.NoError(t, err)
+
+		// Some file systems only support extended attributes on files that
+		// have been persisted.
+		require.NoError(t, f.Sync())
+
+		xattrs, err := f.XAttrs()
+		require.NoError(t, err)
+
+		return f, xattrs
+	}
+
+	t.Run("Set and Get", func(t *testing.T) {
+		_, xattrs := openXAttrs(t, "set-get.txt")
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+		require.NoError(t, xattrs.Set("test-attr2", []byte("test-value2")))
+		require.NoError(t, xattrs.Sync())
+
+		value, err := xattrs.Get("test-attr")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test-value"), value)
+
+		value, err = xattrs.Get("test-attr2")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test-value2"), value)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		_, xattrs := openXAttrs(t, "replace.txt")
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("old")))
+		require.NoError(t, xattrs.Sync())
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("new")))
+		require.NoError(t, xattrs.Sync())
+
+		value, err := xattrs.Get("test-attr")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new"), value)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		_, xattrs := openXAttrs(t, "list.txt")
+
+		names, err := xattrs.List()
+		require.NoError(t, err)
+		assert.Empty(t, names)
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+		require.NoError(t, xattrs.Set("test-attr2", []byte("test-value2")))
+		require.NoError(t, xattrs.Sync())
+
+		names, err = xattrs.List()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"test-attr", "test-attr2"}, names)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		_, xattrs := openXAttrs(t, "remove.txt")
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+		require.NoError(t, xattrs.Set("test-attr2", []byte("test-value2")))
+		require.NoError(t, xattrs.Sync())
+
+		require.NoError(t, xattrs.Remove("test-attr"))
+		require.NoError(t, xattrs.Sync())
+
+		_, err := xattrs.Get("test-attr")
+		assert.ErrorIs(t, err, writablefs.ErrNoSuchAttr)
+
+		value, err := xattrs.Get("test-attr2")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test-value2"), value)
+
+		// Removing a non-existent attribute isn't an error.
+		require.NoError(t, xattrs.Remove("test-attr"))
+		require.NoError(t, xattrs.Sync())
+	})
+
+	t.Run("Persist after Close", func(t *testing.T) {
+		f, xattrs := openXAttrs(t, "persist.txt")
+
+		require.NoError(t, xattrs.Set("test-attr", []byte("test-value")))
+		require.NoError(t, xattrs.Sync())
+
+		require.NoError(t, f.Close())
+
+		f, err := fsys.OpenFile("persist.txt", writablefs.FlagReadOnly)
+		require.NoError(t, err)
+		defer f.Close()
+
+		xattrs, err = f.XAttrs()
+		require.NoError(t, err)
+
+		value, err := xattrs.Get("test-attr")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test-value"), value)
+
+		// Nor should they affect the file contents.
+		assert.Equal(t, "just a test", readFile(t, fsys, "persist.txt"))
+	})
+}
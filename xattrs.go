@@ -0,0 +1,151 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Copyright (c) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package writablefs
+
+import "strings"
+
+// XAttrNamespace is the namespace of an extended attribute. Attributes in the
+// user namespace have unqualified names (eg. "foo"), the names of attributes
+// in other namespaces are prefixed with the namespace (eg. "trusted.foo").
+type XAttrNamespace string
+
+const (
+	// XAttrNamespaceUser is for arbitrary attributes set by users.
+	XAttrNamespaceUser XAttrNamespace = "user"
+	// XAttrNamespaceTrusted is for attributes only accessible to privileged
+	// processes.
+	XAttrNamespaceTrusted XAttrNamespace = "trusted"
+	// XAttrNamespaceSecurity is for attributes used by security modules (eg.
+	// SELinux labels and file capabilities).
+	XAttrNamespaceSecurity XAttrNamespace = "security"
+	// XAttrNamespaceSystem is for attributes used by the kernel (eg. POSIX
+	// ACLs).
+	XAttrNamespaceSystem XAttrNamespace = "system"
+)
+
+// XAttrName returns the name to use for the extended attribute name in the
+// given namespace (names in the user namespace are returned as is).
+func XAttrName(namespace XAttrNamespace, name string) string {
+	if namespace == XAttrNamespaceUser {
+		return name
+	}
+
+	return string(namespace) + "." + name
+}
+
+// SplitXAttrName returns the namespace of an extended attribute name, and its
+// name within that namespace. Names without a trusted, security or system
+// prefix are in the user namespace (including those prefixed with "user.").
+func SplitXAttrName(name string) (XAttrNamespace, string) {
+	for _, namespace := range []XAttrNamespace{XAttrNamespaceTrusted, XAttrNamespaceSecurity, XAttrNamespaceSystem} {
+		if attr, ok := strings.CutPrefix(name, string(namespace)+"."); ok && attr != "" {
+			return namespace, attr
+		}
+	}
+
+	return XAttrNamespaceUser, name
+}
+
+// GetXAttr returns the value of the named extended attribute of the file at
+// path. If the file system does not implement XAttrFS, the file is opened
+// and File.XAttrs() is used instead.
+func GetXAttr(fsys FS, path, name string) ([]byte, error) {
+	fsys, path = resolve(fsys, path)
+
+	if xattrFsys, ok := fsys.(XAttrFS); ok {
+		return xattrFsys.GetXAttr(path, name)
+	}
+
+	var value []byte
+	err := withXAttrs(fsys, path, FlagReadOnly, func(xattrs ExtendedAttributes) (err error) {
+		value, err = xattrs.Get(name)
+		return err
+	})
+
+	return value, err
+}
+
+// SetXAttr sets the value of the named extended attribute of the file at
+// path. If the file system does not implement XAttrFS, the file is opened
+// and File.XAttrs() is used instead.
+func SetXAttr(fsys FS, path, name string, data []byte) error {
+	fsys, path = resolve(fsys, path)
+
+	if xattrFsys, ok := fsys.(XAttrFS); ok {
+		return xattrFsys.SetXAttr(path, name, data)
+	}
+
+	return withXAttrs(fsys, path, FlagReadWrite, func(xattrs ExtendedAttributes) error {
+		if err := xattrs.Set(name, data); err != nil {
+			return err
+		}
+
+		return xattrs.Sync()
+	})
+}
+
+// RemoveXAttr removes the named extended attribute of the file at path. If
+// the file system does not implement XAttrFS, the file is opened and
+// File.XAttrs() is used instead.
+func RemoveXAttr(fsys FS, path, name string) error {
+	fsys, path = resolve(fsys, path)
+
+	if xattrFsys, ok := fsys.(XAttrFS); ok {
+		return xattrFsys.RemoveXAttr(path, name)
+	}
+
+	return withXAttrs(fsys, path, FlagReadWrite, func(xattrs ExtendedAttributes) error {
+		if err := xattrs.Remove(name); err != nil {
+			return err
+		}
+
+		return xattrs.Sync()
+	})
+}
+
+// ListXAttrs returns the names of all the extended attributes of the file at
+// path. If the file system does not implement XAttrFS, the file is opened
+// and File.XAttrs() is used instead.
+func ListXAttrs(fsys FS, path string) ([]string, error) {
+	fsys, path = resolve(fsys, path)
+
+	if xattrFsys, ok := fsys.(XAttrFS); ok {
+		return xattrFsys.ListXAttrs(path)
+	}
+
+	var names []string
+	err := withXAttrs(fsys, path, FlagReadOnly, func(xattrs ExtendedAttributes) (err error) {
+		names, err = xattrs.List()
+		return err
+	})
+
+	return names, err
+}
+
+// withXAttrs opens the file and calls fn with its extended attributes.
+func withXAttrs(fsys FS, path string, flag FileOpenFlag, fn func(xattrs ExtendedAttributes) error) error {
+	f, err := fsys.OpenFile(path, flag)
+	if err != nil {
+		return err
+	}
+
+	xattrs, err := f.XAttrs()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := fn(xattrs); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}